@@ -3,39 +3,197 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"net/url"
+	"net/netip"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 
+	"github.com/AntonKhPI2/self-learning-classifier/internal/auth"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/config"
 	"github.com/AntonKhPI2/self-learning-classifier/internal/handler"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/migrate"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/ratelimit"
 	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
 )
 
-func envOr(k, def string) string {
-	if v := os.Getenv(k); v != "" {
-		return v
+// openStore picks the backend from DSN (mysql://, memory:// or file://).
+// Without DSN it falls back to MySQL configured through the DB_* variables
+// and STORAGE_MODEL. SQL backends must have every migration applied.
+func openStore(dsn string) (repository.Repository, error) {
+	opts := repository.Options{Timeouts: repository.Timeouts{
+		Read:  config.Duration("DB_READ_TIMEOUT", repository.DefaultTimeouts.Read),
+		Write: config.Duration("DB_WRITE_TIMEOUT", repository.DefaultTimeouts.Write),
+	}}
+
+	var (
+		repo repository.Repository
+		err  error
+	)
+	if dsn != "" {
+		repo, err = repository.Open(dsn, opts)
+	} else {
+		repo, err = repository.OpenMySQL(config.MySQLDSN(), config.Env("STORAGE_MODEL", "json"), opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sb, ok := repo.(repository.SQLBackend)
+	if !ok {
+		log.Printf("using %T storage", repo)
+		return repo, nil
+	}
+	migrator, err := migrate.New(sb.SQLDB(), sb.Dialect())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := migrator.Check(ctx); err != nil {
+		return nil, fmt.Errorf("schema check failed: %w (run `migrate up`)", err)
+	}
+	return repo, nil
+}
+
+// startJanitor removes workspaces without a write for RETENTION_ANONYMOUS_TTL
+// (visitors without an account) or RETENTION_AUTHENTICATED_TTL (signed-in
+// users), checking every RETENTION_INTERVAL in batches of RETENTION_BATCH_SIZE.
+// With RETENTION_DRY_RUN it only logs what it would remove, and with
+// RETENTION_ARCHIVE it appends each removed workspace to that file first.
+func startJanitor(ctx context.Context, repo repository.Repository) error {
+	r := service.Retention{
+		Anonymous:     config.Duration("RETENTION_ANONYMOUS_TTL", 90*24*time.Hour),
+		Authenticated: config.Duration("RETENTION_AUTHENTICATED_TTL", 0),
+		BatchSize:     config.Int("RETENTION_BATCH_SIZE", service.DefaultRetentionBatch),
+		DryRun:        config.Bool("RETENTION_DRY_RUN", false),
+	}
+	if r.Anonymous <= 0 && r.Authenticated <= 0 {
+		return nil
+	}
+	if path := os.Getenv("RETENTION_ARCHIVE"); path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return err
+		}
+		r.Archive = f
 	}
-	return def
+	interval := config.Duration("RETENTION_INTERVAL", time.Hour)
+	go service.NewJanitor(service.NewWorkspaces(repo), r, interval).Run(ctx)
+	log.Printf("retention enabled: anonymous=%s authenticated=%s interval=%s dry-run=%t",
+		r.Anonymous, r.Authenticated, interval, r.DryRun)
+	return nil
 }
 
-func buildDSNFromParts() string {
-	user := envOr("DB_USER", "root")
-	pass := os.Getenv("DB_PASSWORD")
-	host := envOr("DB_HOST", "127.0.0.1")
-	port := envOr("DB_PORT", "3306")
-	name := envOr("DB_NAME", "slc")
+// handlerOptions reads how callers are identified and where they may call
+// from. Bearer tokens are enabled by AUTH_TOKEN_SECRET; trusting
+// USER_ID_HEADER must be switched on explicitly. Anonymous session cookies
+// are signed with ANON_COOKIE_SECRET, which is required unless
+// AUTH_REQUIRED turns them off or ANON_COOKIE_INSECURE_EPHEMERAL accepts a
+// per-process secret. Pages on CSRF_TRUSTED_ORIGINS (by default the CORS
+// origins) may post with them. Each caller gets
+// RATE_LIMIT_READS and RATE_LIMIT_WRITES per RATE_LIMIT_WINDOW on each replica;
+// callers without an account are told apart by address, which is taken from
+// X-Forwarded-For on requests from TRUSTED_PROXIES.
+// QUOTA_* caps what each classifier and user may store, and MAX_BODY_BYTES
+// and MAX_JSON_* bound request bodies.
+func handlerOptions() (handler.Options, error) {
+	var opts handler.Options
+	if secrets := os.Getenv("AUTH_TOKEN_SECRET"); secrets != "" {
+		v, err := auth.NewVerifier(auth.ParseSecrets(secrets), os.Getenv("AUTH_TOKEN_ISSUER"), os.Getenv("AUTH_TOKEN_AUDIENCE"))
+		if err != nil {
+			return opts, err
+		}
+		opts.Tokens = v
+	}
+	if config.Bool("TRUST_USER_ID_HEADER", false) {
+		opts.TrustedUserHeader = config.Env("USER_ID_HEADER", "X-User-ID")
+		log.Printf("trusting the %s header as the user ID; only run this behind an authenticating proxy", opts.TrustedUserHeader)
+	}
+	opts.RequireAuth = config.Bool("AUTH_REQUIRED", false)
+
+	opts.AnonCookieName = config.Env("ANON_COOKIE_NAME", handler.DefaultAnonCookieName)
+	ttl := config.Duration("ANON_COOKIE_TTL", auth.DefaultSessionTTL)
+	if secrets := os.Getenv("ANON_COOKIE_SECRET"); secrets != "" {
+		s, err := auth.NewSessions(auth.ParseSecrets(secrets), ttl)
+		if err != nil {
+			return opts, err
+		}
+		opts.Sessions = s
+	} else {
+		if !opts.RequireAuth && !config.Bool("ANON_COOKIE_INSECURE_EPHEMERAL", false) {
+			return opts, fmt.Errorf("ANON_COOKIE_SECRET is required; set ANON_COOKIE_INSECURE_EPHEMERAL=true to sign sessions with a secret that changes on restart")
+		}
+		if !opts.RequireAuth {
+			log.Printf("ANON_COOKIE_SECRET is not set; anonymous sessions end on restart and are not shared between replicas")
+		}
+		opts.Sessions = auth.NewRandomSessions(ttl)
+	}
+	opts.CORS = corsPolicy()
+	opts.TrustedOrigins = config.List("CSRF_TRUSTED_ORIGINS")
+	if len(opts.TrustedOrigins) == 0 {
+		for _, o := range opts.CORS.Origins {
+			if o != "*" {
+				opts.TrustedOrigins = append(opts.TrustedOrigins, o)
+			}
+		}
+	}
+	period := config.Duration("RATE_LIMIT_WINDOW", time.Minute)
+	if n := config.Int("RATE_LIMIT_READS", 600); n > 0 && period > 0 {
+		opts.ReadLimit = ratelimit.New(n, period)
+	}
+	if n := config.Int("RATE_LIMIT_WRITES", 120); n > 0 && period > 0 {
+		opts.WriteLimit = ratelimit.New(n, period)
+	}
+	for _, p := range config.List("TRUSTED_PROXIES") {
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(p)
+			if aerr != nil {
+				return opts, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		opts.TrustedProxies = append(opts.TrustedProxies, prefix)
+	}
+	opts.Quotas = service.Quotas{
+		PropertiesPerArea: config.Int("QUOTA_PROPERTIES_PER_AREA", service.DefaultQuotas.PropertiesPerArea),
+		Vocabulary:        config.Int("QUOTA_VOCABULARY", service.DefaultQuotas.Vocabulary),
+		PropertyLength:    config.Int("QUOTA_PROPERTY_LENGTH", service.DefaultQuotas.PropertyLength),
+		WorkspacesPerUser: config.Int("QUOTA_WORKSPACES_PER_USER", service.DefaultQuotas.WorkspacesPerUser),
+	}
+	opts.Body = handler.BodyLimits{
+		MaxBytes: int64(config.Int("MAX_BODY_BYTES", handler.DefaultMaxBodyBytes)),
+		MaxDepth: config.Int("MAX_JSON_DEPTH", handler.DefaultMaxJSONDepth),
+		MaxArray: config.Int("MAX_JSON_ARRAY", handler.DefaultMaxJSONArray),
+	}
+	if opts.RequireAuth && opts.Tokens == nil && opts.TrustedUserHeader == "" {
+		return opts, fmt.Errorf("AUTH_REQUIRED needs AUTH_TOKEN_SECRET or TRUST_USER_ID_HEADER")
+	}
+	return opts, nil
+}
 
-	if sock := os.Getenv("DB_SOCKET"); sock != "" {
-		return fmt.Sprintf("%s:%s@unix(%s)/%s?parseTime=true&charset=utf8mb4&loc=Local",
-			user, url.QueryEscape(pass), sock, name)
+// corsPolicy reads CORS_ALLOWED_ORIGINS, or the older single ALLOWED_ORIGIN,
+// and allows any origin without credentials when neither is set.
+func corsPolicy() handler.CORSPolicy {
+	origins := config.List("CORS_ALLOWED_ORIGINS")
+	if len(origins) == 0 {
+		origins = config.List("ALLOWED_ORIGIN")
+	}
+	if len(origins) == 0 {
+		origins = []string{"*"}
+	}
+	return handler.CORSPolicy{
+		Origins:     origins,
+		Credentials: config.Bool("CORS_ALLOW_CREDENTIALS", true),
+		MaxAge:      config.Duration("CORS_MAX_AGE", handler.DefaultCORSMaxAge),
 	}
-	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true&charset=utf8mb4&loc=Local",
-		user, url.QueryEscape(pass), host, port, name)
 }
 
 func main() {
@@ -47,22 +205,44 @@ func main() {
 	}
 	addr := ":" + port
 
-	dsn := os.Getenv("MYSQL_DSN")
-	if dsn == "" {
-		dsn = buildDSNFromParts()
+	opts, err := handlerOptions()
+	if err != nil {
+		log.Fatalf("auth: %v", err)
 	}
-	repo, err := repository.New(dsn)
+
+	store, err := openStore(os.Getenv("DSN"))
 	if err != nil {
-		log.Fatalf("mysql connect error: %v", err)
+		log.Fatalf("storage: %v", err)
+	}
+
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	var repo repository.Repository = store
+	if size := config.Int("STATE_CACHE_SIZE", 1024); size > 0 {
+		ttl := config.Duration("STATE_CACHE_TTL", 2*time.Second)
+		cached := repository.NewCached(store, size, ttl)
+		repo = cached
+
+		if cl, ok := store.(repository.ChangeLog); ok {
+			interval := config.Duration("STATE_CACHE_SYNC_INTERVAL", time.Second)
+			inv := repository.NewInvalidator(cached, cl, interval)
+			go inv.Run(bgCtx)
+			log.Printf("state cache enabled: size=%d ttl=%s sync=%s", size, ttl, interval)
+		} else {
+			log.Printf("state cache enabled: size=%d ttl=%s", size, ttl)
+		}
+	}
+
+	if err := startJanitor(bgCtx, repo); err != nil {
+		log.Fatalf("retention: %v", err)
 	}
 
-	mux := handler.NewHTTPMux(repo)
-	allowedOrigin := envOr("ALLOWED_ORIGIN", "*")
-	corsMiddleware := handler.CORS(allowedOrigin)
+	mux := handler.NewHTTPMux(repo, opts)
 
 	srv := &http.Server{
 		Addr:              addr,
-		Handler:           corsMiddleware(mux),
+		Handler:           mux,
 		ReadTimeout:       10 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
 		WriteTimeout:      15 * time.Second,
@@ -77,10 +257,12 @@ func main() {
 	}()
 
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
+	// Docker and Kubernetes stop containers with SIGTERM.
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	<-stop
 
 	log.Println("↘ shutting down...")
+	stopBackground()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -92,5 +274,11 @@ func main() {
 		}
 	}
 
+	if c, ok := store.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("storage close error: %v", err)
+		}
+	}
+
 	log.Println("✓ server stopped")
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/config"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/migrate"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: migrate [flags] <command>
+
+commands:
+  up        apply all pending migrations
+  status    list migrations and whether they are applied
+  convert   copy user_state JSON rows into the normalized tables
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	_ = godotenv.Load()
+
+	timeout := flag.Duration("timeout", 5*time.Minute, "overall deadline for the command")
+	batch := flag.Int("batch", 500, "rows per batch for convert")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	driver, dialect, driverDSN := "mysql", "mysql", config.MySQLDSN()
+	if dsn := os.Getenv("DSN"); dsn != "" {
+		switch {
+		case strings.HasPrefix(dsn, "mysql:"):
+			d, _, err := repository.MySQLDriverDSN(dsn)
+			if err != nil {
+				log.Fatalf("DSN: %v", err)
+			}
+			driverDSN = d
+		case strings.HasPrefix(dsn, "postgres:"), strings.HasPrefix(dsn, "postgresql:"):
+			driver, dialect, driverDSN = "postgres", "postgres", dsn
+		default:
+			log.Printf("DSN %q does not use a SQL backend; nothing to migrate", dsn)
+			return
+		}
+	}
+
+	db, err := sql.Open(driver, driverDSN)
+	if err != nil {
+		log.Fatalf("%s open error: %v", driver, err)
+	}
+	defer db.Close()
+
+	m, err := migrate.New(db, dialect)
+	if err != nil {
+		log.Fatalf("migrations: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch flag.Arg(0) {
+	case "up":
+		done, err := m.Up(ctx)
+		for _, mg := range done {
+			log.Printf("applied %04d_%s", mg.Version, mg.Name)
+		}
+		if err != nil {
+			log.Fatalf("migrate up: %v", err)
+		}
+		if len(done) == 0 {
+			log.Println("schema is up to date")
+		}
+	case "status":
+		sts, err := m.Status(ctx)
+		if err != nil {
+			log.Fatalf("migrate status: %v", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, st := range sts {
+			at := "pending"
+			if st.Applied {
+				at = st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", st.Version, st.Name, at)
+		}
+		_ = tw.Flush()
+	case "convert":
+		if dialect != "mysql" {
+			log.Fatalf("convert: only the mysql backend has a normalized model")
+		}
+		if err := m.Check(ctx); err != nil {
+			log.Fatalf("convert: %v (run `migrate up` first)", err)
+		}
+		src := &repository.MySQLRepo{DB: db}
+		dst := repository.NewNormalized(db)
+		n, err := repository.ConvertToNormalized(ctx, src, dst, *batch)
+		log.Printf("converted %d user states", n)
+		if err != nil {
+			log.Fatalf("convert: %v", err)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
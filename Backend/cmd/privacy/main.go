@@ -0,0 +1,137 @@
+// Command privacy answers data requests for one user ID: export writes
+// everything stored about the user as JSON, and erase deletes it and prints
+// a report whose digest matches the export taken just before.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/config"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/migrate"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: privacy -user <id> [flags] <command>
+
+commands:
+  export    write everything stored about the user as JSON
+  erase     delete it all and print a report (needs -yes)
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	_ = godotenv.Load()
+
+	user := flag.String("user", "", "user ID the request is about (required)")
+	out := flag.String("o", "", "export: file to write instead of stdout")
+	archive := flag.String("archive", "", "erase: file to write the export to before erasing")
+	yes := flag.Bool("yes", false, "erase: really delete; without it only the counts are shown")
+	timeout := flag.Duration("timeout", 5*time.Minute, "overall deadline for the command")
+	flag.Usage = usage
+	flag.Parse()
+	if *user == "" || flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	repo, err := openStore(ctx)
+	if err != nil {
+		log.Fatalf("storage: %v", err)
+	}
+	ws := service.NewWorkspaces(repo)
+	data, err := ws.Export(ctx, *user)
+	if err != nil {
+		log.Fatalf("export: %v", err)
+	}
+
+	switch flag.Arg(0) {
+	case "export":
+		if err := writeExport(*out, data); err != nil {
+			log.Fatalf("export: %v", err)
+		}
+	case "erase":
+		if !*yes {
+			c := data.Counts()
+			log.Printf("would erase %d workspaces, %d grants from others, %d API keys, %d legacy states and %d audit entries of %q; rerun with -yes",
+				c.Workspaces, c.SharedWithMe, c.APIKeys, c.LegacyStates, c.AuditEntries, *user)
+			return
+		}
+		if *archive != "" {
+			if err := writeExport(*archive, data); err != nil {
+				log.Fatalf("archive: %v", err)
+			}
+		}
+		rep, err := ws.Erase(ctx, data)
+		if err != nil {
+			log.Fatalf("erase: %v", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(rep)
+		if !rep.Verified {
+			log.Fatalf("erase: data was left behind; run erase again")
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// writeExport writes the export exactly as it was digested, so the file's
+// SHA-256 matches an erasure report.
+func writeExport(path string, data service.UserData) error {
+	b, err := data.Encode()
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// openStore connects like the API server does, through DSN or the DB_*
+// variables and STORAGE_MODEL, and refuses a schema with pending migrations.
+func openStore(ctx context.Context) (repository.Repository, error) {
+	var (
+		repo repository.Repository
+		err  error
+	)
+	if dsn := os.Getenv("DSN"); dsn != "" {
+		repo, err = repository.Open(dsn, repository.Options{})
+	} else {
+		repo, err = repository.OpenMySQL(config.MySQLDSN(), config.Env("STORAGE_MODEL", "json"), repository.Options{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	sb, ok := repo.(repository.SQLBackend)
+	if !ok {
+		return repo, nil
+	}
+	m, err := migrate.New(sb.SQLDB(), sb.Dialect())
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Check(ctx); err != nil {
+		return nil, fmt.Errorf("%w (run `migrate up`)", err)
+	}
+	return repo, nil
+}
@@ -0,0 +1,39 @@
+// Command token issues a bearer token signed with AUTH_TOKEN_SECRET, for
+// local development and for services that cannot run their own issuer.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/auth"
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	sub := flag.String("sub", "", "user ID to issue the token for (required)")
+	ttl := flag.Duration("ttl", time.Hour, "how long the token stays valid")
+	flag.Parse()
+	if *sub == "" || flag.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: token -sub <user id> [-ttl 1h]")
+		os.Exit(2)
+	}
+
+	v, err := auth.NewVerifier(auth.ParseSecrets(os.Getenv("AUTH_TOKEN_SECRET")),
+		os.Getenv("AUTH_TOKEN_ISSUER"), os.Getenv("AUTH_TOKEN_AUDIENCE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	now := time.Now()
+	tok, err := v.Sign(auth.Claims{Subject: *sub, IssuedAt: now.Unix(), ExpiresAt: now.Add(*ttl).Unix()})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(tok)
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSessionTTL is how long an anonymous session lasts without a visit.
+const DefaultSessionTTL = 90 * 24 * time.Hour
+
+const (
+	sessionVersion = "v1"
+	// anonPrefix starts the IDs New issues.
+	anonPrefix = "anon_"
+)
+
+var ErrInvalidSession = errors.New("invalid session")
+
+// Sessions issues and checks the signed anonymous session IDs carried in the
+// visitor's cookie. A value is "v1.<id>.<issued unix>.<hmac>"; the first
+// secret signs and every secret verifies, like Verifier.
+type Sessions struct {
+	secrets [][]byte
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+func NewSessions(secrets [][]byte, ttl time.Duration) (*Sessions, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("auth: no session secret configured")
+	}
+	for i, s := range secrets {
+		if len(s) < MinSecretLen {
+			return nil, fmt.Errorf("auth: session secret %d is shorter than %d bytes", i+1, MinSecretLen)
+		}
+	}
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	return &Sessions{secrets: secrets, ttl: ttl, now: time.Now}, nil
+}
+
+// NewRandomSessions signs with a secret that lives only as long as the
+// process, so sessions end on restart and are not shared between replicas.
+func NewRandomSessions(ttl time.Duration) *Sessions {
+	b := make([]byte, MinSecretLen)
+	_, _ = rand.Read(b)
+	s, _ := NewSessions([][]byte{b}, ttl)
+	return s
+}
+
+func (s *Sessions) TTL() time.Duration { return s.ttl }
+
+// New starts a session for a fresh random ID. The prefix keeps new IDs apart
+// from the bare hex IDs of unsigned cookies, see LegacySessionID.
+func (s *Sessions) New() (id, value string) {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	id = anonPrefix + hex.EncodeToString(b)
+	return id, s.Sign(id)
+}
+
+// Sign returns the cookie value for id, issued now.
+func (s *Sessions) Sign(id string) string {
+	payload := sessionVersion + "." + id + "." + strconv.FormatInt(s.now().Unix(), 10)
+	return payload + "." + encode(mac(s.secrets[0], payload))
+}
+
+// Verify returns the session ID in value and whether the session is past half
+// its lifetime and should be re-issued. Unsigned, forged and expired values
+// fail with ErrInvalidSession.
+func (s *Sessions) Verify(value string) (id string, renew bool, err error) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return "", false, ErrInvalidSession
+	}
+	payload := value[:i]
+	sig, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+	if err != nil || !s.validMAC(payload, sig) {
+		return "", false, ErrInvalidSession
+	}
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 || parts[0] != sessionVersion || parts[1] == "" {
+		return "", false, ErrInvalidSession
+	}
+	issued, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", false, ErrInvalidSession
+	}
+	age := s.now().Sub(time.Unix(issued, 0))
+	if age >= s.ttl {
+		return "", false, fmt.Errorf("%w: expired", ErrInvalidSession)
+	}
+	return parts[1], age >= s.ttl/2, nil
+}
+
+// SessionIssued returns when a value that Verify accepted was signed.
+func SessionIssued(value string) time.Time {
+	parts := strings.Split(value, ".")
+	if len(parts) != 4 {
+		return time.Time{}
+	}
+	issued, _ := strconv.ParseInt(parts[2], 10, 64)
+	return time.Unix(issued, 0)
+}
+
+// LegacySessionID returns the session ID in a cookie value issued before
+// session cookies were signed, which was the bare 32-digit hex ID.
+func LegacySessionID(value string) (string, bool) {
+	if len(value) != 32 || strings.Trim(value, "0123456789abcdef") != "" {
+		return "", false
+	}
+	return value, true
+}
+
+// AnonymousID reports whether a user ID is an anonymous session's, issued by
+// New or carried by an unsigned cookie, rather than an account's.
+func AnonymousID(id string) bool {
+	_, legacy := LegacySessionID(id)
+	return legacy || strings.HasPrefix(id, anonPrefix)
+}
+
+func (s *Sessions) validMAC(payload string, sig []byte) bool {
+	v := Verifier{secrets: s.secrets}
+	return v.validMAC(payload, sig)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessions_SignVerify(t *testing.T) {
+	s, err := NewSessions([][]byte{oldSecret}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	s.now = func() time.Time { return now }
+
+	id, value := s.New()
+	got, renew, err := s.Verify(value)
+	if err != nil || got != id || renew {
+		t.Fatalf("Verify=%q,%v,%v; want %q", got, renew, err, id)
+	}
+	if issued := SessionIssued(value); !issued.Equal(now) {
+		t.Fatalf("SessionIssued=%v; want %v", issued, now)
+	}
+
+	now = now.Add(31 * time.Minute)
+	if _, renew, err := s.Verify(value); err != nil || !renew {
+		t.Fatalf("past half its lifetime: renew=%v err=%v", renew, err)
+	}
+	now = now.Add(30 * time.Minute)
+	if _, _, err := s.Verify(value); !errors.Is(err, ErrInvalidSession) {
+		t.Fatalf("expired: err=%v", err)
+	}
+}
+
+func TestSessions_Rejects(t *testing.T) {
+	s, _ := NewSessions([][]byte{oldSecret}, time.Hour)
+	id, value := s.New()
+	other, _ := NewSessions([][]byte{newSecret}, time.Hour)
+
+	cases := map[string]string{
+		"unsigned":     id,
+		"other secret": other.Sign(id),
+		"swapped id":   strings.Replace(value, id, strings.Repeat("0", len(id)), 1),
+		"bad base64":   value + "!",
+		"empty":        "",
+	}
+	for name, v := range cases {
+		if _, _, err := s.Verify(v); !errors.Is(err, ErrInvalidSession) {
+			t.Errorf("%s: err=%v; want ErrInvalidSession", name, err)
+		}
+	}
+}
+
+func TestSessions_Rotation(t *testing.T) {
+	old, _ := NewSessions([][]byte{oldSecret}, time.Hour)
+	id, value := old.New()
+
+	rotated, _ := NewSessions([][]byte{newSecret, oldSecret}, time.Hour)
+	if got, _, err := rotated.Verify(value); err != nil || got != id {
+		t.Fatalf("cookie signed with the previous secret: id=%q err=%v", got, err)
+	}
+	if _, _, err := old.Verify(rotated.Sign(id)); err == nil {
+		t.Fatalf("new cookies must be signed with the first secret")
+	}
+}
+
+func TestLegacySessionID(t *testing.T) {
+	s, _ := NewSessions([][]byte{oldSecret}, time.Hour)
+	id, value := s.New()
+	for _, v := range []string{id, value, strings.Repeat("A", 32), strings.Repeat("0", 31)} {
+		if _, ok := LegacySessionID(v); ok {
+			t.Errorf("LegacySessionID(%q) accepted", v)
+		}
+	}
+	legacy := "0123456789abcdef0123456789abcdef"
+	if got, ok := LegacySessionID(legacy); !ok || got != legacy {
+		t.Fatalf("LegacySessionID(%q)=%q,%v", legacy, got, ok)
+	}
+	for v, want := range map[string]bool{id: true, legacy: true, "user-1": false, strings.Repeat("A", 32): false} {
+		if got := AnonymousID(v); got != want {
+			t.Errorf("AnonymousID(%q)=%v; want %v", v, got, want)
+		}
+	}
+}
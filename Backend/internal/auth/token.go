@@ -0,0 +1,201 @@
+// Package auth verifies the HMAC-signed bearer tokens that identify API
+// callers. Tokens are compact JWTs signed with HS256 and checked locally; no
+// identity provider is contacted.
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MinSecretLen is the shortest accepted signing secret, the HS256 key size.
+const MinSecretLen = 32
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the registered JWT claims the API relies on. The subject is the
+// caller's user ID.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+}
+
+// Audience accepts both forms the JWT spec allows: a string or a list.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*a = Audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a Audience) contains(v string) bool {
+	for _, s := range a {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Verifier checks token signatures and claims. The first secret signs; every
+// secret verifies, so a new secret can be rolled out before the old one is
+// retired.
+type Verifier struct {
+	secrets  [][]byte
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// NewVerifier returns a verifier for the given secrets. Issuer and audience
+// are checked only when non-empty.
+func NewVerifier(secrets [][]byte, issuer, audience string) (*Verifier, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("auth: no token secret configured")
+	}
+	for i, s := range secrets {
+		if len(s) < MinSecretLen {
+			return nil, fmt.Errorf("auth: token secret %d is shorter than %d bytes", i+1, MinSecretLen)
+		}
+	}
+	return &Verifier{
+		secrets:  secrets,
+		issuer:   issuer,
+		audience: audience,
+		leeway:   30 * time.Second,
+		now:      time.Now,
+	}, nil
+}
+
+// Sign issues a token for the claims, filling in the issuer and audience the
+// verifier expects when they are unset.
+func (v *Verifier) Sign(c Claims) (string, error) {
+	if c.Issuer == "" {
+		c.Issuer = v.issuer
+	}
+	if len(c.Audience) == 0 && v.audience != "" {
+		c.Audience = Audience{v.audience}
+	}
+	h, err := json.Marshal(header{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := encode(h) + "." + encode(p)
+	return signed + "." + encode(mac(v.secrets[0], signed)), nil
+}
+
+// Verify checks the token and returns its claims. Every failure wraps
+// ErrInvalidToken.
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var h header
+	if err := decode(parts[0], &h); err != nil || h.Alg != "HS256" {
+		return Claims{}, fmt.Errorf("%w: unsupported header", ErrInvalidToken)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	signed := parts[0] + "." + parts[1]
+	if !v.validMAC(signed, sig) {
+		return Claims{}, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var c Claims
+	if err := decode(parts[1], &c); err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	now := v.now()
+	switch {
+	case c.Subject == "":
+		return Claims{}, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	case c.ExpiresAt == 0:
+		return Claims{}, fmt.Errorf("%w: missing expiry", ErrInvalidToken)
+	case now.After(time.Unix(c.ExpiresAt, 0).Add(v.leeway)):
+		return Claims{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case c.NotBefore != 0 && now.Add(v.leeway).Before(time.Unix(c.NotBefore, 0)):
+		return Claims{}, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	case v.issuer != "" && c.Issuer != v.issuer:
+		return Claims{}, fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	case v.audience != "" && !c.Audience.contains(v.audience):
+		return Claims{}, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+	return c, nil
+}
+
+func (v *Verifier) validMAC(signed string, sig []byte) bool {
+	ok := false
+	for _, s := range v.secrets {
+		// Check every secret so timing does not reveal which one matched.
+		if hmac.Equal(sig, mac(s, signed)) {
+			ok = true
+		}
+	}
+	return ok
+}
+
+func mac(secret []byte, signed string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(signed))
+	return m.Sum(nil)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// ParseSecrets splits a comma-separated secret list, as read from the
+// environment.
+func ParseSecrets(s string) [][]byte {
+	var out [][]byte
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, []byte(part))
+		}
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	oldSecret = []byte("0123456789abcdef0123456789abcdef")
+	newSecret = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func TestVerifier_RoundTrip(t *testing.T) {
+	v, err := NewVerifier([][]byte{oldSecret}, "slc", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := v.Sign(Claims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := v.Verify(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != "alice" || c.Issuer != "slc" || !c.Audience.contains("api") {
+		t.Fatalf("claims=%+v", c)
+	}
+}
+
+func TestVerifier_Rejects(t *testing.T) {
+	v, _ := NewVerifier([][]byte{oldSecret}, "slc", "api")
+	exp := time.Now().Add(time.Hour).Unix()
+	sign := func(c Claims) string {
+		tok, err := v.Sign(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	good := sign(Claims{Subject: "alice", ExpiresAt: exp})
+	parts := strings.Split(good, ".")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+
+	other, _ := NewVerifier([][]byte{newSecret}, "slc", "api")
+	forged, _ := other.Sign(Claims{Subject: "alice", ExpiresAt: exp})
+
+	cases := map[string]string{
+		"empty":          "",
+		"two parts":      parts[0] + "." + parts[1],
+		"alg none":       none + "." + parts[1] + ".",
+		"other secret":   forged,
+		"tampered":       parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"bob","exp":9999999999}`)) + "." + parts[2],
+		"expired":        sign(Claims{Subject: "alice", ExpiresAt: time.Now().Add(-time.Hour).Unix()}),
+		"no expiry":      sign(Claims{Subject: "alice"}),
+		"no subject":     sign(Claims{ExpiresAt: exp}),
+		"not yet":        sign(Claims{Subject: "alice", ExpiresAt: exp, NotBefore: exp - 60}),
+		"wrong issuer":   sign(Claims{Subject: "alice", ExpiresAt: exp, Issuer: "evil"}),
+		"wrong audience": sign(Claims{Subject: "alice", ExpiresAt: exp, Audience: Audience{"other"}}),
+	}
+	for name, tok := range cases {
+		if _, err := v.Verify(tok); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: err=%v; want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func TestVerifier_Rotation(t *testing.T) {
+	old, _ := NewVerifier([][]byte{oldSecret}, "", "")
+	tok, _ := old.Sign(Claims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	rotated, _ := NewVerifier([][]byte{newSecret, oldSecret}, "", "")
+	if _, err := rotated.Verify(tok); err != nil {
+		t.Fatalf("token signed with the previous secret: %v", err)
+	}
+	fresh, _ := rotated.Sign(Claims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if _, err := old.Verify(fresh); err == nil {
+		t.Fatalf("new tokens must be signed with the first secret")
+	}
+}
+
+func TestNewVerifier_ShortSecret(t *testing.T) {
+	if _, err := NewVerifier(ParseSecrets("short, "+string(oldSecret)), "", ""); err == nil {
+		t.Fatal("short secret must be rejected")
+	}
+	if _, err := NewVerifier(ParseSecrets(" , "), "", ""); err == nil {
+		t.Fatal("missing secret must be rejected")
+	}
+}
+
+func TestAudience_String(t *testing.T) {
+	var c Claims
+	if err := decode(base64.RawURLEncoding.EncodeToString([]byte(`{"aud":"api"}`)), &c); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Audience.contains("api") {
+		t.Fatalf("aud=%v", c.Audience)
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func Env(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+func Int(k string, def int) int {
+	if v := os.Getenv(k); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+		log.Printf("invalid %s=%q, using %d", k, v, def)
+	}
+	return def
+}
+
+func Bool(k string, def bool) bool {
+	if v := os.Getenv(k); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+		log.Printf("invalid %s=%q, using %t", k, v, def)
+	}
+	return def
+}
+
+// List splits a comma-separated variable, dropping empty items.
+func List(k string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(k), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func Duration(k string, def time.Duration) time.Duration {
+	if v := os.Getenv(k); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		log.Printf("invalid %s=%q, using %s", k, v, def)
+	}
+	return def
+}
+
+func MySQLDSN() string {
+	if dsn := os.Getenv("MYSQL_DSN"); dsn != "" {
+		return dsn
+	}
+	return buildDSNFromParts()
+}
+
+func buildDSNFromParts() string {
+	user := Env("DB_USER", "root")
+	pass := os.Getenv("DB_PASSWORD")
+	host := Env("DB_HOST", "127.0.0.1")
+	port := Env("DB_PORT", "3306")
+	name := Env("DB_NAME", "slc")
+
+	if sock := os.Getenv("DB_SOCKET"); sock != "" {
+		return fmt.Sprintf("%s:%s@unix(%s)/%s?parseTime=true&charset=utf8mb4&loc=Local",
+			user, url.QueryEscape(pass), sock, name)
+	}
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true&charset=utf8mb4&loc=Local",
+		user, url.QueryEscape(pass), host, port, name)
+}
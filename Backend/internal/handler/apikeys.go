@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
+)
+
+func (h *httpHandler) keyCollection(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := h.workspaces.Keys(r.Context(), h.userID(w, r))
+		if err != nil {
+			return h.serviceError(w, err, http.StatusInternalServerError)
+		}
+		if keys == nil {
+			keys = []repository.APIKey{}
+		}
+		return h.writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+	case http.MethodPost:
+		var req models.APIKeyRequest
+		if err := h.readJSON(w, r, &req); err != nil {
+			return err
+		}
+		key, token, err := h.workspaces.CreateKey(r.Context(), h.userID(w, r), req.WorkspaceID, req.Name, service.Scope(req.Scope))
+		if err != nil {
+			return h.serviceError(w, err, http.StatusInternalServerError)
+		}
+		return h.writeJSON(w, http.StatusCreated, map[string]any{"key": key, "token": token})
+	default:
+		return h.methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (h *httpHandler) keyItem(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodDelete {
+		return h.methodNotAllowed(w, r, http.MethodDelete)
+	}
+
+	if err := h.workspaces.RevokeKey(r.Context(), h.userID(w, r), r.PathValue("key")); err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	return h.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
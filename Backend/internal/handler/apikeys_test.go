@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func TestHTTP_APIKeys(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), trustHeader))
+	defer srv.Close()
+
+	var ws repository.Workspace
+	decode(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces", "alice", models.WorkspaceRequest{Name: "pipeline"}), &ws)
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces/"+ws.ID+"/init", "alice", models.InitRequest{
+		Class1: models.Class{Name: "Spam", Properties: []string{"offer"}}, Class2: models.Class{Name: "Ham"},
+	}), http.StatusOK, "init")
+
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, "/api/v1/keys", "alice", models.APIKeyRequest{WorkspaceID: ws.ID, Scope: "root"}), http.StatusBadRequest, "bad scope")
+
+	resp := wsRequest(t, srv, http.MethodPost, "/api/v1/keys", "alice", models.APIKeyRequest{Name: "cron", WorkspaceID: ws.ID, Scope: "train"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create key status=%d", resp.StatusCode)
+	}
+	var created struct {
+		Key   repository.APIKey `json:"key"`
+		Token string            `json:"token"`
+	}
+	decode(t, resp, &created)
+	bearer := "Bearer " + created.Token
+
+	// The key addresses its own workspace without naming it.
+	expectStatus(t, bearerRequest(t, srv, http.MethodGet, "/api/v1/state", bearer, nil), http.StatusOK, "key state")
+	expectStatus(t, bearerRequest(t, srv, http.MethodGet, "/api/v1/workspaces/"+ws.ID+"/state", bearer, nil), http.StatusOK, "key state by path")
+	expectStatus(t, bearerRequest(t, srv, http.MethodPost, "/api/v1/reset", bearer, nil), http.StatusForbidden, "train key reset")
+	expectStatus(t, bearerRequest(t, srv, http.MethodGet, "/api/v1/workspaces", bearer, nil), http.StatusForbidden, "key lists workspaces")
+	expectStatus(t, bearerRequest(t, srv, http.MethodPost, "/api/v1/keys", bearer, nil), http.StatusForbidden, "key mints keys")
+	expectStatus(t, bearerRequest(t, srv, http.MethodGet, "/api/v1/workspaces/"+ws.ID+"/grants", bearer, nil), http.StatusForbidden, "train key grants")
+
+	var other repository.Workspace
+	decode(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces", "alice", models.WorkspaceRequest{Name: "other"}), &other)
+	expectStatus(t, bearerRequest(t, srv, http.MethodGet, "/api/v1/state", bearer, map[string]string{"X-Workspace-ID": other.ID}), http.StatusForbidden, "key on another workspace")
+
+	var list struct {
+		Keys []repository.APIKey `json:"keys"`
+	}
+	decode(t, wsRequest(t, srv, http.MethodGet, "/api/v1/keys", "alice", nil), &list)
+	if len(list.Keys) != 1 || list.Keys[0].ID != created.Key.ID || list.Keys[0].LastUsedAt == nil || list.Keys[0].Scope != "train" {
+		t.Fatalf("keys=%+v", list.Keys)
+	}
+
+	expectStatus(t, wsRequest(t, srv, http.MethodDelete, "/api/v1/keys/"+created.Key.ID, "bob", nil), http.StatusNotFound, "revoke someone else's key")
+	expectStatus(t, wsRequest(t, srv, http.MethodDelete, "/api/v1/keys/"+created.Key.ID, "alice", nil), http.StatusOK, "revoke")
+	resp = bearerRequest(t, srv, http.MethodGet, "/api/v1/state", bearer, nil)
+	expectStatus(t, resp, http.StatusUnauthorized, "revoked key")
+}
+
+func TestHTTP_AdminKeyManagesItsWorkspace(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), trustHeader))
+	defer srv.Close()
+
+	var created struct {
+		Key   repository.APIKey `json:"key"`
+		Token string            `json:"token"`
+	}
+	decode(t, wsRequest(t, srv, http.MethodPost, "/api/v1/keys", "alice", models.APIKeyRequest{Scope: "admin"}), &created)
+	bearer := "Bearer " + created.Token
+
+	expectStatus(t, bearerRequest(t, srv, http.MethodPost, "/api/v1/reset", bearer, nil), http.StatusOK, "admin key reset")
+	expectStatus(t, bearerRequest(t, srv, http.MethodGet, "/api/v1/workspaces/"+created.Key.WorkspaceID+"/grants", bearer, nil), http.StatusOK, "admin key grants")
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
+)
+
+// RequestIDHeader carries the request ID that audit entries record. A
+// caller's own ID is kept if it is sane; otherwise one is generated. Either
+// way it is echoed on the response.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestID = 128
+
+func (h *httpHandler) withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return r.WithContext(service.WithRequestID(r.Context(), id))
+}
+
+// validRequestID accepts up to maxRequestID printable ASCII characters
+// without spaces.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// auditLog lists the audit log of the workspace the request addresses,
+// newest first. Only its owner may read it. from and to bound the time
+// (RFC 3339, to exclusive), op and property filter the entries, and before
+// takes the next cursor of the previous page.
+func (h *httpHandler) auditLog(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return h.methodNotAllowed(w, r, http.MethodGet)
+	}
+
+	qs := r.URL.Query()
+	q := repository.AuditQuery{Operation: qs.Get("op"), Property: qs.Get("property")}
+	if q.Operation != "" && !slices.Contains(service.AuditOperations, q.Operation) {
+		return h.badRequest(w, "op must be one of: "+strings.Join(service.AuditOperations, "|"))
+	}
+	var err error
+	if q.Since, err = queryTime(qs, "from"); err != nil {
+		return h.badRequest(w, err.Error())
+	}
+	if q.Until, err = queryTime(qs, "to"); err != nil {
+		return h.badRequest(w, err.Error())
+	}
+	if q.Before, err = queryInt(qs, "before"); err != nil {
+		return h.badRequest(w, err.Error())
+	}
+	limit, err := queryInt(qs, "limit")
+	if err != nil {
+		return h.badRequest(w, err.Error())
+	}
+	q.Limit = int(min(limit, service.MaxAuditLimit))
+
+	var page service.AuditPage
+	if id, ok := identityFrom(r); ok && id.key != nil {
+		page, err = h.workspaces.KeyAudit(r.Context(), *id.key, workspaceID(r), q)
+	} else {
+		page, err = h.workspaces.Audit(r.Context(), h.userID(w, r), workspaceID(r), q)
+	}
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	return h.writeJSON(w, http.StatusOK, page)
+}
+
+// queryTime reads an optional RFC 3339 query parameter.
+func queryTime(qs url.Values, name string) (time.Time, error) {
+	v := qs.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' must be an RFC 3339 time", name)
+	}
+	return t, nil
+}
+
+// queryInt reads an optional positive integer query parameter.
+func queryInt(qs url.Values, name string) (int64, error) {
+	v := qs.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("'%s' must be a positive integer", name)
+	}
+	return n, nil
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
+)
+
+func TestHTTP_AuditLog(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), trustHeader))
+	defer srv.Close()
+
+	var ws repository.Workspace
+	decode(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces", "owner", models.WorkspaceRequest{Name: "triage"}), &ws)
+	base := "/api/v1/workspaces/" + ws.ID
+
+	b, _ := json.Marshal(models.InitRequest{
+		Class1: models.Class{Name: "Urgent", Properties: []string{"asap"}}, Class2: models.Class{Name: "Routine"},
+	})
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+base+"/init", bytes.NewReader(b))
+	req.Header.Set("X-User-ID", "owner")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(RequestIDHeader, "trace-42")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, resp, http.StatusOK, "init")
+	if got := resp.Header.Get(RequestIDHeader); got != "trace-42" {
+		t.Fatalf("request ID=%q; want the caller's", got)
+	}
+
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/grants", "owner", models.GrantRequest{UserID: "alice", Role: "editor"}), http.StatusOK, "share")
+	resp = wsRequest(t, srv, http.MethodPost, base+"/feedback", "alice", models.FeedbackRequest{Variant: "class1", Properties: []string{"today"}})
+	expectStatus(t, resp, http.StatusOK, "feedback")
+	generated := resp.Header.Get(RequestIDHeader)
+	if len(generated) != 32 {
+		t.Fatalf("generated request ID=%q", generated)
+	}
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/classes/rename", "owner", models.RenameClassRequest{Class: "class2", Name: "Later"}), http.StatusOK, "rename")
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/classify", "alice", models.ClassifyRequest{Properties: []string{"asap"}}), http.StatusOK, "classify")
+
+	audit := func(query string) service.AuditPage {
+		t.Helper()
+		var page service.AuditPage
+		decode(t, wsRequest(t, srv, http.MethodGet, base+"/audit"+query, "owner", nil), &page)
+		return page
+	}
+	page := audit("")
+	if len(page.Entries) != 3 || page.Next != 0 {
+		t.Fatalf("audit=%+v; want init, feedback and rename only", page)
+	}
+	rename, feedback, init := page.Entries[0], page.Entries[1], page.Entries[2]
+	if init.Operation != "init" || init.Actor != "owner" || init.Via != "header" || init.RequestID != "trace-42" {
+		t.Fatalf("init entry=%+v", init)
+	}
+	var diff service.AuditDiff
+	if err := json.Unmarshal(feedback.Diff, &diff); err != nil {
+		t.Fatal(err)
+	}
+	if feedback.Actor != "alice" || feedback.RequestID != generated || len(diff.Added["class1"]) != 1 || diff.Added["class1"][0] != "today" {
+		t.Fatalf("feedback entry=%+v diff=%+v", feedback, diff)
+	}
+	if err := json.Unmarshal(rename.Diff, &diff); err != nil {
+		t.Fatal(err)
+	}
+	if diff.Classes["class2"] != (service.ClassRename{From: "Routine", To: "Later"}) {
+		t.Fatalf("rename diff=%s", rename.Diff)
+	}
+
+	page = audit("?property=today")
+	if len(page.Entries) != 1 || page.Entries[0].ID != feedback.ID {
+		t.Fatalf("by property=%+v", page.Entries)
+	}
+	page = audit("?op=classes/rename")
+	if len(page.Entries) != 1 || page.Entries[0].ID != rename.ID {
+		t.Fatalf("by op=%+v", page.Entries)
+	}
+	page = audit("?to=" + init.At.Add(-1).Format(time.RFC3339Nano))
+	if len(page.Entries) != 0 {
+		t.Fatalf("before the first change=%+v", page.Entries)
+	}
+	page = audit("?limit=2")
+	if len(page.Entries) != 2 || page.Next != feedback.ID {
+		t.Fatalf("first page=%+v", page)
+	}
+	page = audit("?limit=2&before=" + strconv.FormatInt(page.Next, 10))
+	if len(page.Entries) != 1 || page.Entries[0].ID != init.ID || page.Next != 0 {
+		t.Fatalf("second page=%+v", page)
+	}
+
+	expectStatus(t, wsRequest(t, srv, http.MethodGet, base+"/audit", "alice", nil), http.StatusForbidden, "editor reads audit")
+	expectStatus(t, wsRequest(t, srv, http.MethodGet, base+"/audit?op=classify", "owner", nil), http.StatusBadRequest, "unknown op")
+	expectStatus(t, wsRequest(t, srv, http.MethodGet, base+"/audit?from=yesterday", "owner", nil), http.StatusBadRequest, "bad time")
+	expectStatus(t, wsRequest(t, srv, http.MethodGet, base+"/audit?limit=0", "owner", nil), http.StatusBadRequest, "bad limit")
+}
+
+func TestHTTP_AuditLogFiltersNewWorkspaces(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	srv := httptest.NewServer(NewHTTPMux(repo, trustHeader))
+	defer srv.Close()
+
+	cat, dog := models.Class{Name: "Cat", Properties: []string{"purr"}}, models.Class{Name: "Dog"}
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, "/api/v1/init", "owner", models.InitRequest{Class1: cat, Class2: dog}), http.StatusOK, "init")
+	var list struct {
+		Workspaces []repository.Workspace `json:"workspaces"`
+	}
+	decode(t, wsRequest(t, srv, http.MethodGet, "/api/v1/workspaces", "owner", nil), &list)
+	src := list.Workspaces[0]
+	var imported, cloned repository.Workspace
+	decode(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces/clone", "owner", models.CloneRequest{
+		Export: &models.Snapshot{Class1: cat, Class2: dog},
+	}), &imported)
+	decode(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces/clone", "owner", models.CloneRequest{From: src.ID}), &cloned)
+
+	// A visitor's state that the owner claims into their default workspace.
+	ws := service.NewWorkspaces(repo)
+	visitor, err := ws.Default(service.WithAnonymous(ctx), "anon_visitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Classifier(visitor.ID).Init(ctx, cat, dog); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.ClaimAnonymous(ctx, "owner", "anon_visitor", service.ClaimMerge); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{ workspace, op string }{
+		{imported.ID, "import"},
+		{cloned.ID, "clone"},
+		{src.ID, "claim"},
+	} {
+		var page service.AuditPage
+		decode(t, wsRequest(t, srv, http.MethodGet, "/api/v1/workspaces/"+c.workspace+"/audit?op="+c.op, "owner", nil), &page)
+		if len(page.Entries) != 1 || page.Entries[0].Operation != c.op {
+			t.Fatalf("op=%s entries=%+v", c.op, page.Entries)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/auth"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/ratelimit"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
+)
+
+// Options configures how callers are identified and where they may call
+// from.
+type Options struct {
+	// Tokens verifies "Authorization: Bearer" tokens. Nil rejects them;
+	// API keys are accepted either way.
+	Tokens *auth.Verifier
+	// TrustedUserHeader names a header whose value is taken as the user ID
+	// as-is. Set it only behind a proxy that authenticates users, sets the
+	// header and strips it from client requests.
+	TrustedUserHeader string
+	// RequireAuth refuses callers without a token or trusted header instead
+	// of giving them an anonymous cookie. Share links stay public.
+	RequireAuth bool
+	// Sessions signs anonymous session cookies. Nil uses a random secret,
+	// so sessions do not survive a restart.
+	Sessions *auth.Sessions
+	// AnonCookieName defaults to DefaultAnonCookieName.
+	AnonCookieName string
+	// CORS says which other origins browsers may call the API from.
+	CORS CORSPolicy
+	// TrustedOrigins may send POST and DELETE requests with the anonymous
+	// session cookie from another origin. They are written like
+	// CORSPolicy.Origins, without the bare "*". Same-origin requests are
+	// always allowed.
+	TrustedOrigins []string
+	// ReadLimit and WriteLimit budget each caller's reads and writes. Nil
+	// means unlimited.
+	ReadLimit, WriteLimit *ratelimit.Limiter
+	// Quotas caps what each classifier and user may store. The zero value
+	// sets no limits.
+	Quotas service.Quotas
+	// Body bounds JSON request bodies.
+	Body BodyLimits
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header
+	// says which client a request came from, for rate limiting. Requests
+	// from elsewhere are charged to their own address.
+	TrustedProxies []netip.Prefix
+}
+
+const DefaultAnonCookieName = "slc_uid"
+
+type identityKey struct{}
+
+// identity is a caller established by authenticate. Anonymous callers have
+// none until userID starts a session for them. API key callers carry the key,
+// which limits them to one workspace. A signed-in user whose browser still
+// holds an anonymous session carries its ID until they claim it. issued is
+// when a cookie session was signed.
+type identity struct {
+	userID string
+	via    string
+	key    *repository.APIKey
+	anonID string
+	issued time.Time
+}
+
+// ClaimHeader is set on responses to signed-in users who still hold an
+// anonymous session, whose state they can claim at /api/v1/claim.
+const ClaimHeader = "X-Anonymous-Session"
+
+// authenticate resolves the caller's identity and stores it in the request
+// context. It writes a 401 and returns false when the credentials are
+// invalid or missing but required.
+func (h *httpHandler) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if authz := r.Header.Get("Authorization"); authz != "" {
+		token, ok := bearerToken(authz)
+		if !ok {
+			return r, h.unauthorized(w, `error="invalid_request"`, "unsupported authorization")
+		}
+		if service.IsAPIKey(token) {
+			k, err := h.workspaces.AuthenticateKey(r.Context(), token)
+			if errors.Is(err, service.ErrInvalidKey) {
+				return r, h.unauthorized(w, `error="invalid_token"`, err.Error())
+			}
+			if err != nil {
+				_ = h.serviceError(w, err, http.StatusInternalServerError)
+				return r, false
+			}
+			return withIdentity(r, identity{userID: k.UserID, via: "apikey", key: &k}), true
+		}
+		if h.opts.Tokens == nil {
+			return r, h.unauthorized(w, `error="invalid_token"`, "bearer tokens are not enabled")
+		}
+		claims, err := h.opts.Tokens.Verify(token)
+		if err != nil {
+			return r, h.unauthorized(w, `error="invalid_token"`, err.Error())
+		}
+		return withIdentity(r, h.signedIn(w, r, claims.Subject, "token")), true
+	}
+	if hdr := h.opts.TrustedUserHeader; hdr != "" {
+		if uid := r.Header.Get(hdr); uid != "" {
+			return withIdentity(r, h.signedIn(w, r, uid, "header")), true
+		}
+	}
+	if r.PathValue("token") != "" {
+		// Share links need no identity, and must not set cookies.
+		return r, true
+	}
+	if h.opts.RequireAuth {
+		return r, h.unauthorized(w, "", "authentication required")
+	}
+	// Workspaces made from here on belong to a visitor without an account.
+	r = r.WithContext(service.WithAnonymous(r.Context()))
+	uid, issued, ok, err := h.anonymousSession(w, r)
+	if err != nil {
+		_ = h.serviceError(w, err, http.StatusInternalServerError)
+		return r, false
+	}
+	if ok {
+		return withIdentity(r, identity{userID: uid, via: "cookie", issued: issued}), true
+	}
+	return r, true
+}
+
+// signedIn is the identity of an authenticated user, noting an anonymous
+// session left over from before they signed in so they can be offered its
+// state.
+func (h *httpHandler) signedIn(w http.ResponseWriter, r *http.Request, uid, via string) identity {
+	id := identity{userID: uid, via: via}
+	if c, err := r.Cookie(h.opts.AnonCookieName); err == nil && c.Value != "" {
+		if anon, _, err := h.opts.Sessions.Verify(c.Value); err == nil && anon != uid {
+			id.anonID = anon
+			w.Header().Set(ClaimHeader, "claimable")
+		}
+	}
+	return id
+}
+
+// anonymousSession returns the visitor ID from a validly signed session
+// cookie and when it was signed, re-issuing the cookie once it is past half
+// its lifetime. An unsigned cookie from before signing is re-signed the first
+// time it is seen; other unsigned, forged and expired cookies are ignored, so
+// the visitor gets a new session.
+func (h *httpHandler) anonymousSession(w http.ResponseWriter, r *http.Request) (string, time.Time, bool, error) {
+	c, err := r.Cookie(h.opts.AnonCookieName)
+	if err != nil || c.Value == "" {
+		return "", time.Time{}, false, nil
+	}
+	uid, renew, err := h.opts.Sessions.Verify(c.Value)
+	if err != nil {
+		return h.legacySession(w, r, c.Value)
+	}
+	if renew {
+		h.setSessionCookie(w, r, h.opts.Sessions.Sign(uid))
+	}
+	return uid, auth.SessionIssued(c.Value), true, nil
+}
+
+// legacySession replaces an unsigned cookie from before signing with a signed
+// one for the same visitor, if Workspaces.AcceptLegacySession allows it.
+func (h *httpHandler) legacySession(w http.ResponseWriter, r *http.Request, value string) (string, time.Time, bool, error) {
+	uid, ok := auth.LegacySessionID(value)
+	if !ok {
+		return "", time.Time{}, false, nil
+	}
+	ok, err := h.workspaces.AcceptLegacySession(r.Context(), uid)
+	if err != nil || !ok {
+		return "", time.Time{}, false, err
+	}
+	h.setSessionCookie(w, r, h.opts.Sessions.Sign(uid))
+	return uid, time.Now(), true, nil
+}
+
+func (h *httpHandler) setSessionCookie(w http.ResponseWriter, r *http.Request, value string) {
+	ttl := h.opts.Sessions.TTL()
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.opts.AnonCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		Expires:  time.Now().Add(ttl),
+		HttpOnly: true,
+		Secure:   isHTTPSRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// endSession tells the browser to drop its anonymous session cookie.
+func (h *httpHandler) endSession(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.opts.AnonCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPSRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *httpHandler) unauthorized(w http.ResponseWriter, params, msg string) bool {
+	challenge := "Bearer"
+	if params != "" {
+		challenge += " " + params
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	_ = h.writeJSON(w, http.StatusUnauthorized, map[string]any{"error": msg})
+	return false
+}
+
+func bearerToken(authz string) (string, bool) {
+	scheme, token, ok := strings.Cut(authz, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func withIdentity(r *http.Request, id identity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+}
+
+func identityFrom(r *http.Request) (identity, bool) {
+	id, ok := r.Context().Value(identityKey{}).(identity)
+	return id, ok
+}
+
+// keyCatalog guards workspace management routes. An API key may only manage
+// its own workspace, and only with the admin scope; routes that are not about
+// a single workspace, such as listing workspaces or managing keys, refuse
+// keys altogether.
+func (h *httpHandler) keyCatalog(fn func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if id, ok := identityFrom(r); ok && id.key != nil {
+			ws := r.PathValue("ws")
+			if ws == "" || ws != id.key.WorkspaceID || service.Scope(id.key.Scope) != service.ScopeAdmin {
+				return h.serviceError(w, service.ErrKeyScope, http.StatusForbidden)
+			}
+		}
+		return fn(w, r)
+	}
+}
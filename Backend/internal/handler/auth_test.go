@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/auth"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
+)
+
+// trustHeader lets tests name the caller with X-User-ID, as a deployment
+// behind an authenticating proxy would.
+var trustHeader = Options{TrustedUserHeader: "X-User-ID"}
+
+func testVerifier(t *testing.T) *auth.Verifier {
+	t.Helper()
+	v, err := auth.NewVerifier([][]byte{[]byte("0123456789abcdef0123456789abcdef")}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func bearerRequest(t *testing.T, srv *httptest.Server, method, path, authz string, header map[string]string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, srv.URL+path, nil)
+	if authz != "" {
+		req.Header.Set("Authorization", authz)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestHTTP_BearerTokens(t *testing.T) {
+	v := testVerifier(t)
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), Options{Tokens: v}))
+	defer srv.Close()
+
+	tok, _ := v.Sign(auth.Claims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	var list struct {
+		Workspaces []service.Access `json:"workspaces"`
+	}
+	resp := bearerRequest(t, srv, http.MethodGet, "/api/v1/workspaces", "Bearer "+tok, nil)
+	if len(resp.Cookies()) != 0 {
+		t.Fatalf("token callers must not get an anonymous cookie")
+	}
+	decode(t, resp, &list)
+	if len(list.Workspaces) != 1 || list.Workspaces[0].OwnerID != "alice" {
+		t.Fatalf("workspaces=%+v", list.Workspaces)
+	}
+
+	expired, _ := v.Sign(auth.Claims{Subject: "alice", ExpiresAt: time.Now().Add(-time.Hour).Unix()})
+	for name, authz := range map[string]string{
+		"expired": "Bearer " + expired,
+		"garbage": "Bearer abc.def.ghi",
+		"basic":   "Basic YWxpY2U6eA==",
+	} {
+		resp := bearerRequest(t, srv, http.MethodGet, "/api/v1/state", authz, nil)
+		if resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: missing WWW-Authenticate", name)
+		}
+		expectStatus(t, resp, http.StatusUnauthorized, name)
+	}
+}
+
+func TestHTTP_UserHeaderIsNotTrustedByDefault(t *testing.T) {
+	repo := repository.NewMemory()
+	srv := httptest.NewServer(NewHTTPMux(repo, Options{}))
+	defer srv.Close()
+
+	owner := httptest.NewServer(NewHTTPMux(repo, trustHeader))
+	defer owner.Close()
+	var ws repository.Workspace
+	decode(t, wsRequest(t, owner, http.MethodPost, "/api/v1/workspaces", "alice", models.WorkspaceRequest{Name: "private"}), &ws)
+
+	expectStatus(t, wsRequest(t, srv, http.MethodGet, "/api/v1/workspaces/"+ws.ID, "alice", nil), http.StatusNotFound, "spoofed header")
+}
+
+func TestHTTP_RequireAuth(t *testing.T) {
+	v := testVerifier(t)
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), Options{Tokens: v, RequireAuth: true}))
+	defer srv.Close()
+
+	expectStatus(t, bearerRequest(t, srv, http.MethodGet, "/api/v1/state", "", nil), http.StatusUnauthorized, "anonymous state")
+	expectStatus(t, bearerRequest(t, srv, http.MethodGet, "/api/v1/state", "", map[string]string{"X-User-ID": "alice"}), http.StatusUnauthorized, "untrusted header")
+	expectStatus(t, bearerRequest(t, srv, http.MethodOptions, "/api/v1/state", "", nil), http.StatusNoContent, "preflight")
+	// Share links stay usable without credentials; this one simply does not exist.
+	expectStatus(t, bearerRequest(t, srv, http.MethodGet, "/api/v1/shared/nope/state", "", nil), http.StatusNotFound, "share link")
+
+	tok, _ := v.Sign(auth.Claims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	expectStatus(t, bearerRequest(t, srv, http.MethodGet, "/api/v1/state", "Bearer "+tok, nil), http.StatusOK, "token state")
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
+)
+
+// claim lets a signed-in user take over the state they built under the
+// anonymous session their browser still holds. GET shows that state; POST
+// adopts, merges or discards it and ends the anonymous session.
+func (h *httpHandler) claim(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		return h.methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	}
+	id, ok := identityFrom(r)
+	if !ok || id.anonID == "" {
+		return h.writeJSON(w, http.StatusNotFound, map[string]any{"error": "no anonymous session to claim"})
+	}
+
+	if r.Method == http.MethodGet {
+		u, err := h.workspaces.Unclaimed(r.Context(), id.anonID)
+		if err != nil {
+			return h.serviceError(w, err, http.StatusInternalServerError)
+		}
+		return h.writeJSON(w, http.StatusOK, u)
+	}
+
+	var req models.ClaimRequest
+	if err := h.readJSON(w, r, &req); err != nil {
+		return err
+	}
+	out, err := h.workspaces.ClaimAnonymous(r.Context(), id.userID, id.anonID, service.ClaimAction(req.Action))
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	h.endSession(w, r)
+	w.Header().Del(ClaimHeader)
+	if out == nil {
+		out = []repository.Workspace{}
+	}
+	return h.writeJSON(w, http.StatusOK, map[string]any{"workspaces": out})
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/auth"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
+)
+
+func TestHTTP_ClaimAnonymousState(t *testing.T) {
+	v := testVerifier(t)
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), Options{Tokens: v}))
+	defer srv.Close()
+
+	resp := cookieRequest(t, srv, http.MethodPost, "/api/v1/init", nil, models.InitRequest{
+		Class1: models.Class{Name: "Cat", Properties: []string{"purr"}}, Class2: models.Class{Name: "Dog"},
+	})
+	expectStatus(t, resp, http.StatusOK, "anonymous init")
+	session := sessionCookie(resp)
+
+	tok, _ := v.Sign(auth.Claims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	do := func(method, path string, cookie *http.Cookie, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+tok)
+		req.Header.Set("Content-Type", "application/json")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp = do(http.MethodGet, "/api/v1/state", session, "")
+	if resp.Header.Get(ClaimHeader) != "claimable" {
+		t.Fatalf("signed-in request with a session: %s=%q", ClaimHeader, resp.Header.Get(ClaimHeader))
+	}
+	expectStatus(t, resp, http.StatusOK, "state")
+
+	var pending service.Unclaimed
+	decode(t, do(http.MethodGet, "/api/v1/claim", session, ""), &pending)
+	if len(pending.Workspaces) != 1 || pending.State.Class1.Name != "Cat" {
+		t.Fatalf("pending claim=%+v", pending)
+	}
+	expectStatus(t, do(http.MethodPost, "/api/v1/claim", session, `{"action":"keep"}`), http.StatusBadRequest, "unknown action")
+
+	resp = do(http.MethodPost, "/api/v1/claim", session, `{"action":"adopt"}`)
+	if c := sessionCookie(resp); c == nil || c.MaxAge >= 0 {
+		t.Fatalf("claim must end the anonymous session, cookie=%+v", c)
+	}
+	expectStatus(t, resp, http.StatusOK, "claim")
+
+	var snap models.Snapshot
+	decode(t, do(http.MethodGet, "/api/v1/state", nil, ""), &snap)
+	if snap.Class1.Name != "Cat" || len(snap.Class1.Properties) != 1 {
+		t.Fatalf("alice's state after adopting=%+v", snap)
+	}
+	// The session cookie is still validly signed, but its state is gone.
+	decode(t, do(http.MethodGet, "/api/v1/claim", session, ""), &pending)
+	if len(pending.Workspaces) != 0 || pending.State.Class1.Name != "" {
+		t.Fatalf("claimed session still holds=%+v", pending)
+	}
+
+	expectStatus(t, do(http.MethodGet, "/api/v1/claim", nil, ""), http.StatusNotFound, "no session")
+	expectStatus(t, cookieRequest(t, srv, http.MethodGet, "/api/v1/claim", session, nil), http.StatusNotFound, "anonymous caller")
+}
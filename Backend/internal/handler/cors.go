@@ -1,19 +1,117 @@
 package handler
 
-import "net/http"
-
-func CORS(allowedOrigin string) func(http.Handler) http.Handler {
-    return func(next http.Handler) http.Handler {
-        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-            w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
-            w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-User-ID")
-            w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-            w.Header().Set("Vary", "Origin")
-            if r.Method == http.MethodOptions {
-                w.WriteHeader(http.StatusNoContent)
-                return
-            }
-            next.ServeHTTP(w, r)
-        })
-    }
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCORSMaxAge is how long browsers may cache a preflight answer unless
+// CORSPolicy says otherwise.
+const DefaultCORSMaxAge = 10 * time.Minute
+
+// CORSPolicy decides which browser origins may call the API.
+type CORSPolicy struct {
+	// Origins lists the allowed origins, such as "https://app.example.com".
+	// A "*" in the host matches one or more subdomain labels, as in
+	// "https://*.example.com", and a bare "*" matches any origin.
+	Origins []string
+	// Credentials lets the listed origins send cookies. Origins allowed only
+	// through a bare "*" never may.
+	Credentials bool
+	// MaxAge defaults to DefaultCORSMaxAge.
+	MaxAge time.Duration
+}
+
+// corsHeaders lists the request headers a cross-origin caller may send.
+var corsHeaders = []string{"Content-Type", "Authorization", "X-Workspace-ID", RequestIDHeader}
+
+// exposedHeaders are the response headers cross-origin callers may read.
+var exposedHeaders = []string{
+	ClaimHeader, RequestIDHeader, "Retry-After",
+	"RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+}
+
+// allow reports whether origin may call the API, and whether it was listed
+// explicitly rather than through a bare "*".
+func (p CORSPolicy) allow(origin string) (ok, listed bool) {
+	for _, pattern := range p.Origins {
+		switch {
+		case pattern == "*":
+			ok = true
+		case matchOrigin(pattern, origin):
+			return true, true
+		}
+	}
+	return ok, false
+}
+
+// applyCORS sets the CORS headers for a request to a route serving methods. It
+// answers preflight and other OPTIONS requests itself and then returns true.
+// Origins the policy does not allow get no CORS headers, so browsers keep
+// the response from them.
+func (h *httpHandler) applyCORS(w http.ResponseWriter, r *http.Request, methods []string) bool {
+	p := h.opts.CORS
+	allowed := append(append([]string{}, methods...), http.MethodOptions)
+	hdr := w.Header()
+	hdr.Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	ok, listed := p.allow(origin)
+	if origin != "" && ok {
+		if listed {
+			hdr.Set("Access-Control-Allow-Origin", origin)
+			if p.Credentials {
+				hdr.Set("Access-Control-Allow-Credentials", "true")
+			}
+		} else {
+			hdr.Set("Access-Control-Allow-Origin", "*")
+		}
+		hdr.Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+	}
+	if r.Method != http.MethodOptions {
+		return false
+	}
+
+	hdr.Set("Allow", strings.Join(allowed, ", "))
+	if origin != "" && ok && r.Header.Get("Access-Control-Request-Method") != "" {
+		hdr.Add("Vary", "Access-Control-Request-Method")
+		hdr.Add("Vary", "Access-Control-Request-Headers")
+		hdr.Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+		hdr.Set("Access-Control-Allow-Headers", strings.Join(h.corsHeaders(), ", "))
+		maxAge := p.MaxAge
+		if maxAge <= 0 {
+			maxAge = DefaultCORSMaxAge
+		}
+		hdr.Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func (h *httpHandler) corsHeaders() []string {
+	if hdr := h.opts.TrustedUserHeader; hdr != "" {
+		return append(append([]string{}, corsHeaders...), hdr)
+	}
+	return corsHeaders
+}
+
+// matchOrigin compares origins case-insensitively. A "*" in pattern stands
+// for at least one character that may appear in a host name.
+func matchOrigin(pattern, origin string) bool {
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+	prefix, suffix, wild := strings.Cut(pattern, "*")
+	if !wild {
+		return pattern == origin
+	}
+	if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	for _, c := range origin[len(prefix) : len(origin)-len(suffix)] {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
 }
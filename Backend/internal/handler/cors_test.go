@@ -1,49 +1,115 @@
 package handler
 
 import (
-    "net/http"
-    "net/http/httptest"
-    "testing"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
 )
 
-func TestCORS_Middleware_OPTIONS_and_Get(t *testing.T) {
-    mux := http.NewServeMux()
-    mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
-        w.WriteHeader(http.StatusOK)
-        _, _ = w.Write([]byte("ok"))
-    })
-
-    h := CORS("*")(mux)
-    srv := httptest.NewServer(h)
-    defer srv.Close()
-
-    req, _ := http.NewRequest(http.MethodOptions, srv.URL+"/ok", nil)
-    req.Header.Set("Origin", "https://example.test")
-    req.Header.Set("Access-Control-Request-Method", "GET")
-    resp, err := http.DefaultClient.Do(req)
-    if err != nil {
-        t.Fatalf("options request failed: %v", err)
-    }
-    if resp.StatusCode != http.StatusNoContent {
-        t.Fatalf("options status=%d; want 204", resp.StatusCode)
-    }
-    if resp.Header.Get("Access-Control-Allow-Origin") != "*" {
-        t.Fatalf("allow origin=%q; want '*'", resp.Header.Get("Access-Control-Allow-Origin"))
-    }
-
-    h2 := CORS("https://site.example")(mux)
-    srv2 := httptest.NewServer(h2)
-    defer srv2.Close()
-    req2, _ := http.NewRequest(http.MethodGet, srv2.URL+"/ok", nil)
-    req2.Header.Set("Origin", "https://site.example")
-    resp2, err := http.DefaultClient.Do(req2)
-    if err != nil {
-        t.Fatalf("get request failed: %v", err)
-    }
-    if resp2.StatusCode != http.StatusOK {
-        t.Fatalf("get status=%d; want 200", resp2.StatusCode)
-    }
-    if resp2.Header.Get("Access-Control-Allow-Origin") != "https://site.example" {
-        t.Fatalf("allow origin=%q; want 'https://site.example'", resp2.Header.Get("Access-Control-Allow-Origin"))
-    }
+func corsRequest(t *testing.T, srv *httptest.Server, method, path, origin string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, srv.URL+path, nil)
+	req.Header.Set("Origin", origin)
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), Options{CORS: CORSPolicy{
+		Origins:     []string{"https://app.example.com", "https://*.preview.example.com"},
+		Credentials: true,
+		MaxAge:      time.Hour,
+	}}))
+	defer srv.Close()
+
+	resp := corsRequest(t, srv, http.MethodOptions, "/api/v1/workspaces", "https://app.example.com")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("preflight status=%d; want 204", resp.StatusCode)
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, POST, OPTIONS",
+		"Access-Control-Max-Age":           "3600",
+	}
+	for k, v := range want {
+		if got := resp.Header.Get(k); got != v {
+			t.Errorf("%s=%q; want %q", k, got, v)
+		}
+	}
+	if resp.Header.Get("Vary") != "Origin" {
+		t.Errorf("Vary=%q", resp.Header.Values("Vary"))
+	}
+
+	// Each route advertises its own methods.
+	resp = corsRequest(t, srv, http.MethodOptions, "/api/v1/state", "https://pr-7.preview.example.com")
+	if resp.Header.Get("Access-Control-Allow-Methods") != "GET, OPTIONS" || resp.Header.Get("Access-Control-Allow-Origin") != "https://pr-7.preview.example.com" {
+		t.Fatalf("state preflight headers=%v", resp.Header)
+	}
+
+	for _, origin := range []string{"https://evil.example", "https://preview.example.com", "null"} {
+		resp := corsRequest(t, srv, http.MethodOptions, "/api/v1/workspaces", origin)
+		if resp.Header.Get("Access-Control-Allow-Origin") != "" || resp.Header.Get("Access-Control-Allow-Methods") != "" {
+			t.Fatalf("%s was allowed: %v", origin, resp.Header)
+		}
+	}
+}
+
+func TestCORS_SimpleRequests(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), Options{CORS: CORSPolicy{
+		Origins:     []string{"*", "https://app.example.com"},
+		Credentials: true,
+	}}))
+	defer srv.Close()
+
+	resp := corsRequest(t, srv, http.MethodGet, "/api/v1/state", "https://app.example.com")
+	if got := resp.Header.Values("Access-Control-Allow-Origin"); len(got) != 1 || got[0] != "https://app.example.com" {
+		t.Fatalf("allow origin=%q", got)
+	}
+	if !strings.Contains(resp.Header.Get("Access-Control-Expose-Headers"), ClaimHeader) {
+		t.Fatalf("expose headers=%q", resp.Header.Get("Access-Control-Expose-Headers"))
+	}
+
+	// Any other origin may read, but never with credentials.
+	resp = corsRequest(t, srv, http.MethodGet, "/api/v1/state", "https://elsewhere.example")
+	if resp.Header.Get("Access-Control-Allow-Origin") != "*" || resp.Header.Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("wildcard headers=%v", resp.Header)
+	}
+	resp = corsRequest(t, srv, http.MethodOptions, "/api/v1/reset", "https://elsewhere.example")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("wildcard preflight status=%d headers=%v", resp.StatusCode, resp.Header)
+	}
+}
+
+func TestMatchOrigin(t *testing.T) {
+	cases := []struct {
+		pattern, origin string
+		want            bool
+	}{
+		{"https://app.example.com", "https://app.example.com", true},
+		{"https://app.example.com", "HTTPS://APP.EXAMPLE.COM", true},
+		{"https://app.example.com", "http://app.example.com", false},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://.example.com.evil/.example.com", false},
+		{"https://*.example.com", "https://evil.com:1@x.example.com", false},
+		{"https://*.example.com", "https://x.example.com:8080", false},
+		{"http://localhost:*", "http://localhost:5173", true},
+	}
+	for _, c := range cases {
+		if got := matchOrigin(c.pattern, c.origin); got != c.want {
+			t.Errorf("matchOrigin(%q, %q)=%t; want %t", c.pattern, c.origin, got, c.want)
+		}
+	}
 }
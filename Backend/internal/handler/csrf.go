@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+var errCrossSite = errors.New("cross-site request refused")
+
+// crossSite reports whether an unsafe request made with the anonymous session
+// cookie comes from a page on another site, which the browser would have sent
+// the cookie for anyway. Browsers say where a request comes from in
+// Sec-Fetch-Site, and older ones at least in Origin; other origins must be
+// listed in TrustedOrigins. Requests with neither header do not come from a
+// browser. Callers identified by a token, API key or trusted header are
+// exempt, as no browser attaches those by itself, and so are share links,
+// which carry no identity.
+func (h *httpHandler) crossSite(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	id, ok := identityFrom(r)
+	if ok && id.via != "cookie" || !ok && r.PathValue("token") != "" {
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	case "":
+		if origin == "" {
+			return false
+		}
+	}
+	return !h.trustedOrigin(r, origin)
+}
+
+func (h *httpHandler) trustedOrigin(r *http.Request, origin string) bool {
+	if origin == "" || origin == "null" {
+		return false
+	}
+	for _, pattern := range h.opts.TrustedOrigins {
+		if pattern != "*" && matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && u.Host == r.Host
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/auth"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func TestHTTP_CrossSiteCookieRequests(t *testing.T) {
+	v := testVerifier(t)
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), Options{
+		Tokens:         v,
+		TrustedOrigins: []string{"https://app.example.com"},
+	}))
+	defer srv.Close()
+
+	resp := cookieRequest(t, srv, http.MethodPost, "/api/v1/init", nil, models.InitRequest{
+		Class1: models.Class{Name: "Cat"}, Class2: models.Class{Name: "Dog"},
+	})
+	expectStatus(t, resp, http.StatusOK, "init")
+	session := sessionCookie(resp)
+	tok, _ := v.Sign(auth.Claims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	send := func(method, path string, header map[string]string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(`{}`))
+		req.AddCookie(session)
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	refused := map[string]map[string]string{
+		"cross-site":           {"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"},
+		"same-site":            {"Sec-Fetch-Site": "same-site", "Origin": "https://other.example.com"},
+		"cross-site no origin": {"Sec-Fetch-Site": "cross-site"},
+		"origin only":          {"Origin": "https://evil.example"},
+		"null origin":          {"Origin": "null"},
+	}
+	for name, header := range refused {
+		expectStatus(t, send(http.MethodPost, "/api/v1/reset", header), http.StatusForbidden, name)
+	}
+
+	allowed := map[string]map[string]string{
+		"same-origin":    {"Sec-Fetch-Site": "same-origin", "Origin": srv.URL},
+		"typed URL":      {"Sec-Fetch-Site": "none"},
+		"origin matches": {"Origin": srv.URL},
+		"trusted":        {"Sec-Fetch-Site": "cross-site", "Origin": "https://app.example.com"},
+		"not a browser":  {},
+		"token":          {"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example", "Authorization": "Bearer " + tok},
+	}
+	for name, header := range allowed {
+		expectStatus(t, send(http.MethodPost, "/api/v1/reset", header), http.StatusOK, name)
+	}
+	expectStatus(t, send(http.MethodGet, "/api/v1/state", refused["cross-site"]), http.StatusOK, "cross-site read")
+
+	// Share links are public and meant to be embedded on other sites.
+	var list struct {
+		Workspaces []repository.Workspace `json:"workspaces"`
+	}
+	decode(t, send(http.MethodGet, "/api/v1/workspaces", nil), &list)
+	var link struct {
+		Token string `json:"token"`
+	}
+	decode(t, send(http.MethodPost, "/api/v1/workspaces/"+list.Workspaces[0].ID+"/links", map[string]string{"Sec-Fetch-Site": "same-origin"}), &link)
+	expectStatus(t, send(http.MethodPost, "/api/v1/shared/"+link.Token+"/classify", refused["cross-site"]), http.StatusOK, "share link")
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// BodyLimits bounds the JSON request bodies handlers accept. Zero fields use
+// the defaults below.
+type BodyLimits struct {
+	MaxBytes int64
+	// MaxDepth is how deeply objects and arrays may nest.
+	MaxDepth int
+	// MaxArray is the most elements one array may hold.
+	MaxArray int
+}
+
+const (
+	DefaultMaxBodyBytes = 1 << 20
+	DefaultMaxJSONDepth = 16
+	DefaultMaxJSONArray = 10000
+)
+
+// errBodyRefused is returned by readJSON after it has written the response.
+var errBodyRefused = errors.New("request body refused")
+
+// readJSON decodes the request body, which must be a single JSON value sent
+// as application/json, into v. Oversized bodies get 413, other content types
+// 415, and malformed, deeply nested or trailing JSON 400. It writes that
+// response itself and returns errBodyRefused, so handlers just return.
+func (h *httpHandler) readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		_ = h.writeJSON(w, http.StatusUnsupportedMediaType, map[string]any{"error": "content type must be application/json"})
+		return errBodyRefused
+	}
+
+	limits := h.opts.Body
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limits.MaxBytes))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		_ = h.writeJSON(w, http.StatusRequestEntityTooLarge, map[string]any{
+			"error": fmt.Sprintf("request body must be at most %d bytes", limits.MaxBytes),
+		})
+		return errBodyRefused
+	case err != nil:
+		_ = h.badRequest(w, "read body: "+err.Error())
+		return errBodyRefused
+	}
+
+	if err := checkJSON(body, limits); err != nil {
+		_ = h.badRequest(w, "bad json: "+err.Error())
+		return errBodyRefused
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		_ = h.badRequest(w, "bad json: "+err.Error())
+		return errBodyRefused
+	}
+	return nil
+}
+
+// checkJSON makes sure body holds exactly one JSON value within the nesting
+// and array limits, before it is decoded into anything.
+func checkJSON(body []byte, limits BodyLimits) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	// counts holds the element count of each open array, or -1 for objects.
+	var counts []int
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			if len(counts) > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return errors.New("empty body")
+		}
+		if err != nil {
+			return err
+		}
+		if tok == json.Delim(']') || tok == json.Delim('}') {
+			counts = counts[:len(counts)-1]
+		} else {
+			if n := len(counts); n > 0 && counts[n-1] >= 0 {
+				if counts[n-1]++; counts[n-1] > limits.MaxArray {
+					return fmt.Errorf("arrays may hold at most %d elements", limits.MaxArray)
+				}
+			}
+			if tok == json.Delim('[') || tok == json.Delim('{') {
+				if len(counts) == limits.MaxDepth {
+					return fmt.Errorf("nesting is limited to %d levels", limits.MaxDepth)
+				}
+				count := -1
+				if tok == json.Delim('[') {
+					count = 0
+				}
+				counts = append(counts, count)
+			}
+		}
+		if len(counts) == 0 {
+			break
+		}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after the JSON value")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func TestHTTP_RequestBodies(t *testing.T) {
+	opts := trustHeader
+	opts.Body = BodyLimits{MaxBytes: 256, MaxDepth: 3, MaxArray: 4}
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), opts))
+	defer srv.Close()
+
+	post := func(contentType, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/v1/classify", strings.NewReader(body))
+		req.Header.Set("X-User-ID", "alice")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	cases := []struct {
+		name, contentType, body string
+		want                    int
+	}{
+		{"json", "application/json", `{"properties": ["fur"]}`, http.StatusOK},
+		{"charset", "application/json; charset=utf-8", `{"properties": ["fur"]}`, http.StatusOK},
+		{"no content type", "", `{"properties": ["fur"]}`, http.StatusUnsupportedMediaType},
+		{"form", "application/x-www-form-urlencoded", `properties=fur`, http.StatusUnsupportedMediaType},
+		{"text", "text/plain", `{"properties": ["fur"]}`, http.StatusUnsupportedMediaType},
+		{"too large", "application/json", `{"properties": ["` + strings.Repeat("x", 300) + `"]}`, http.StatusRequestEntityTooLarge},
+		{"empty", "application/json", ``, http.StatusBadRequest},
+		{"trailing value", "application/json", `{"properties": []} {}`, http.StatusBadRequest},
+		{"trailing garbage", "application/json", `{"properties": []}]`, http.StatusBadRequest},
+		{"truncated", "application/json", `{"properties": [`, http.StatusBadRequest},
+		{"too deep", "application/json", `{"properties": [], "x": [[[]]]}`, http.StatusBadRequest},
+		{"long array", "application/json", `{"properties": ["a", "b", "c", "d", "e"]}`, http.StatusBadRequest},
+		{"wrong type", "application/json", `{"properties": "fur"}`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if got := post(c.contentType, c.body); got != c.want {
+			t.Errorf("%s: status=%d; want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCheckJSON(t *testing.T) {
+	limits := BodyLimits{MaxDepth: 2, MaxArray: 2}
+	ok := []string{`1`, `"s"`, `[]`, `{}`, `[1, 2]`, `{"a": [1, 2], "b": {"c": 1}}`, ` [ {} , [] ] `, `{"a": 1, "b": 2, "c": 3}`}
+	for _, body := range ok {
+		if err := checkJSON([]byte(body), limits); err != nil {
+			t.Errorf("checkJSON(%s): %v", body, err)
+		}
+	}
+	bad := []string{``, ` `, `[1, 2, 3]`, `[[[]]]`, `{"a": {"b": {}}}`, `[] []`, `{"a": 1} x`, `[1,`, `{"a"}`}
+	for _, body := range bad {
+		if err := checkJSON([]byte(body), limits); err == nil {
+			t.Errorf("checkJSON(%s) passed", body)
+		}
+	}
+}
@@ -1,120 +1,195 @@
 package handler
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"os"
 
+	"github.com/AntonKhPI2/self-learning-classifier/internal/auth"
 	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
 	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
 	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
 )
 
+// endpoint is a route's handler and the methods it serves, which preflight
+// requests are told about.
+type endpoint struct {
+	fn      func(http.ResponseWriter, *http.Request) error
+	methods []string
+}
+
+var (
+	get     = []string{http.MethodGet}
+	post    = []string{http.MethodPost}
+	del     = []string{http.MethodDelete}
+	getPost = []string{http.MethodGet, http.MethodPost}
+)
+
 type httpHandler struct {
-	repo repository.Repository
+	repo       repository.Repository
+	workspaces *service.Workspaces
+	opts       Options
 }
 
-func NewHTTPMux(repo repository.Repository) *http.ServeMux {
-	h := &httpHandler{repo: repo}
+func NewHTTPMux(repo repository.Repository, opts Options) *http.ServeMux {
+	if opts.Sessions == nil {
+		opts.Sessions = auth.NewRandomSessions(auth.DefaultSessionTTL)
+	}
+	if opts.AnonCookieName == "" {
+		opts.AnonCookieName = DefaultAnonCookieName
+	}
+	if opts.Body.MaxBytes <= 0 {
+		opts.Body.MaxBytes = DefaultMaxBodyBytes
+	}
+	if opts.Body.MaxDepth <= 0 {
+		opts.Body.MaxDepth = DefaultMaxJSONDepth
+	}
+	if opts.Body.MaxArray <= 0 {
+		opts.Body.MaxArray = DefaultMaxJSONArray
+	}
+	h := &httpHandler{repo: repo, workspaces: service.NewWorkspaces(repo), opts: opts}
+	h.workspaces.Quotas = opts.Quotas
 	mux := http.NewServeMux()
-	mux.Handle("/api/v1/reset", h.wrap(h.reset))
-	mux.Handle("/api/v1/prop/rename", h.wrap(h.propRename))
-	mux.Handle("/api/v1/init", h.wrap(h.init))
-	mux.Handle("/api/v1/classify", h.wrap(h.classify))
-	mux.Handle("/api/v1/feedback", h.wrap(h.feedback))
-	mux.Handle("/api/v1/state", h.wrap(h.state))
-	mux.Handle("/api/v1/prop/remove", h.wrap(h.propRemove))
-	mux.Handle("/api/v1/prop/move", h.wrap(h.propMove))
-	mux.Handle("/api/v1/classes/rename", h.wrap(h.renameClass))
-	mux.Handle("/api/v1/prop/add", h.wrap(h.propAdd))
+
+	// Classifier operations act on the workspace named in the path, in the
+	// X-Workspace-ID header, or else on the caller's default workspace.
+	ops := map[string]endpoint{
+		"reset":          {h.reset, post},
+		"prop/rename":    {h.propRename, post},
+		"init":           {h.init, post},
+		"classify":       {h.classify, post},
+		"feedback":       {h.feedback, post},
+		"state":          {h.state, get},
+		"prop/remove":    {h.propRemove, post},
+		"prop/move":      {h.propMove, post},
+		"classes/rename": {h.renameClass, post},
+		"prop/add":       {h.propAdd, post},
+		"usage":          {h.usage, get},
+		"audit":          {h.auditLog, get},
+	}
+	for path, e := range ops {
+		mux.Handle("/api/v1/"+path, h.wrap(e.fn, e.methods...))
+		mux.Handle("/api/v1/workspaces/{ws}/"+path, h.wrap(e.fn, e.methods...))
+	}
+	catalog := map[string]endpoint{
+		"/api/v1/workspaces":                       {h.workspaceCollection, getPost},
+		"/api/v1/workspaces/clone":                 {h.workspaceClone, post},
+		"/api/v1/workspaces/{ws}":                  {h.workspaceItem, []string{http.MethodGet, http.MethodDelete}},
+		"/api/v1/workspaces/{ws}/rename":           {h.workspaceRename, post},
+		"/api/v1/workspaces/{ws}/grants":           {h.grantCollection, getPost},
+		"/api/v1/workspaces/{ws}/grants/{user...}": {h.grantItem, del},
+		"/api/v1/workspaces/{ws}/links":            {h.linkCollection, getPost},
+		"/api/v1/workspaces/{ws}/links/{link}":     {h.linkItem, del},
+		"/api/v1/keys":                             {h.keyCollection, getPost},
+		"/api/v1/keys/{key}":                       {h.keyItem, del},
+		"/api/v1/claim":                            {h.claim, getPost},
+		"/api/v1/me":                               {h.eraseData, del},
+		"/api/v1/me/export":                        {h.exportData, get},
+	}
+	for path, e := range catalog {
+		mux.Handle(path, h.wrap(h.keyCatalog(e.fn), e.methods...))
+	}
+
+	// Share links expose classify and state only; every other operation under
+	// a token is refused.
+	mux.Handle("/api/v1/shared/{token}/classify", h.wrap(h.classify, post...))
+	mux.Handle("/api/v1/shared/{token}/state", h.wrap(h.state, get...))
+	mux.Handle("/api/v1/shared/{token}/{rest...}", h.wrap(h.sharedReadOnly, http.MethodGet, http.MethodPost, http.MethodDelete))
 
 	mux.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
 	})
+	if sr, ok := repo.(repository.StatsReporter); ok {
+		mux.HandleFunc("/status/cache", func(w http.ResponseWriter, _ *http.Request) {
+			_ = h.writeJSON(w, http.StatusOK, sr.Stats())
+		})
+	}
 
 	return mux
 }
 
 func (h *httpHandler) init(w http.ResponseWriter, r *http.Request) error {
-	if r.Method == http.MethodOptions {
-		return h.cors(w, r)
-	}
 	if r.Method != http.MethodPost {
 		return h.methodNotAllowed(w, r, http.MethodPost)
 	}
 
-	uid := getUserID(w, r)
-	svc := service.NewUserService(h.repo, uid)
+	svc, err := h.stateService(w, r, service.RoleOwner)
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
 
 	var req models.InitRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return h.badRequest(w, "bad json: "+err.Error())
+	if err := h.readJSON(w, r, &req); err != nil {
+		return err
 	}
 	if req.Class1.Name == "" || req.Class2.Name == "" {
 		return h.badRequest(w, "class names are required")
 	}
 
-	svc.Init(req.Class1, req.Class2)
+	if err := svc.Init(r.Context(), req.Class1, req.Class2); err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
 	return h.writeJSON(w, http.StatusOK, models.InitResponse{Ok: true})
 }
 
 func (h *httpHandler) reset(w http.ResponseWriter, r *http.Request) error {
-	if r.Method == http.MethodOptions {
-		return h.cors(w, r)
-	}
 	if r.Method != http.MethodPost {
 		return h.methodNotAllowed(w, r, http.MethodPost)
 	}
 
-	uid := getUserID(w, r)
-	svc := service.NewUserService(h.repo, uid)
+	svc, err := h.stateService(w, r, service.RoleOwner)
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
 
-	if err := svc.Reset(); err != nil {
-		return h.writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+	if err := svc.Reset(r.Context()); err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
 	}
 	return h.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
 func (h *httpHandler) classify(w http.ResponseWriter, r *http.Request) error {
-	if r.Method == http.MethodOptions {
-		return h.cors(w, r)
-	}
 	if r.Method != http.MethodPost {
 		return h.methodNotAllowed(w, r, http.MethodPost)
 	}
 
-	uid := getUserID(w, r)
-	svc := service.NewUserService(h.repo, uid)
+	svc, err := h.stateService(w, r, service.RoleViewer)
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
 
 	var req models.ClassifyRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return h.badRequest(w, "bad json: "+err.Error())
+	if err := h.readJSON(w, r, &req); err != nil {
+		return err
+	}
+	resp, err := svc.Classify(r.Context(), req.Properties)
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
 	}
-	resp := svc.Classify(req.Properties)
 	return h.writeJSON(w, http.StatusOK, resp)
 }
 
 func (h *httpHandler) feedback(w http.ResponseWriter, r *http.Request) error {
-	if r.Method == http.MethodOptions {
-		return h.cors(w, r)
-	}
 	if r.Method != http.MethodPost {
 		return h.methodNotAllowed(w, r, http.MethodPost)
 	}
 
-	uid := getUserID(w, r)
-	svc := service.NewUserService(h.repo, uid)
+	svc, err := h.stateService(w, r, service.RoleEditor)
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
 
 	var req models.FeedbackRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return h.badRequest(w, "bad json: "+err.Error())
+	if err := h.readJSON(w, r, &req); err != nil {
+		return err
 	}
 	switch req.Variant {
 	case "class1", "class2", "none":
-		svc.Feedback(req.Variant, req.Properties)
+		if err := svc.Feedback(r.Context(), req.Variant, req.Properties); err != nil {
+			return h.serviceError(w, err, http.StatusInternalServerError)
+		}
 		return h.writeJSON(w, http.StatusOK, models.FeedbackResponse{Ok: true})
 	default:
 		return h.badRequest(w, "variant must be one of: class1|class2|none")
@@ -122,25 +197,28 @@ func (h *httpHandler) feedback(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (h *httpHandler) state(w http.ResponseWriter, r *http.Request) error {
-	if r.Method == http.MethodOptions {
-		return h.cors(w, r)
-	}
 	if r.Method != http.MethodGet {
 		return h.methodNotAllowed(w, r, http.MethodGet)
 	}
 
-	uid := getUserID(w, r)
-	svc := service.NewUserService(h.repo, uid)
+	svc, err := h.stateService(w, r, service.RoleViewer)
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
 
-	return h.writeJSON(w, http.StatusOK, svc.Snapshot())
+	snap, err := svc.Snapshot(r.Context())
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	return h.writeJSON(w, http.StatusOK, snap)
 }
 
-func (h *httpHandler) wrap(fn func(http.ResponseWriter, *http.Request) error) http.Handler {
+func (h *httpHandler) wrap(fn func(http.ResponseWriter, *http.Request) error, methods ...string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-User-ID")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Vary", "Origin")
+		if h.applyCORS(w, r, methods) {
+			return
+		}
+		r = h.withRequestID(w, r)
 
 		defer func() {
 			if rec := recover(); rec != nil {
@@ -150,6 +228,17 @@ func (h *httpHandler) wrap(fn func(http.ResponseWriter, *http.Request) error) ht
 			}
 		}()
 
+		r, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+		if h.crossSite(r) {
+			_ = h.writeJSON(w, http.StatusForbidden, map[string]any{"error": errCrossSite.Error()})
+			return
+		}
+		if h.rateLimited(w, r) {
+			return
+		}
 		if err := fn(w, r); err != nil {
 
 			_ = err
@@ -165,6 +254,36 @@ func (h *httpHandler) writeJSON(w http.ResponseWriter, status int, v any) error
 	return enc.Encode(v)
 }
 
+func (h *httpHandler) serviceError(w http.ResponseWriter, err error, status int) error {
+	var quota *service.QuotaError
+	if errors.As(err, &quota) {
+		return h.writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error": err.Error(), "quota": quota.Quota, "limit": quota.Limit,
+		})
+	}
+	switch {
+	case errors.Is(err, service.ErrWorkspaceNotFound), errors.Is(err, service.ErrGrantNotFound),
+		errors.Is(err, service.ErrLinkNotFound), errors.Is(err, service.ErrKeyNotFound):
+		return h.writeJSON(w, http.StatusNotFound, map[string]any{"error": err.Error()})
+	case errors.Is(err, service.ErrLinkGone):
+		return h.writeJSON(w, http.StatusGone, map[string]any{"error": err.Error()})
+	case errors.Is(err, service.ErrForbidden), errors.Is(err, service.ErrKeyScope):
+		return h.writeJSON(w, http.StatusForbidden, map[string]any{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidName), errors.Is(err, service.ErrInvalidRole), errors.Is(err, service.ErrInvalidGrantee),
+		errors.Is(err, service.ErrInvalidExpiry), errors.Is(err, service.ErrInvalidExport),
+		errors.Is(err, service.ErrInvalidScope), errors.Is(err, service.ErrInvalidKeyName),
+		errors.Is(err, service.ErrInvalidClaim):
+		return h.badRequest(w, err.Error())
+	case errors.Is(err, repository.ErrStale):
+		return h.writeJSON(w, http.StatusConflict, map[string]any{"error": "classifier is being changed concurrently, try again"})
+	case errors.Is(err, repository.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return h.writeJSON(w, http.StatusGatewayTimeout, map[string]any{"error": "storage timed out"})
+	case errors.Is(err, context.Canceled):
+		return h.writeJSON(w, http.StatusServiceUnavailable, map[string]any{"error": "request cancelled"})
+	}
+	return h.writeJSON(w, status, map[string]any{"error": err.Error()})
+}
+
 func (h *httpHandler) badRequest(w http.ResponseWriter, msg string) error {
 	return h.writeJSON(w, http.StatusBadRequest, map[string]any{"error": msg})
 }
@@ -176,11 +295,6 @@ func (h *httpHandler) methodNotAllowed(w http.ResponseWriter, r *http.Request, a
 	})
 }
 
-func (h *httpHandler) cors(w http.ResponseWriter, _ *http.Request) error {
-	w.WriteHeader(http.StatusNoContent)
-	return nil
-}
-
 func joinAllow(methods []string) string {
 	switch len(methods) {
 	case 0:
@@ -196,38 +310,14 @@ func joinAllow(methods []string) string {
 	}
 }
 
-func getenvDefault(k, def string) string {
-	if v := os.Getenv(k); v != "" {
-		return v
-	}
-	return def
-}
-
-func randString() string {
-	b := make([]byte, 16)
-	_, _ = rand.Read(b)
-	return hex.EncodeToString(b)
-}
-
-func getUserID(w http.ResponseWriter, r *http.Request) string {
-	header := getenvDefault("USER_ID_HEADER", "X-User-ID")
-	if uid := r.Header.Get(header); uid != "" {
-		return uid
-	}
-	cookieName := getenvDefault("ANON_COOKIE_NAME", "slc_uid")
-	if c, err := r.Cookie(cookieName); err == nil && c.Value != "" {
-		return c.Value
+// userID returns the authenticated caller, or starts an anonymous session
+// for a caller without one.
+func (h *httpHandler) userID(w http.ResponseWriter, r *http.Request) string {
+	if id, ok := identityFrom(r); ok {
+		return id.userID
 	}
-
-	uid := randString()
-	http.SetCookie(w, &http.Cookie{
-		Name:     cookieName,
-		Value:    uid,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   isHTTPSRequest(r),
-		SameSite: http.SameSiteLaxMode,
-	})
+	uid, value := h.opts.Sessions.New()
+	h.setSessionCookie(w, r, value)
 	return uid
 }
 
@@ -236,113 +326,113 @@ func isHTTPSRequest(r *http.Request) bool {
 }
 
 func (h *httpHandler) propRemove(w http.ResponseWriter, r *http.Request) error {
-	if r.Method == http.MethodOptions {
-		return h.cors(w, r)
-	}
 	if r.Method != http.MethodPost {
 		return h.methodNotAllowed(w, r, http.MethodPost)
 	}
 
 	var req models.RemovePropertyRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return h.badRequest(w, "bad json: "+err.Error())
+	if err := h.readJSON(w, r, &req); err != nil {
+		return err
 	}
 	if req.Property == "" {
 		return h.badRequest(w, "property is required")
 	}
-	svc := service.NewUserService(h.repo, getUserID(w, r))
-	if err := svc.RemoveProperty(req.Area, req.Property); err != nil {
-		return h.writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+	svc, err := h.stateService(w, r, service.RoleEditor)
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	if err := svc.RemoveProperty(r.Context(), req.Area, req.Property); err != nil {
+		return h.serviceError(w, err, http.StatusBadRequest)
 	}
 	return h.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
 func (h *httpHandler) propMove(w http.ResponseWriter, r *http.Request) error {
-	if r.Method == http.MethodOptions {
-		return h.cors(w, r)
-	}
 	if r.Method != http.MethodPost {
 		return h.methodNotAllowed(w, r, http.MethodPost)
 	}
 
 	var req models.MovePropertyRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return h.badRequest(w, "bad json: "+err.Error())
+	if err := h.readJSON(w, r, &req); err != nil {
+		return err
 	}
 	if req.Property == "" {
 		return h.badRequest(w, "property is required")
 	}
-	svc := service.NewUserService(h.repo, getUserID(w, r))
-	if err := svc.MoveProperty(req.From, req.To, req.Property); err != nil {
-		return h.writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+	svc, err := h.stateService(w, r, service.RoleEditor)
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	if err := svc.MoveProperty(r.Context(), req.From, req.To, req.Property); err != nil {
+		return h.serviceError(w, err, http.StatusBadRequest)
 	}
 	return h.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
 func (h *httpHandler) renameClass(w http.ResponseWriter, r *http.Request) error {
-	if r.Method == http.MethodOptions {
-		return h.cors(w, r)
-	}
 	if r.Method != http.MethodPost {
 		return h.methodNotAllowed(w, r, http.MethodPost)
 	}
 
 	var req models.RenameClassRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return h.badRequest(w, "bad json: "+err.Error())
+	if err := h.readJSON(w, r, &req); err != nil {
+		return err
 	}
 	if req.Name == "" {
 		return h.badRequest(w, "name is required")
 	}
-	svc := service.NewUserService(h.repo, getUserID(w, r))
-	if err := svc.RenameClass(req.Class, req.Name); err != nil {
-		return h.writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+	svc, err := h.stateService(w, r, service.RoleEditor)
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	if err := svc.RenameClass(r.Context(), req.Class, req.Name); err != nil {
+		return h.serviceError(w, err, http.StatusBadRequest)
 	}
 	return h.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
 func (h *httpHandler) propRename(w http.ResponseWriter, r *http.Request) error {
-	if r.Method == http.MethodOptions {
-		return h.cors(w, r)
-	}
 	if r.Method != http.MethodPost {
 		return h.methodNotAllowed(w, r, http.MethodPost)
 	}
 
 	var req models.RenamePropertyRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return h.badRequest(w, "bad json: "+err.Error())
+	if err := h.readJSON(w, r, &req); err != nil {
+		return err
 	}
 	if req.From == "" || req.To == "" {
 		return h.badRequest(w, "both 'from' and 'to' are required")
 	}
 
-	svc := service.NewUserService(h.repo, getUserID(w, r))
-	if err := svc.RenameProperty(req.Area, req.From, req.To); err != nil {
-		return h.writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+	svc, err := h.stateService(w, r, service.RoleEditor)
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	if err := svc.RenameProperty(r.Context(), req.Area, req.From, req.To); err != nil {
+		return h.serviceError(w, err, http.StatusBadRequest)
 	}
 	return h.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
 func (h *httpHandler) propAdd(w http.ResponseWriter, r *http.Request) error {
-	if r.Method == http.MethodOptions {
-		return h.cors(w, r)
-	}
 	if r.Method != http.MethodPost {
 		return h.methodNotAllowed(w, r, http.MethodPost)
 	}
 
 	var req models.AddPropertyRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return h.badRequest(w, "bad json: "+err.Error())
+	if err := h.readJSON(w, r, &req); err != nil {
+		return err
 	}
 	if req.Property == "" {
 		return h.badRequest(w, "property is required")
 	}
 
-	svc := service.NewUserService(h.repo, getUserID(w, r))
-	if err := svc.AddProperty(req.Area, req.Property); err != nil {
-		return h.writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+	svc, err := h.stateService(w, r, service.RoleEditor)
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	if err := svc.AddProperty(r.Context(), req.Area, req.Property); err != nil {
+		return h.serviceError(w, err, http.StatusBadRequest)
 	}
 	return h.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
@@ -2,34 +2,19 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
 	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
 )
 
-type mockRepo struct {
-	state map[string]repository.State
-}
-
-func newMockRepo() *mockRepo { return &mockRepo{state: make(map[string]repository.State)} }
-
-func (m *mockRepo) GetState(userID string) (repository.State, error) {
-	return m.state[userID], nil
-}
-func (m *mockRepo) UpsertState(userID string, st repository.State) error {
-	m.state[userID] = st
-	return nil
-}
-func (m *mockRepo) ResetUser(userID string) error {
-	delete(m.state, userID)
-	return nil
-}
-
 func decode[T any](t *testing.T, resp *http.Response, out *T) {
 	t.Helper()
 	defer resp.Body.Close()
@@ -39,7 +24,7 @@ func decode[T any](t *testing.T, resp *http.Response, out *T) {
 }
 
 func TestHTTP_Flow(t *testing.T) {
-	mux := NewHTTPMux(newMockRepo())
+	mux := NewHTTPMux(repository.NewMemory(), Options{})
 	srv := httptest.NewServer(mux)
 	defer srv.Close()
 
@@ -118,3 +103,25 @@ func TestHTTP_Flow(t *testing.T) {
 	}
 	resp.Body.Close()
 }
+
+type slowRepo struct{ *repository.MemoryRepo }
+
+func (s *slowRepo) GetState(ctx context.Context, userID string) (repository.State, error) {
+	<-ctx.Done()
+	return repository.State{}, fmt.Errorf("%w: %v", repository.ErrTimeout, ctx.Err())
+}
+
+func TestHTTP_StorageTimeoutMapsTo504(t *testing.T) {
+	mux := NewHTTPMux(&slowRepo{MemoryRepo: repository.NewMemory()}, trustHeader)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/state", nil)
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Millisecond)
+	defer cancel()
+	req = req.WithContext(ctx)
+	req.Header.Set("X-User-ID", "u1")
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status=%d; want 504", rec.Code)
+	}
+}
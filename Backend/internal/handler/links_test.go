@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func anonRequest(t *testing.T, srv *httptest.Server, method, path string, body any) *http.Response {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, srv.URL+path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestHTTP_ShareLinksAreReadOnly(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), trustHeader))
+	defer srv.Close()
+
+	var ws repository.Workspace
+	decode(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces", "owner", models.WorkspaceRequest{Name: "docs"}), &ws)
+	base := "/api/v1/workspaces/" + ws.ID
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/init", "owner", models.InitRequest{
+		Class1: models.Class{Name: "Bug", Properties: []string{"crash"}}, Class2: models.Class{Name: "Feature"},
+	}), http.StatusOK, "init")
+
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/links", "stranger", models.ShareLinkRequest{}), http.StatusNotFound, "stranger creates link")
+
+	var created struct {
+		Link  repository.ShareLink `json:"link"`
+		Token string               `json:"token"`
+		Path  string               `json:"path"`
+	}
+	resp := wsRequest(t, srv, http.MethodPost, base+"/links", "owner", models.ShareLinkRequest{})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create link status=%d", resp.StatusCode)
+	}
+	decode(t, resp, &created)
+	if len(created.Token) < 40 || created.Path != "/api/v1/shared/"+created.Token {
+		t.Fatalf("created=%+v", created)
+	}
+
+	resp = anonRequest(t, srv, http.MethodPost, created.Path+"/classify", models.ClassifyRequest{Properties: []string{"crash"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("shared classify status=%d", resp.StatusCode)
+	}
+	if len(resp.Cookies()) != 0 {
+		t.Fatalf("shared route must not set cookies: %v", resp.Cookies())
+	}
+	var cl models.ClassifyResponse
+	decode(t, resp, &cl)
+	if cl.Guess != "Bug" {
+		t.Fatalf("guess=%q; want Bug", cl.Guess)
+	}
+	expectStatus(t, anonRequest(t, srv, http.MethodGet, created.Path+"/state", nil), http.StatusOK, "shared state")
+
+	for _, path := range []string{"/feedback", "/init", "/reset", "/prop/add", "/classes/rename", "/anything"} {
+		expectStatus(t, anonRequest(t, srv, http.MethodPost, created.Path+path, struct{}{}), http.StatusForbidden, "shared "+path)
+	}
+	expectStatus(t, anonRequest(t, srv, http.MethodGet, "/api/v1/shared/not-a-token/state", nil), http.StatusNotFound, "unknown token")
+
+	var links struct {
+		Links []repository.ShareLink `json:"links"`
+	}
+	decode(t, wsRequest(t, srv, http.MethodGet, base+"/links", "owner", nil), &links)
+	if len(links.Links) != 1 || links.Links[0].ID != created.Link.ID {
+		t.Fatalf("links=%+v", links.Links)
+	}
+
+	expectStatus(t, wsRequest(t, srv, http.MethodDelete, base+"/links/"+created.Link.ID, "owner", nil), http.StatusOK, "revoke")
+	expectStatus(t, anonRequest(t, srv, http.MethodGet, created.Path+"/state", nil), http.StatusGone, "state after revoke")
+	expectStatus(t, wsRequest(t, srv, http.MethodDelete, base+"/links/lnk_missing", "owner", nil), http.StatusNotFound, "revoke unknown")
+
+	past := time.Now().Add(-time.Minute)
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/links", "owner", models.ShareLinkRequest{ExpiresAt: &past}), http.StatusBadRequest, "expiry in the past")
+}
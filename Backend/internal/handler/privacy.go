@@ -0,0 +1,42 @@
+package handler
+
+import "net/http"
+
+// exportData downloads everything stored about the caller.
+func (h *httpHandler) exportData(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return h.methodNotAllowed(w, r, http.MethodGet)
+	}
+	data, err := h.workspaces.Export(r.Context(), h.userID(w, r))
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	body, err := data.Encode()
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="slc-export.json"`)
+	_, err = w.Write(body)
+	return err
+}
+
+// eraseData deletes everything stored about the caller and reports what went.
+// An anonymous caller's session ends with it.
+func (h *httpHandler) eraseData(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodDelete {
+		return h.methodNotAllowed(w, r, http.MethodDelete)
+	}
+	data, err := h.workspaces.Export(r.Context(), h.userID(w, r))
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	rep, err := h.workspaces.Erase(r.Context(), data)
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	if id, ok := identityFrom(r); !ok || id.via == "cookie" {
+		h.endSession(w, r)
+	}
+	return h.writeJSON(w, http.StatusOK, rep)
+}
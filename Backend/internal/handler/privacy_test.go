@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
+)
+
+func TestHTTP_ExportAndErase(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), trustHeader))
+	defer srv.Close()
+
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, "/api/v1/init", "alice", models.InitRequest{
+		Class1: models.Class{Name: "Cat"}, Class2: models.Class{Name: "Owl"},
+	}), http.StatusOK, "init")
+	expectStatus(t, wsRequest(t, srv, http.MethodGet, "/api/v1/state", "bob", nil), http.StatusOK, "bob's state")
+
+	resp := wsRequest(t, srv, http.MethodGet, "/api/v1/me/export", "alice", nil)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Disposition"), "attachment") {
+		t.Fatalf("export status=%d headers=%v", resp.StatusCode, resp.Header)
+	}
+	var data service.UserData
+	if err := json.Unmarshal(body, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.UserID != "alice" || len(data.Workspaces) != 1 || data.Workspaces[0].State.Class1.Name != "Cat" {
+		t.Fatalf("export=%s", body)
+	}
+
+	var rep service.ErasureReport
+	decode(t, wsRequest(t, srv, http.MethodDelete, "/api/v1/me", "alice", nil), &rep)
+	sum := sha256.Sum256(body)
+	if !rep.Verified || rep.Erased.Workspaces != 1 || rep.Digest != hex.EncodeToString(sum[:]) {
+		t.Fatalf("report=%+v; want digest of the export", rep)
+	}
+
+	decode(t, wsRequest(t, srv, http.MethodGet, "/api/v1/me/export", "alice", nil), &data)
+	if len(data.Workspaces) != 0 {
+		t.Fatalf("export after erase=%+v", data)
+	}
+	decode(t, wsRequest(t, srv, http.MethodGet, "/api/v1/me/export", "bob", nil), &data)
+	if len(data.Workspaces) != 1 {
+		t.Fatalf("bob's export=%+v", data)
+	}
+}
+
+func TestHTTP_EraseEndsAnonymousSession(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), Options{}))
+	defer srv.Close()
+
+	resp := cookieRequest(t, srv, http.MethodPost, "/api/v1/init", nil, models.InitRequest{
+		Class1: models.Class{Name: "Cat"}, Class2: models.Class{Name: "Owl"},
+	})
+	expectStatus(t, resp, http.StatusOK, "init")
+	session := sessionCookie(resp)
+
+	resp = cookieRequest(t, srv, http.MethodDelete, "/api/v1/me", session, nil)
+	var rep service.ErasureReport
+	cleared := sessionCookie(resp)
+	decode(t, resp, &rep)
+	if !rep.Verified || rep.Erased.Workspaces != 1 {
+		t.Fatalf("report=%+v", rep)
+	}
+	if cleared == nil || cleared.MaxAge >= 0 {
+		t.Fatalf("session cookie=%+v; want it cleared", cleared)
+	}
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateLimited charges the request to its caller's read or write budget and
+// writes a 429 when the budget is spent. Callers are told their standing in
+// RateLimit-* headers either way.
+func (h *httpHandler) rateLimited(w http.ResponseWriter, r *http.Request) bool {
+	l := h.opts.WriteLimit
+	if isRead(r) {
+		l = h.opts.ReadLimit
+	}
+	if l == nil {
+		return false
+	}
+	res := l.Allow(h.rateKey(r, l.Period()))
+
+	hdr := w.Header()
+	hdr.Set("RateLimit-Policy", strconv.Itoa(l.Limit())+";w="+strconv.Itoa(int(l.Period().Seconds())))
+	hdr.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	hdr.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	hdr.Set("RateLimit-Reset", seconds(res.Reset))
+	if res.Allowed {
+		return false
+	}
+	hdr.Set("Retry-After", seconds(res.RetryAfter))
+	_ = h.writeJSON(w, http.StatusTooManyRequests, map[string]any{"error": "rate limit exceeded"})
+	return true
+}
+
+// isRead reports whether a request only reads state. Classifying is a POST
+// but stores nothing.
+func isRead(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || strings.HasSuffix(r.URL.Path, "/classify")
+}
+
+// rateKey is who a request is charged to: the API key, the user, the
+// anonymous session, the share link, or else the client address, so that
+// dropping the cookie does not buy a fresh budget. A session issued less than
+// a window ago is charged to the address too, or taking a new one with every
+// few requests would.
+func (h *httpHandler) rateKey(r *http.Request, window time.Duration) string {
+	if id, ok := identityFrom(r); ok {
+		switch {
+		case id.key != nil:
+			return "key:" + id.key.ID
+		case id.via != "cookie":
+			return "user:" + id.userID
+		case time.Since(id.issued) >= window:
+			return "anon:" + id.userID
+		}
+	} else if token := r.PathValue("token"); token != "" {
+		return "link:" + token
+	}
+	return "addr:" + h.clientAddr(r)
+}
+
+// clientAddr is the address r came from: the peer, or if the peer is a
+// trusted proxy, the last X-Forwarded-For hop that is not one. Hops before
+// that were written by the client and are ignored.
+func (h *httpHandler) clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops); h.trustedProxy(host) && i > 0; i-- {
+		hop := strings.TrimSpace(hops[i-1])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		host = hop
+	}
+	return host
+}
+
+func (h *httpHandler) trustedProxy(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range h.opts.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// seconds rounds up, so a client that waits that long is let through.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/ratelimit"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func TestHTTP_RateLimits(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), Options{
+		TrustedUserHeader: "X-User-ID",
+		ReadLimit:         ratelimit.New(3, time.Minute),
+		WriteLimit:        ratelimit.New(2, time.Minute),
+	}))
+	defer srv.Close()
+	as := func(user string) map[string]string { return map[string]string{"X-User-ID": user} }
+
+	for i := 0; i < 2; i++ {
+		resp := bearerRequest(t, srv, http.MethodPost, "/api/v1/reset", "", as("alice"))
+		if resp.Header.Get("RateLimit-Limit") != "2" || resp.Header.Get("RateLimit-Policy") != "2;w=60" {
+			t.Fatalf("write %d headers=%v", i+1, resp.Header)
+		}
+		expectStatus(t, resp, http.StatusOK, "write within budget")
+	}
+	resp := bearerRequest(t, srv, http.MethodPost, "/api/v1/reset", "", as("alice"))
+	if resp.Header.Get("Retry-After") != "30" || resp.Header.Get("RateLimit-Remaining") != "0" || resp.Header.Get("RateLimit-Reset") != "60" {
+		t.Fatalf("429 headers=%v", resp.Header)
+	}
+	expectStatus(t, resp, http.StatusTooManyRequests, "write over budget")
+
+	// Reads, including classify, have their own budget, and other users
+	// their own buckets.
+	expectStatus(t, bearerRequest(t, srv, http.MethodGet, "/api/v1/state", "", as("alice")), http.StatusOK, "read")
+	expectStatus(t, bearerRequest(t, srv, http.MethodPost, "/api/v1/classify", "", as("alice")), http.StatusUnsupportedMediaType, "classify")
+	expectStatus(t, bearerRequest(t, srv, http.MethodGet, "/api/v1/state", "", as("alice")), http.StatusOK, "read")
+	expectStatus(t, bearerRequest(t, srv, http.MethodGet, "/api/v1/state", "", as("alice")), http.StatusTooManyRequests, "read over budget")
+	expectStatus(t, bearerRequest(t, srv, http.MethodPost, "/api/v1/reset", "", as("bob")), http.StatusOK, "another user")
+
+	// Visitors without a session share their address's budget, so dropping
+	// the cookie does not reset it.
+	for i := 0; i < 2; i++ {
+		expectStatus(t, bearerRequest(t, srv, http.MethodPost, "/api/v1/reset", "", nil), http.StatusOK, "cookieless write")
+	}
+	expectStatus(t, bearerRequest(t, srv, http.MethodPost, "/api/v1/reset", "", nil), http.StatusTooManyRequests, "cookieless write over budget")
+}
+
+func TestHTTP_RateLimitsNewSessionsByAddress(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), Options{WriteLimit: ratelimit.New(3, time.Minute)}))
+	defer srv.Close()
+
+	// Dropping the cookie before every other request and writing with the
+	// session it returns must not buy a budget per session.
+	statuses := make([]int, 0, 4)
+	for i := 0; i < 2; i++ {
+		resp := cookieRequest(t, srv, http.MethodPost, "/api/v1/reset", nil, nil)
+		session := sessionCookie(resp)
+		resp.Body.Close()
+		if session == nil {
+			t.Fatalf("cookieless request %d got no session", i+1)
+		}
+		statuses = append(statuses, resp.StatusCode)
+		resp = cookieRequest(t, srv, http.MethodPost, "/api/v1/reset", session, nil)
+		resp.Body.Close()
+		statuses = append(statuses, resp.StatusCode)
+	}
+	want := []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	if !slices.Equal(statuses, want) {
+		t.Fatalf("statuses=%v; want %v", statuses, want)
+	}
+}
+
+func TestHTTP_RateLimitsByForwardedAddress(t *testing.T) {
+	from := func(hops string) map[string]string { return map[string]string{"X-Forwarded-For": hops} }
+
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), Options{
+		WriteLimit:     ratelimit.New(1, time.Minute),
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+	}))
+	defer srv.Close()
+	expectStatus(t, bearerRequest(t, srv, http.MethodPost, "/api/v1/reset", "", from("203.0.113.1")), http.StatusOK, "first client")
+	expectStatus(t, bearerRequest(t, srv, http.MethodPost, "/api/v1/reset", "", from("203.0.113.2")), http.StatusOK, "second client behind the proxy")
+	// A hop the client wrote itself is ahead of the one the proxy added.
+	expectStatus(t, bearerRequest(t, srv, http.MethodPost, "/api/v1/reset", "", from("198.51.100.7, 203.0.113.1")), http.StatusTooManyRequests, "spoofed hop")
+
+	// Without a trusted proxy the header is the client's own and ignored.
+	untrusted := httptest.NewServer(NewHTTPMux(repository.NewMemory(), Options{WriteLimit: ratelimit.New(1, time.Minute)}))
+	defer untrusted.Close()
+	expectStatus(t, bearerRequest(t, untrusted, http.MethodPost, "/api/v1/reset", "", from("203.0.113.1")), http.StatusOK, "direct client")
+	expectStatus(t, bearerRequest(t, untrusted, http.MethodPost, "/api/v1/reset", "", from("203.0.113.2")), http.StatusTooManyRequests, "direct client with a forged header")
+}
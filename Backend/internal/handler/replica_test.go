@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func newReplica(t *testing.T, ctx context.Context, shared *repository.MemoryRepo, interval time.Duration) *httptest.Server {
+	t.Helper()
+	cached := repository.NewCached(shared, 16, time.Hour)
+	inv := repository.NewInvalidator(cached, shared, interval)
+	inv.Poll(ctx)
+	go inv.Run(ctx)
+	srv := httptest.NewServer(NewHTTPMux(cached, trustHeader))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func replicaState(t *testing.T, srv *httptest.Server) models.Snapshot {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/state", nil)
+	req.Header.Set("X-User-ID", "shared-user")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap models.Snapshot
+	decode(t, resp, &snap)
+	return snap
+}
+
+func replicaPost(t *testing.T, srv *httptest.Server, path string, body any) {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+path, bytes.NewReader(b))
+	req.Header.Set("X-User-ID", "shared-user")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s status=%d", path, resp.StatusCode)
+	}
+}
+
+func TestReplicas_CacheConvergesAfterRemoteWrite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const interval = 20 * time.Millisecond
+	shared := repository.NewMemory()
+	a := newReplica(t, ctx, shared, interval)
+	b := newReplica(t, ctx, shared, interval)
+
+	replicaPost(t, a, "/api/v1/init", models.InitRequest{
+		Class1: models.Class{Name: "Cat", Properties: []string{"purr"}},
+		Class2: models.Class{Name: "Dog", Properties: []string{"bark"}},
+	})
+	if snap := replicaState(t, b); snap.Class1.Name != "Cat" {
+		t.Fatalf("replica b state=%+v; want Cat", snap)
+	}
+
+	replicaPost(t, a, "/api/v1/classes/rename", models.RenameClassRequest{Class: "class1", Name: "Kitten"})
+
+	deadline := time.Now().Add(20 * interval)
+	for {
+		snap := replicaState(t, b)
+		if snap.Class1.Name == "Kitten" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replica b still serves stale state %q after %s", snap.Class1.Name, 20*interval)
+		}
+		time.Sleep(interval / 2)
+	}
+
+	replicaPost(t, b, "/api/v1/reset", struct{}{})
+	deadline = time.Now().Add(20 * interval)
+	for replicaState(t, a).Class1.Name != "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("replica a did not observe reset from replica b")
+		}
+		time.Sleep(interval / 2)
+	}
+}
+
+func TestReplicas_ConcurrentWritesAreNotLost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The invalidators never poll within the test, so each replica keeps
+	// serving whatever it cached.
+	shared := repository.NewMemory()
+	a := newReplica(t, ctx, shared, time.Hour)
+	b := newReplica(t, ctx, shared, time.Hour)
+
+	replicaPost(t, a, "/api/v1/init", models.InitRequest{
+		Class1: models.Class{Name: "Cat", Properties: []string{"purr"}},
+		Class2: models.Class{Name: "Dog", Properties: []string{"bark"}},
+	})
+	replicaState(t, b)
+	replicaPost(t, a, "/api/v1/feedback", models.FeedbackRequest{Variant: "class1", Properties: []string{"whiskers"}})
+	replicaPost(t, b, "/api/v1/feedback", models.FeedbackRequest{Variant: "class2", Properties: []string{"fetch"}})
+
+	const writes = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, writes)
+	for i := 0; i < writes; i++ {
+		srv := a
+		if i%2 == 1 {
+			srv = b
+		}
+		wg.Add(1)
+		go func(i int, srv *httptest.Server) {
+			defer wg.Done()
+			b, _ := json.Marshal(models.AddPropertyRequest{Area: "none", Property: fmt.Sprintf("p%d", i)})
+			req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/v1/prop/add", bytes.NewReader(b))
+			req.Header.Set("X-User-ID", "shared-user")
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				errs <- err
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				errs <- fmt.Errorf("add p%d: status=%d", i, resp.StatusCode)
+			}
+		}(i, srv)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	st, err := shared.GetState(ctx, sharedUserWorkspace(t, ctx, shared))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(st.Class1.Properties, "whiskers") || !slices.Contains(st.Class2.Properties, "fetch") || len(st.NoneClass) != writes {
+		t.Fatalf("shared state=%+v; want every replica's writes", st)
+	}
+}
+
+func sharedUserWorkspace(t *testing.T, ctx context.Context, repo repository.Repository) string {
+	t.Helper()
+	list, err := repo.ListWorkspaces(ctx, "shared-user")
+	if err != nil || len(list) != 1 {
+		t.Fatalf("workspaces=%+v err=%v", list, err)
+	}
+	return list[0].ID
+}
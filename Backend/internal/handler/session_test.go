@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/auth"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
+)
+
+func cookieRequest(t *testing.T, srv *httptest.Server, method, path string, cookie *http.Cookie, body any) *http.Response {
+	t.Helper()
+	var rd io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		rd = bytes.NewReader(b)
+	}
+	req, _ := http.NewRequest(method, srv.URL+path, rd)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func sessionCookie(resp *http.Response) *http.Cookie {
+	for _, c := range resp.Cookies() {
+		if c.Name == DefaultAnonCookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestHTTP_AnonymousSessionsAreSigned(t *testing.T) {
+	sessions, err := auth.NewSessions([][]byte{[]byte("0123456789abcdef0123456789abcdef")}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), Options{Sessions: sessions}))
+	defer srv.Close()
+
+	resp := cookieRequest(t, srv, http.MethodPost, "/api/v1/init", nil, models.InitRequest{
+		Class1: models.Class{Name: "Cat"}, Class2: models.Class{Name: "Dog"},
+	})
+	expectStatus(t, resp, http.StatusOK, "init")
+	session := sessionCookie(resp)
+	if session == nil || !session.HttpOnly || session.MaxAge != int(time.Hour.Seconds()) {
+		t.Fatalf("session cookie=%+v", session)
+	}
+	uid, _, err := sessions.Verify(session.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var snap models.Snapshot
+	resp = cookieRequest(t, srv, http.MethodGet, "/api/v1/state", session, nil)
+	if sessionCookie(resp) != nil {
+		t.Fatalf("a fresh session must not be re-issued")
+	}
+	decode(t, resp, &snap)
+	if snap.Class1.Name != "Cat" {
+		t.Fatalf("state with the signed cookie=%+v", snap)
+	}
+
+	// A bare user ID, as cookies used to carry, and a forged value are both
+	// replaced by a new session instead of being trusted.
+	for name, value := range map[string]string{
+		"unsigned": uid,
+		"forged":   auth.NewRandomSessions(time.Hour).Sign(uid),
+	} {
+		resp := cookieRequest(t, srv, http.MethodGet, "/api/v1/state", &http.Cookie{Name: DefaultAnonCookieName, Value: value}, nil)
+		fresh := sessionCookie(resp)
+		decode(t, resp, &snap)
+		if fresh == nil || fresh.Value == session.Value || snap.Class1.Name != "" {
+			t.Fatalf("%s cookie: new cookie=%+v state=%+v", name, fresh, snap)
+		}
+	}
+}
+
+func TestHTTP_LegacyCookieIsResignedOnce(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	sessions, err := auth.NewSessions([][]byte{[]byte("0123456789abcdef0123456789abcdef")}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewHTTPMux(repo, Options{Sessions: sessions}))
+	defer srv.Close()
+
+	// A visitor from before cookies were signed, whose cookie was the ID.
+	const legacy = "00112233445566778899aabbccddeeff"
+	ws, err := service.NewWorkspaces(repo).Default(ctx, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpsertState(ctx, ws.ID, repository.State{Class1: models.Class{Name: "Cat"}, Class2: models.Class{Name: "Dog"}}); err != nil {
+		t.Fatal(err)
+	}
+	old := &http.Cookie{Name: DefaultAnonCookieName, Value: legacy}
+
+	var snap models.Snapshot
+	resp := cookieRequest(t, srv, http.MethodGet, "/api/v1/state", old, nil)
+	signed := sessionCookie(resp)
+	decode(t, resp, &snap)
+	if signed == nil || snap.Class1.Name != "Cat" {
+		t.Fatalf("legacy cookie: new cookie=%+v state=%+v", signed, snap)
+	}
+	if uid, _, err := sessions.Verify(signed.Value); err != nil || uid != legacy {
+		t.Fatalf("re-signed cookie carries %q, %v; want %q", uid, err, legacy)
+	}
+	resp = cookieRequest(t, srv, http.MethodGet, "/api/v1/state", signed, nil)
+	decode(t, resp, &snap)
+	if snap.Class1.Name != "Cat" {
+		t.Fatalf("state with the re-signed cookie=%+v", snap)
+	}
+
+	// A second copy of the unsigned value, and one that owns nothing, start
+	// new sessions.
+	for name, c := range map[string]*http.Cookie{
+		"replayed": old,
+		"unknown":  {Name: DefaultAnonCookieName, Value: "ffeeddccbbaa99887766554433221100"},
+	} {
+		resp := cookieRequest(t, srv, http.MethodGet, "/api/v1/state", c, nil)
+		fresh := sessionCookie(resp)
+		decode(t, resp, &snap)
+		if fresh == nil || snap.Class1.Name != "" {
+			t.Fatalf("%s cookie: new cookie=%+v state=%+v", name, fresh, snap)
+		}
+		if uid, _, _ := sessions.Verify(fresh.Value); uid == c.Value {
+			t.Fatalf("%s cookie was re-signed", name)
+		}
+	}
+}
+
+func TestHTTP_AnonymousWorkspacesAreMarked(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), trustHeader))
+	defer srv.Close()
+
+	var anon, member struct {
+		Workspaces []repository.Workspace `json:"workspaces"`
+	}
+	decode(t, cookieRequest(t, srv, http.MethodGet, "/api/v1/workspaces", nil, nil), &anon)
+	decode(t, wsRequest(t, srv, http.MethodGet, "/api/v1/workspaces", "member", nil), &member)
+	if len(anon.Workspaces) != 1 || !anon.Workspaces[0].Anonymous {
+		t.Fatalf("visitor workspaces=%+v; want one anonymous", anon.Workspaces)
+	}
+	if len(member.Workspaces) != 1 || member.Workspaces[0].Anonymous {
+		t.Fatalf("member workspaces=%+v; want one not anonymous", member.Workspaces)
+	}
+}
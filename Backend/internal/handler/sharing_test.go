@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
+)
+
+func expectStatus(t *testing.T, resp *http.Response, want int, what string) {
+	t.Helper()
+	resp.Body.Close()
+	if resp.StatusCode != want {
+		t.Fatalf("%s: status=%d; want %d", what, resp.StatusCode, want)
+	}
+}
+
+func TestHTTP_SharingEnforcesRoles(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), trustHeader))
+	defer srv.Close()
+
+	var ws repository.Workspace
+	decode(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces", "owner", models.WorkspaceRequest{Name: "triage"}), &ws)
+	base := "/api/v1/workspaces/" + ws.ID
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/init", "owner", models.InitRequest{
+		Class1: models.Class{Name: "Urgent", Properties: []string{"asap"}}, Class2: models.Class{Name: "Routine"},
+	}), http.StatusOK, "owner init")
+
+	classify := models.ClassifyRequest{Properties: []string{"asap"}}
+	feedback := models.FeedbackRequest{Variant: "class1", Properties: []string{"today"}}
+
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/classify", "alice", classify), http.StatusNotFound, "classify before share")
+
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/grants", "owner", models.GrantRequest{UserID: "alice", Role: "viewer"}), http.StatusOK, "share viewer")
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/classify", "alice", classify), http.StatusOK, "viewer classify")
+	expectStatus(t, wsRequest(t, srv, http.MethodGet, base+"/state", "alice", nil), http.StatusOK, "viewer state")
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/feedback", "alice", feedback), http.StatusForbidden, "viewer feedback")
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/prop/add", "alice", models.AddPropertyRequest{Area: "general", Property: "x"}), http.StatusForbidden, "viewer prop add")
+
+	var list struct {
+		Workspaces []service.Access `json:"workspaces"`
+	}
+	decode(t, wsRequest(t, srv, http.MethodGet, "/api/v1/workspaces", "alice", nil), &list)
+	if n := len(list.Workspaces); n != 2 || list.Workspaces[1].ID != ws.ID || list.Workspaces[1].Role != service.RoleViewer {
+		t.Fatalf("alice's workspaces=%+v", list.Workspaces)
+	}
+
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/grants", "owner", models.GrantRequest{UserID: "alice", Role: "editor"}), http.StatusOK, "upgrade to editor")
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/feedback", "alice", feedback), http.StatusOK, "editor feedback")
+	for _, path := range []string{"/init", "/reset", "/rename", "/grants"} {
+		expectStatus(t, wsRequest(t, srv, http.MethodPost, base+path, "alice", models.GrantRequest{UserID: "bob", Role: "viewer"}), http.StatusForbidden, "editor "+path)
+	}
+	expectStatus(t, wsRequest(t, srv, http.MethodDelete, base, "alice", nil), http.StatusForbidden, "editor delete")
+
+	var grants struct {
+		Grants []repository.Grant `json:"grants"`
+	}
+	decode(t, wsRequest(t, srv, http.MethodGet, base+"/grants", "owner", nil), &grants)
+	if len(grants.Grants) != 1 || grants.Grants[0].UserID != "alice" || grants.Grants[0].Role != "editor" {
+		t.Fatalf("grants=%+v", grants.Grants)
+	}
+
+	expectStatus(t, wsRequest(t, srv, http.MethodDelete, base+"/grants/alice", "owner", nil), http.StatusOK, "revoke")
+	expectStatus(t, wsRequest(t, srv, http.MethodGet, base+"/state", "alice", nil), http.StatusNotFound, "state after revoke")
+	expectStatus(t, wsRequest(t, srv, http.MethodDelete, base+"/grants/alice", "owner", nil), http.StatusNotFound, "second revoke")
+}
+
+func TestHTTP_GranteeCanLeave(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), trustHeader))
+	defer srv.Close()
+
+	var ws repository.Workspace
+	decode(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces", "owner", models.WorkspaceRequest{Name: "w"}), &ws)
+	base := "/api/v1/workspaces/" + ws.ID
+	for _, u := range []string{"team/alice", "bob"} {
+		expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/grants", "owner", models.GrantRequest{UserID: u, Role: "viewer"}), http.StatusOK, "share "+u)
+	}
+
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/grants", "owner", models.GrantRequest{UserID: "carol", Role: "admin"}), http.StatusBadRequest, "bad role")
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, base+"/grants", "owner", models.GrantRequest{UserID: "owner", Role: "viewer"}), http.StatusBadRequest, "share with self")
+
+	expectStatus(t, wsRequest(t, srv, http.MethodDelete, base+"/grants/bob", "team/alice", nil), http.StatusForbidden, "revoke someone else")
+	expectStatus(t, wsRequest(t, srv, http.MethodDelete, base+"/grants/"+url.PathEscape("team/alice"), "team/alice", nil), http.StatusOK, "leave")
+	expectStatus(t, wsRequest(t, srv, http.MethodGet, base, "team/alice", nil), http.StatusNotFound, "get after leaving")
+	expectStatus(t, wsRequest(t, srv, http.MethodGet, base, "bob", nil), http.StatusOK, "bob still has access")
+}
@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
+)
+
+const workspaceHeader = "X-Workspace-ID"
+
+var errReadOnlyLink = fmt.Errorf("%w: share links are read-only", service.ErrForbidden)
+
+func workspaceID(r *http.Request) string {
+	if id := r.PathValue("ws"); id != "" {
+		return id
+	}
+	return r.Header.Get(workspaceHeader)
+}
+
+// stateService binds the classifier service to the workspace the request
+// addresses, provided the caller's role there is at least need. Requests
+// under a share token only ever get viewer access.
+func (h *httpHandler) stateService(w http.ResponseWriter, r *http.Request, need service.Role) (service.Service, error) {
+	if token := r.PathValue("token"); token != "" {
+		if !service.RoleViewer.Allows(need) {
+			return nil, errReadOnlyLink
+		}
+		ws, err := h.workspaces.ResolveLink(r.Context(), token)
+		if err != nil {
+			return nil, err
+		}
+		return h.workspaces.Classifier(ws.ID), nil
+	}
+	var (
+		a     service.Access
+		actor service.Actor
+		err   error
+	)
+	if id, ok := identityFrom(r); ok && id.key != nil {
+		a, err = h.workspaces.KeyAccess(r.Context(), *id.key, workspaceID(r), need)
+		actor = service.Actor{UserID: id.userID, Via: "apikey:" + id.key.ID}
+	} else {
+		actor = service.Actor{UserID: h.userID(w, r), Via: "cookie"}
+		if id, ok := identityFrom(r); ok {
+			actor.Via = id.via
+		}
+		a, err = h.workspaces.Resolve(r.Context(), actor.UserID, workspaceID(r), need)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.workspaces.ClassifierAs(a.ID, actor), nil
+}
+
+// usage reports the caller's standing against their quotas in the workspace
+// the request addresses.
+func (h *httpHandler) usage(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return h.methodNotAllowed(w, r, http.MethodGet)
+	}
+
+	var (
+		u   service.UsageReport
+		err error
+	)
+	if id, ok := identityFrom(r); ok && id.key != nil {
+		u, err = h.workspaces.KeyUsage(r.Context(), *id.key, workspaceID(r))
+	} else {
+		u, err = h.workspaces.Usage(r.Context(), h.userID(w, r), workspaceID(r))
+	}
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	return h.writeJSON(w, http.StatusOK, u)
+}
+
+func (h *httpHandler) workspaceCollection(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := h.workspaces.List(r.Context(), h.userID(w, r))
+		if err != nil {
+			return h.serviceError(w, err, http.StatusInternalServerError)
+		}
+		return h.writeJSON(w, http.StatusOK, map[string]any{"workspaces": list})
+	case http.MethodPost:
+		var req models.WorkspaceRequest
+		if err := h.readJSON(w, r, &req); err != nil {
+			return err
+		}
+		ws, err := h.workspaces.Create(r.Context(), h.userID(w, r), req.Name)
+		if err != nil {
+			return h.serviceError(w, err, http.StatusInternalServerError)
+		}
+		return h.writeJSON(w, http.StatusCreated, ws)
+	default:
+		return h.methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (h *httpHandler) workspaceItem(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		ws, err := h.workspaces.Get(r.Context(), h.userID(w, r), r.PathValue("ws"))
+		if err != nil {
+			return h.serviceError(w, err, http.StatusInternalServerError)
+		}
+		return h.writeJSON(w, http.StatusOK, ws)
+	case http.MethodDelete:
+		if err := h.workspaces.Delete(r.Context(), h.userID(w, r), r.PathValue("ws")); err != nil {
+			return h.serviceError(w, err, http.StatusInternalServerError)
+		}
+		return h.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	default:
+		return h.methodNotAllowed(w, r, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func (h *httpHandler) workspaceRename(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return h.methodNotAllowed(w, r, http.MethodPost)
+	}
+
+	var req models.WorkspaceRequest
+	if err := h.readJSON(w, r, &req); err != nil {
+		return err
+	}
+	ws, err := h.workspaces.Rename(r.Context(), h.userID(w, r), r.PathValue("ws"), req.Name)
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	return h.writeJSON(w, http.StatusOK, ws)
+}
+
+// workspaceClone copies another workspace, or an uploaded export, into a new
+// workspace owned by the caller.
+func (h *httpHandler) workspaceClone(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return h.methodNotAllowed(w, r, http.MethodPost)
+	}
+
+	var req models.CloneRequest
+	if err := h.readJSON(w, r, &req); err != nil {
+		return err
+	}
+	var (
+		ws  repository.Workspace
+		err error
+	)
+	switch {
+	case (req.From == "") == (req.Export == nil):
+		return h.badRequest(w, "give exactly one of from or export")
+	case req.From != "":
+		ws, err = h.workspaces.Clone(r.Context(), h.userID(w, r), req.From, req.Name)
+	default:
+		ws, err = h.workspaces.Import(r.Context(), h.userID(w, r), req.Name, *req.Export)
+	}
+	if err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	return h.writeJSON(w, http.StatusCreated, ws)
+}
+
+func (h *httpHandler) grantCollection(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		grants, err := h.workspaces.Grants(r.Context(), h.userID(w, r), r.PathValue("ws"))
+		if err != nil {
+			return h.serviceError(w, err, http.StatusInternalServerError)
+		}
+		if grants == nil {
+			grants = []repository.Grant{}
+		}
+		return h.writeJSON(w, http.StatusOK, map[string]any{"grants": grants})
+	case http.MethodPost:
+		var req models.GrantRequest
+		if err := h.readJSON(w, r, &req); err != nil {
+			return err
+		}
+		g, err := h.workspaces.Share(r.Context(), h.userID(w, r), r.PathValue("ws"), req.UserID, service.Role(req.Role))
+		if err != nil {
+			return h.serviceError(w, err, http.StatusInternalServerError)
+		}
+		return h.writeJSON(w, http.StatusOK, g)
+	default:
+		return h.methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (h *httpHandler) grantItem(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodDelete {
+		return h.methodNotAllowed(w, r, http.MethodDelete)
+	}
+
+	if err := h.workspaces.Revoke(r.Context(), h.userID(w, r), r.PathValue("ws"), r.PathValue("user")); err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	return h.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *httpHandler) linkCollection(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		links, err := h.workspaces.Links(r.Context(), h.userID(w, r), r.PathValue("ws"))
+		if err != nil {
+			return h.serviceError(w, err, http.StatusInternalServerError)
+		}
+		if links == nil {
+			links = []repository.ShareLink{}
+		}
+		return h.writeJSON(w, http.StatusOK, map[string]any{"links": links})
+	case http.MethodPost:
+		var req models.ShareLinkRequest
+		if err := h.readJSON(w, r, &req); err != nil {
+			return err
+		}
+		link, token, err := h.workspaces.CreateLink(r.Context(), h.userID(w, r), r.PathValue("ws"), req.ExpiresAt)
+		if err != nil {
+			return h.serviceError(w, err, http.StatusInternalServerError)
+		}
+		return h.writeJSON(w, http.StatusCreated, map[string]any{
+			"link":  link,
+			"token": token,
+			"path":  "/api/v1/shared/" + token,
+		})
+	default:
+		return h.methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (h *httpHandler) linkItem(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodDelete {
+		return h.methodNotAllowed(w, r, http.MethodDelete)
+	}
+
+	if err := h.workspaces.RevokeLink(r.Context(), h.userID(w, r), r.PathValue("ws"), r.PathValue("link")); err != nil {
+		return h.serviceError(w, err, http.StatusInternalServerError)
+	}
+	return h.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// sharedReadOnly answers every token-scoped route other than classify and
+// state.
+func (h *httpHandler) sharedReadOnly(w http.ResponseWriter, r *http.Request) error {
+	return h.serviceError(w, errReadOnlyLink, http.StatusForbidden)
+}
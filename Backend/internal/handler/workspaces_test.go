@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/service"
+)
+
+func wsRequest(t *testing.T, srv *httptest.Server, method, path, user string, body any) *http.Response {
+	t.Helper()
+	var rd *bytes.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		rd = bytes.NewReader(b)
+	} else {
+		rd = bytes.NewReader(nil)
+	}
+	req, _ := http.NewRequest(method, srv.URL+path, rd)
+	req.Header.Set("X-User-ID", user)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestHTTP_WorkspacesAreIndependent(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), trustHeader))
+	defer srv.Close()
+
+	resp := wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces", "analyst", models.WorkspaceRequest{Name: "urgent vs routine"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create status=%d", resp.StatusCode)
+	}
+	var urgent repository.Workspace
+	decode(t, resp, &urgent)
+
+	resp = wsRequest(t, srv, http.MethodPost, "/api/v1/init", "analyst", models.InitRequest{
+		Class1: models.Class{Name: "Spam"}, Class2: models.Class{Name: "Ham"},
+	})
+	resp.Body.Close()
+	resp = wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces/"+urgent.ID+"/init", "analyst", models.InitRequest{
+		Class1: models.Class{Name: "Urgent"}, Class2: models.Class{Name: "Routine"},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("scoped init status=%d", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	var snap models.Snapshot
+	decode(t, wsRequest(t, srv, http.MethodGet, "/api/v1/state", "analyst", nil), &snap)
+	if snap.Class1.Name != "Spam" {
+		t.Fatalf("default workspace=%+v; want Spam", snap)
+	}
+	decode(t, wsRequest(t, srv, http.MethodGet, "/api/v1/workspaces/"+urgent.ID+"/state", "analyst", nil), &snap)
+	if snap.Class1.Name != "Urgent" {
+		t.Fatalf("urgent workspace=%+v; want Urgent", snap)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/state", nil)
+	req.Header.Set("X-User-ID", "analyst")
+	req.Header.Set("X-Workspace-ID", urgent.ID)
+	hresp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decode(t, hresp, &snap)
+	if snap.Class1.Name != "Urgent" {
+		t.Fatalf("header-selected workspace=%+v; want Urgent", snap)
+	}
+
+	var list struct {
+		Workspaces []repository.Workspace `json:"workspaces"`
+	}
+	decode(t, wsRequest(t, srv, http.MethodGet, "/api/v1/workspaces", "analyst", nil), &list)
+	if len(list.Workspaces) != 2 || list.Workspaces[1].ID != urgent.ID {
+		t.Fatalf("list=%+v", list.Workspaces)
+	}
+}
+
+func TestHTTP_WorkspaceLifecycle(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), trustHeader))
+	defer srv.Close()
+
+	resp := wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces", "owner", models.WorkspaceRequest{Name: "  "})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("blank name status=%d; want 400", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	var ws repository.Workspace
+	decode(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces", "owner", models.WorkspaceRequest{Name: "draft"}), &ws)
+
+	for _, tc := range []struct {
+		method, path string
+	}{
+		{http.MethodGet, "/api/v1/workspaces/" + ws.ID},
+		{http.MethodGet, "/api/v1/workspaces/" + ws.ID + "/state"},
+		{http.MethodPost, "/api/v1/workspaces/" + ws.ID + "/rename"},
+		{http.MethodDelete, "/api/v1/workspaces/" + ws.ID},
+	} {
+		resp := wsRequest(t, srv, tc.method, tc.path, "intruder", models.WorkspaceRequest{Name: "mine"})
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s %s by another user: status=%d; want 404", tc.method, tc.path, resp.StatusCode)
+		}
+		resp.Body.Close()
+	}
+
+	var renamed repository.Workspace
+	decode(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces/"+ws.ID+"/rename", "owner", models.WorkspaceRequest{Name: "final"}), &renamed)
+	if renamed.Name != "final" || renamed.ID != ws.ID {
+		t.Fatalf("renamed=%+v", renamed)
+	}
+
+	resp = wsRequest(t, srv, http.MethodDelete, "/api/v1/workspaces/"+ws.ID, "owner", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete status=%d", resp.StatusCode)
+	}
+	resp.Body.Close()
+	resp = wsRequest(t, srv, http.MethodGet, "/api/v1/workspaces/"+ws.ID, "owner", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("get after delete status=%d; want 404", resp.StatusCode)
+	}
+	resp.Body.Close()
+}
+
+func TestHTTP_LegacyStateMovesToDefaultWorkspace(t *testing.T) {
+	repo := repository.NewMemory()
+	legacy := repository.State{Class1: models.Class{Name: "Cat"}, Class2: models.Class{Name: "Dog"}}
+	if err := repo.UpsertState(context.Background(), "old-user", legacy); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewHTTPMux(repo, trustHeader))
+	defer srv.Close()
+
+	var snap models.Snapshot
+	decode(t, wsRequest(t, srv, http.MethodGet, "/api/v1/state", "old-user", nil), &snap)
+	if snap.Class1.Name != "Cat" || snap.Class2.Name != "Dog" {
+		t.Fatalf("legacy state not adopted: %+v", snap)
+	}
+	left, _ := repo.GetState(context.Background(), "old-user")
+	if left.Class1.Name != "" {
+		t.Fatalf("legacy row not cleared: %+v", left)
+	}
+}
+
+func TestHTTP_CloneWorkspace(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), trustHeader))
+	defer srv.Close()
+
+	var src repository.Workspace
+	decode(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces", "alice", models.WorkspaceRequest{Name: "spam"}), &src)
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces/"+src.ID+"/init", "alice", models.InitRequest{
+		Class1: models.Class{Name: "Spam", Properties: []string{"offer"}}, Class2: models.Class{Name: "Ham"},
+	}), http.StatusOK, "init")
+
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces/clone", "bob", models.CloneRequest{From: src.ID}), http.StatusNotFound, "clone without access")
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces/clone", "bob", models.CloneRequest{}), http.StatusBadRequest, "clone nothing")
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces/"+src.ID+"/grants", "alice", models.GrantRequest{UserID: "bob", Role: "viewer"}), http.StatusOK, "share")
+
+	resp := wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces/clone", "bob", models.CloneRequest{From: src.ID, Name: "my spam"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("clone status=%d", resp.StatusCode)
+	}
+	var clone repository.Workspace
+	decode(t, resp, &clone)
+	if clone.OwnerID != "bob" || clone.Name != "my spam" || len(clone.Lineage) != 1 || clone.Lineage[0].WorkspaceID != src.ID {
+		t.Fatalf("clone=%+v", clone)
+	}
+
+	var snap models.Snapshot
+	decode(t, wsRequest(t, srv, http.MethodGet, "/api/v1/workspaces/"+clone.ID+"/state", "bob", nil), &snap)
+	if snap.Class1.Name != "Spam" {
+		t.Fatalf("cloned state=%+v", snap)
+	}
+
+	resp = wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces/clone", "carol", models.CloneRequest{Export: &snap})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("import status=%d", resp.StatusCode)
+	}
+	var imported repository.Workspace
+	decode(t, resp, &imported)
+	if imported.OwnerID != "carol" || len(imported.Lineage) != 1 || imported.Lineage[0].Source != repository.LineageUpload {
+		t.Fatalf("imported=%+v", imported)
+	}
+}
+
+func TestHTTP_QuotasAndUsage(t *testing.T) {
+	opts := trustHeader
+	opts.Quotas = service.Quotas{Vocabulary: 3, WorkspacesPerUser: 1}
+	srv := httptest.NewServer(NewHTTPMux(repository.NewMemory(), opts))
+	defer srv.Close()
+
+	resp := wsRequest(t, srv, http.MethodPost, "/api/v1/init", "alice", models.InitRequest{
+		Class1: models.Class{Name: "Cat", Properties: []string{"purr"}}, Class2: models.Class{Name: "Dog"},
+	})
+	expectStatus(t, resp, http.StatusOK, "init")
+
+	var refused struct {
+		Error string `json:"error"`
+		Quota string `json:"quota"`
+		Limit int    `json:"limit"`
+	}
+	resp = wsRequest(t, srv, http.MethodPost, "/api/v1/feedback", "alice", models.FeedbackRequest{Variant: "class2", Properties: []string{"bark", "fetch", "wag"}})
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("feedback over quota status=%d", resp.StatusCode)
+	}
+	decode(t, resp, &refused)
+	if refused.Quota != "vocabulary" || refused.Limit != 3 || refused.Error == "" {
+		t.Fatalf("quota error=%+v", refused)
+	}
+	expectStatus(t, wsRequest(t, srv, http.MethodPost, "/api/v1/workspaces", "alice", models.WorkspaceRequest{Name: "more"}), http.StatusUnprocessableEntity, "workspace over quota")
+
+	var u service.UsageReport
+	decode(t, wsRequest(t, srv, http.MethodGet, "/api/v1/usage", "alice", nil), &u)
+	if u.Classifier.Vocabulary != 1 || u.Classifier.Class1 != 1 || u.Workspaces != 1 || u.Limits.Vocabulary != 3 {
+		t.Fatalf("usage=%+v", u)
+	}
+	expectStatus(t, wsRequest(t, srv, http.MethodGet, "/api/v1/workspaces/"+u.WorkspaceID+"/usage", "bob", nil), http.StatusNotFound, "usage of another user's workspace")
+}
@@ -0,0 +1,378 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+//go:embed migrations
+var embedded embed.FS
+
+var (
+	ErrSchemaBehind = errors.New("migrate: database schema is behind")
+	ErrLocked       = errors.New("migrate: another migrator holds the lock")
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	SQL     string
+}
+
+type Status struct {
+	Version   int64     `json:"version"`
+	Name      string    `json:"name"`
+	Applied   bool      `json:"applied"`
+	AppliedAt time.Time `json:"appliedAt,omitempty"`
+}
+
+type dialect struct {
+	createTable string
+	insert      string
+	// createSteps, insertStep and deleteSteps keep the statements done so
+	// far of a migration that is not transactional.
+	createSteps string
+	insertStep  string
+	deleteSteps string
+	lock        func(ctx context.Context, conn *sql.Conn) error
+	unlock      func(ctx context.Context, conn *sql.Conn) error
+	// transactional dialects run a migration and its version insert in one
+	// transaction, so a failure leaves nothing behind.
+	transactional bool
+	// applied reports whether a statement failed only because its change is
+	// already in place, which happens when a run stopped between a statement
+	// and recording it.
+	applied func(err error) bool
+}
+
+const lockName = "slc_schema_migrations"
+
+// mysqlApplied matches the errors a rerun DDL statement gets: the table,
+// column or index it creates exists, or the one it drops does not.
+func mysqlApplied(err error) bool {
+	var me *mysql.MySQLError
+	if !errors.As(err, &me) {
+		return false
+	}
+	switch me.Number {
+	case 1050, 1060, 1061, 1091: // table exists, duplicate column, duplicate key, can't drop
+		return true
+	}
+	return false
+}
+
+var dialects = map[string]dialect{
+	"mysql": {
+		createTable: `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version     BIGINT        NOT NULL PRIMARY KEY,
+  name        VARCHAR(255)  NOT NULL,
+  applied_at  TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		insert: `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`,
+		createSteps: `
+CREATE TABLE IF NOT EXISTS schema_migration_steps (
+  version  BIGINT  NOT NULL,
+  step     INT     NOT NULL,
+  PRIMARY KEY (version, step)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		insertStep:  `INSERT INTO schema_migration_steps (version, step) VALUES (?, ?)`,
+		deleteSteps: `DELETE FROM schema_migration_steps WHERE version = ?`,
+		lock: func(ctx context.Context, conn *sql.Conn) error {
+			var got sql.NullInt64
+			if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 30)`, lockName).Scan(&got); err != nil {
+				return err
+			}
+			if !got.Valid || got.Int64 != 1 {
+				return ErrLocked
+			}
+			return nil
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, `SELECT RELEASE_LOCK(?)`, lockName)
+			return err
+		},
+		applied: mysqlApplied,
+	},
+	"postgres": {
+		createTable: `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version     BIGINT        NOT NULL PRIMARY KEY,
+  name        VARCHAR(255)  NOT NULL,
+  applied_at  TIMESTAMPTZ   NOT NULL DEFAULT now()
+)`,
+		insert: `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`,
+		lock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1))`, lockName)
+			return err
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext($1))`, lockName)
+			return err
+		},
+		transactional: true,
+	},
+}
+
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+}
+
+func New(db *sql.DB, dialectName string) (*Migrator, error) {
+	d, ok := dialects[dialectName]
+	if !ok {
+		return nil, fmt.Errorf("migrate: unsupported dialect %q", dialectName)
+	}
+	ms, err := load(embedded, path.Join("migrations", dialectName))
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: d, migrations: ms}, nil
+}
+
+func (m *Migrator) Migrations() []Migration {
+	return append([]Migration(nil), m.migrations...)
+}
+
+// Up applies every pending migration in version order while holding a
+// database-wide lock, so concurrently starting migrators run them only once.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := m.dialect.lock(ctx, conn); err != nil {
+		return nil, err
+	}
+	defer func() { _ = m.dialect.unlock(context.Background(), conn) }()
+
+	if _, err := conn.ExecContext(ctx, m.dialect.createTable); err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, mg := range m.migrations {
+		if _, ok := applied[mg.Version]; ok {
+			continue
+		}
+		if err := m.apply(ctx, conn, mg); err != nil {
+			return done, err
+		}
+		done = append(done, mg)
+	}
+	return done, nil
+}
+
+// apply runs a migration's statements and records its version. Where DDL is
+// transactional, both happen in one transaction. Elsewhere, as in MySQL, every
+// DDL statement commits on its own, so each one done is recorded and a rerun
+// after a failure continues with the statement that failed.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mg Migration) error {
+	fail := func(err error) error { return fmt.Errorf("migration %04d_%s: %w", mg.Version, mg.Name, err) }
+	if m.dialect.transactional {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fail(err)
+		}
+		defer tx.Rollback()
+		for _, stmt := range splitStatements(mg.SQL) {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fail(err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, m.dialect.insert, mg.Version, mg.Name); err != nil {
+			return fmt.Errorf("record migration %04d_%s: %w", mg.Version, mg.Name, err)
+		}
+		return tx.Commit()
+	}
+
+	if _, err := conn.ExecContext(ctx, m.dialect.createSteps); err != nil {
+		return err
+	}
+	done, err := doneSteps(ctx, conn, mg.Version)
+	if err != nil {
+		return fail(err)
+	}
+	for i, stmt := range splitStatements(mg.SQL) {
+		if done[i] {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, stmt); err != nil && (m.dialect.applied == nil || !m.dialect.applied(err)) {
+			return fail(err)
+		}
+		if _, err := conn.ExecContext(ctx, m.dialect.insertStep, mg.Version, i); err != nil {
+			return fail(err)
+		}
+	}
+	if _, err := conn.ExecContext(ctx, m.dialect.insert, mg.Version, mg.Name); err != nil {
+		return fmt.Errorf("record migration %04d_%s: %w", mg.Version, mg.Name, err)
+	}
+	_, err = conn.ExecContext(ctx, m.dialect.deleteSteps, mg.Version)
+	return err
+}
+
+func doneSteps(ctx context.Context, conn *sql.Conn, version int64) (map[int]bool, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT step FROM schema_migration_steps WHERE version = ?`, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int]bool)
+	for rows.Next() {
+		var step int
+		if err := rows.Scan(&step); err != nil {
+			return nil, err
+		}
+		out[step] = true
+	}
+	return out, rows.Err()
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, m.dialect.createTable); err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Status, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		st := Status{Version: mg.Version, Name: mg.Name}
+		if at, ok := applied[mg.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = at
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// Check returns ErrSchemaBehind if any known migration has not been applied.
+func (m *Migrator) Check(ctx context.Context) error {
+	sts, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, st := range sts {
+		if !st.Applied {
+			pending = append(pending, fmt.Sprintf("%04d_%s", st.Version, st.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: pending %s", ErrSchemaBehind, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			v  int64
+			at time.Time
+		)
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		out[v] = at
+	}
+	return out, rows.Err()
+}
+
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int64]string)
+	var out []Migration
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		base := strings.TrimSuffix(e.Name(), ".sql")
+		num, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migrate: bad file name %q (want NNNN_name.sql)", e.Name())
+		}
+		v, err := strconv.ParseInt(num, 10, 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("migrate: bad version in %q", e.Name())
+		}
+		if prev, dup := seen[v]; dup {
+			return nil, fmt.Errorf("migrate: version %d used by %q and %q", v, prev, e.Name())
+		}
+		seen[v] = e.Name()
+
+		body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Migration{Version: v, Name: name, SQL: string(body)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// splitStatements splits a migration file on semicolons that end a line, so a
+// file may hold several statements without enabling multiStatements on the
+// driver. Statements must not contain such semicolons inside string literals.
+func splitStatements(src string) []string {
+	var (
+		out []string
+		cur strings.Builder
+	)
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		cur.WriteString(line)
+		cur.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			if stmt := strings.TrimSuffix(strings.TrimSpace(cur.String()), ";"); stmt != "" {
+				out = append(out, stmt)
+			}
+			cur.Reset()
+		}
+	}
+	if stmt := strings.TrimSpace(cur.String()); stmt != "" {
+		out = append(out, stmt)
+	}
+	return out
+}
@@ -0,0 +1,157 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+func TestLoad_OrdersAndValidates(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0002_second.sql": {Data: []byte("SELECT 2;")},
+		"m/0001_first.sql":  {Data: []byte("SELECT 1;")},
+		"m/README.md":       {Data: []byte("ignored")},
+	}
+	ms, err := load(fsys, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 || ms[0].Version != 1 || ms[1].Version != 2 || ms[0].Name != "first" {
+		t.Fatalf("migrations=%+v", ms)
+	}
+
+	fsys["m/0002_dup.sql"] = &fstest.MapFile{Data: []byte("SELECT 3;")}
+	if _, err := load(fsys, "m"); err == nil {
+		t.Fatalf("duplicate version must fail")
+	}
+
+	bad := fstest.MapFS{"m/first.sql": {Data: []byte("SELECT 1;")}}
+	if _, err := load(bad, "m"); err == nil {
+		t.Fatalf("file without version must fail")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	src := `-- comment
+CREATE TABLE a (
+  id INT
+);
+
+INSERT INTO a VALUES (1);
+SELECT 'x;y' FROM a`
+	got := splitStatements(src)
+	want := []string{
+		"CREATE TABLE a (\n  id INT\n)",
+		"INSERT INTO a VALUES (1)",
+		"SELECT 'x;y' FROM a",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("split=%q; want %q", got, want)
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	for name := range dialects {
+		m, err := New(nil, name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		ms := m.Migrations()
+		if len(ms) == 0 {
+			t.Fatalf("%s: no migrations embedded", name)
+		}
+		for i, mg := range ms {
+			if mg.Version != int64(i+1) {
+				t.Fatalf("%s: migration %q has version %d; want %d (no gaps)", name, mg.Name, mg.Version, i+1)
+			}
+			if len(splitStatements(mg.SQL)) == 0 {
+				t.Fatalf("%s: migration %q is empty", name, mg.Name)
+			}
+		}
+	}
+}
+
+func TestMySQLApplied(t *testing.T) {
+	if !mysqlApplied(fmt.Errorf("wrapped: %w", &mysql.MySQLError{Number: 1060, Message: "Duplicate column name 'lineage'"})) {
+		t.Fatalf("duplicate column must count as applied")
+	}
+	if mysqlApplied(&mysql.MySQLError{Number: 1146, Message: "Table 'workspaces' doesn't exist"}) {
+		t.Fatalf("missing table must not count as applied")
+	}
+	if mysqlApplied(errors.New("connection refused")) {
+		t.Fatalf("non-MySQL error must not count as applied")
+	}
+}
+
+// A migration that stopped partway is finished by the next run. For MySQL
+// the test fakes a failure after the first statement of a migration; for
+// PostgreSQL nothing of a failed migration survives, so forgetting its
+// version is enough. The test runs against the disposable databases the
+// repository contract tests use.
+func TestUp_FinishesInterruptedMigration(t *testing.T) {
+	for _, c := range []struct{ dialect, driver, env string }{
+		{"mysql", "mysql", "SLC_TEST_MYSQL_DSN"},
+		{"postgres", "postgres", "SLC_TEST_POSTGRES_DSN"},
+	} {
+		t.Run(c.dialect, func(t *testing.T) {
+			dsn := os.Getenv(c.env)
+			if dsn == "" {
+				t.Skip(c.env + " not set")
+			}
+			db, err := sql.Open(c.driver, dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			ctx := context.Background()
+			m, err := New(db, c.dialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := m.Up(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			// The first migration that alters an existing table.
+			var mg Migration
+			for _, x := range m.Migrations() {
+				if strings.HasPrefix(x.Name, "add_") {
+					mg = x
+					break
+				}
+			}
+			v := strconv.FormatInt(mg.Version, 10)
+			if _, err := db.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = `+v); err != nil {
+				t.Fatal(err)
+			}
+			if c.dialect == "mysql" {
+				if _, err := db.ExecContext(ctx, `INSERT INTO schema_migration_steps (version, step) VALUES (`+v+`, 0)`); err != nil {
+					t.Fatal(err)
+				}
+			}
+			done, err := m.Up(ctx)
+			if err != nil {
+				t.Fatalf("rerun: %v", err)
+			}
+			if len(done) != 1 || done[0].Version != mg.Version {
+				t.Fatalf("reapplied %+v; want %04d_%s", done, mg.Version, mg.Name)
+			}
+			if c.dialect == "mysql" {
+				var n int
+				if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migration_steps`).Scan(&n); err != nil || n != 0 {
+					t.Fatalf("steps left=%d err=%v", n, err)
+				}
+			}
+		})
+	}
+}
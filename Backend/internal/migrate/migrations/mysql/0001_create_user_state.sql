@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_state (
+  user_id       VARCHAR(128)  NOT NULL PRIMARY KEY,
+  class1_name   VARCHAR(255)  NOT NULL DEFAULT '',
+  class2_name   VARCHAR(255)  NOT NULL DEFAULT '',
+  class1_props  JSON          NOT NULL,
+  class2_props  JSON          NOT NULL,
+  general_props JSON          NOT NULL,
+  none_props    JSON          NOT NULL,
+  updated_at    TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS user_state_changes (
+  user_id     VARCHAR(128)  NOT NULL PRIMARY KEY,
+  version     BIGINT        NOT NULL DEFAULT 1,
+  changed_at  TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  KEY idx_user_state_changes_changed_at (changed_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS classifiers (
+  id          BIGINT        NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id     VARCHAR(128)  NOT NULL,
+  created_at  TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at  TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE KEY uq_classifiers_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS classes (
+  classifier_id  BIGINT        NOT NULL,
+  slot           TINYINT       NOT NULL,
+  name           VARCHAR(255)  NOT NULL DEFAULT '',
+  PRIMARY KEY (classifier_id, slot),
+  CONSTRAINT fk_classes_classifier FOREIGN KEY (classifier_id)
+    REFERENCES classifiers (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS properties (
+  classifier_id  BIGINT                                   NOT NULL,
+  area           ENUM('class1','class2','general','none') NOT NULL,
+  name           VARCHAR(255) COLLATE utf8mb4_bin         NOT NULL,
+  position       INT                                      NOT NULL,
+  PRIMARY KEY (classifier_id, area, name),
+  KEY idx_properties_area_name (area, name),
+  CONSTRAINT fk_properties_classifier FOREIGN KEY (classifier_id)
+    REFERENCES classifiers (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS workspaces (
+  id          VARCHAR(128)  NOT NULL PRIMARY KEY,
+  owner_id    VARCHAR(128)  NOT NULL,
+  name        VARCHAR(255)  NOT NULL,
+  created_at  TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  updated_at  TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  KEY idx_workspaces_owner (owner_id, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
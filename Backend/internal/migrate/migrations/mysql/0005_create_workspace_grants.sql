@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS workspace_grants (
+  workspace_id  VARCHAR(128)  NOT NULL,
+  user_id       VARCHAR(128)  NOT NULL,
+  role          VARCHAR(16)   NOT NULL,
+  created_at    TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  PRIMARY KEY (workspace_id, user_id),
+  KEY idx_workspace_grants_user (user_id),
+  CONSTRAINT fk_workspace_grants_workspace FOREIGN KEY (workspace_id)
+    REFERENCES workspaces (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS share_links (
+  id            VARCHAR(64)   NOT NULL PRIMARY KEY,
+  workspace_id  VARCHAR(128)  NOT NULL,
+  token_hash    CHAR(64)      NOT NULL,
+  created_by    VARCHAR(128)  NOT NULL,
+  created_at    TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  expires_at    TIMESTAMP(6)  NULL DEFAULT NULL,
+  revoked_at    TIMESTAMP(6)  NULL DEFAULT NULL,
+  UNIQUE KEY uq_share_links_token (token_hash),
+  KEY idx_share_links_workspace (workspace_id, created_at),
+  CONSTRAINT fk_share_links_workspace FOREIGN KEY (workspace_id)
+    REFERENCES workspaces (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1 @@
+ALTER TABLE workspaces ADD COLUMN lineage JSON NULL;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+  id            VARCHAR(64)   NOT NULL PRIMARY KEY,
+  user_id       VARCHAR(128)  NOT NULL,
+  workspace_id  VARCHAR(128)  NOT NULL,
+  name          VARCHAR(100)  NOT NULL DEFAULT '',
+  scope         VARCHAR(16)   NOT NULL,
+  secret_hash   CHAR(64)      NOT NULL,
+  created_at    TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  last_used_at  TIMESTAMP(6)  NULL DEFAULT NULL,
+  revoked_at    TIMESTAMP(6)  NULL DEFAULT NULL,
+  KEY idx_api_keys_user (user_id, created_at),
+  CONSTRAINT fk_api_keys_workspace FOREIGN KEY (workspace_id)
+    REFERENCES workspaces (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
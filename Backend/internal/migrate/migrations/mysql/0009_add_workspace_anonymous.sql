@@ -0,0 +1 @@
+ALTER TABLE workspaces ADD COLUMN anonymous BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+  id            BIGINT        NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  workspace_id  VARCHAR(128)  NOT NULL,
+  actor         VARCHAR(128)  NOT NULL DEFAULT '',
+  via           VARCHAR(80)   NOT NULL DEFAULT '',
+  request_id    VARCHAR(128)  NOT NULL DEFAULT '',
+  operation     VARCHAR(32)   NOT NULL,
+  payload       JSON          NULL,
+  diff          JSON          NOT NULL,
+  created_at    TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  KEY idx_audit_log_workspace (workspace_id, id),
+  KEY idx_audit_log_actor (actor, id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS audit_log_properties (
+  entry_id  BIGINT                           NOT NULL,
+  property  VARCHAR(255) COLLATE utf8mb4_bin NOT NULL,
+  PRIMARY KEY (entry_id, property),
+  KEY idx_audit_log_properties_property (property, entry_id),
+  CONSTRAINT fk_audit_log_properties_entry FOREIGN KEY (entry_id)
+    REFERENCES audit_log (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS legacy_sessions (
+  id_hash      CHAR(64)      NOT NULL PRIMARY KEY,
+  resigned_at  TIMESTAMP(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1 @@
+ALTER TABLE workspaces ADD COLUMN accessed_at TIMESTAMP(6) NULL DEFAULT NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_state (
+  user_id       VARCHAR(128)  NOT NULL PRIMARY KEY,
+  class1_name   VARCHAR(255)  NOT NULL DEFAULT '',
+  class2_name   VARCHAR(255)  NOT NULL DEFAULT '',
+  class1_props  JSONB         NOT NULL DEFAULT '[]'::jsonb,
+  class2_props  JSONB         NOT NULL DEFAULT '[]'::jsonb,
+  general_props JSONB         NOT NULL DEFAULT '[]'::jsonb,
+  none_props    JSONB         NOT NULL DEFAULT '[]'::jsonb,
+  updated_at    TIMESTAMPTZ   NOT NULL DEFAULT now()
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_state_changes (
+  user_id     VARCHAR(128)  NOT NULL PRIMARY KEY,
+  version     BIGINT        NOT NULL DEFAULT 1,
+  changed_at  TIMESTAMPTZ   NOT NULL DEFAULT clock_timestamp()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_state_changes_changed_at ON user_state_changes (changed_at);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS workspaces (
+  id          VARCHAR(128)  NOT NULL PRIMARY KEY,
+  owner_id    VARCHAR(128)  NOT NULL,
+  name        VARCHAR(255)  NOT NULL,
+  created_at  TIMESTAMPTZ   NOT NULL DEFAULT now(),
+  updated_at  TIMESTAMPTZ   NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_workspaces_owner ON workspaces (owner_id, created_at);
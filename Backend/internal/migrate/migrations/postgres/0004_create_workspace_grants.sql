@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS workspace_grants (
+  workspace_id  VARCHAR(128)  NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+  user_id       VARCHAR(128)  NOT NULL,
+  role          VARCHAR(16)   NOT NULL,
+  created_at    TIMESTAMPTZ   NOT NULL DEFAULT now(),
+  PRIMARY KEY (workspace_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_workspace_grants_user ON workspace_grants (user_id);
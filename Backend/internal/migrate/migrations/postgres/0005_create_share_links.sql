@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS share_links (
+  id            VARCHAR(64)   NOT NULL PRIMARY KEY,
+  workspace_id  VARCHAR(128)  NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+  token_hash    CHAR(64)      NOT NULL UNIQUE,
+  created_by    VARCHAR(128)  NOT NULL,
+  created_at    TIMESTAMPTZ   NOT NULL DEFAULT now(),
+  expires_at    TIMESTAMPTZ   NULL,
+  revoked_at    TIMESTAMPTZ   NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_workspace ON share_links (workspace_id, created_at);
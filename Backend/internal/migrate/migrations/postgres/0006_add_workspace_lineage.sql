@@ -0,0 +1 @@
+ALTER TABLE workspaces ADD COLUMN IF NOT EXISTS lineage JSONB NULL;
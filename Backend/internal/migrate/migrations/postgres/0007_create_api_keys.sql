@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+  id            VARCHAR(64)   NOT NULL PRIMARY KEY,
+  user_id       VARCHAR(128)  NOT NULL,
+  workspace_id  VARCHAR(128)  NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+  name          VARCHAR(100)  NOT NULL DEFAULT '',
+  scope         VARCHAR(16)   NOT NULL,
+  secret_hash   CHAR(64)      NOT NULL,
+  created_at    TIMESTAMPTZ   NOT NULL DEFAULT now(),
+  last_used_at  TIMESTAMPTZ   NULL,
+  revoked_at    TIMESTAMPTZ   NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys (user_id, created_at);
@@ -0,0 +1 @@
+ALTER TABLE workspaces ADD COLUMN IF NOT EXISTS anonymous BOOLEAN NOT NULL DEFAULT FALSE;
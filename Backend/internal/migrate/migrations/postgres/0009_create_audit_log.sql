@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+  id            BIGSERIAL     PRIMARY KEY,
+  workspace_id  VARCHAR(128)  NOT NULL,
+  actor         VARCHAR(128)  NOT NULL DEFAULT '',
+  via           VARCHAR(80)   NOT NULL DEFAULT '',
+  request_id    VARCHAR(128)  NOT NULL DEFAULT '',
+  operation     VARCHAR(32)   NOT NULL,
+  payload       JSONB         NULL,
+  diff          JSONB         NOT NULL,
+  created_at    TIMESTAMPTZ   NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_workspace ON audit_log (workspace_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor, id);
+
+CREATE TABLE IF NOT EXISTS audit_log_properties (
+  entry_id  BIGINT        NOT NULL REFERENCES audit_log (id) ON DELETE CASCADE,
+  property  VARCHAR(255)  NOT NULL,
+  PRIMARY KEY (entry_id, property)
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_properties_property ON audit_log_properties (property, entry_id);
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS legacy_sessions (
+  id_hash      CHAR(64)     NOT NULL PRIMARY KEY,
+  resigned_at  TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
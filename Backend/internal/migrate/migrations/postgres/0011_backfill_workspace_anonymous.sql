@@ -0,0 +1,5 @@
+-- 0008 marked every existing workspace as an account's. Workspaces owned by an
+-- anonymous session, a bare 32-digit hex ID from unsigned cookies or an
+-- anon_ ID, belong to visitors.
+UPDATE workspaces SET anonymous = TRUE
+WHERE LEFT(owner_id, 5) = 'anon_' OR owner_id ~ '^[0-9a-f]{32}$';
@@ -0,0 +1 @@
+ALTER TABLE workspaces ADD COLUMN IF NOT EXISTS accessed_at TIMESTAMPTZ NULL;
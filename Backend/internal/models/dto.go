@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type InitRequest struct {
 	Class1 Class `json:"class1"`
 	Class2 Class `json:"class2"`
@@ -60,3 +62,36 @@ type AddPropertyRequest struct {
 type OkResponse struct {
 	Ok bool `json:"ok"`
 }
+
+type WorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+type GrantRequest struct {
+	UserID string `json:"userId"`
+	Role   string `json:"role"`
+}
+
+type ShareLinkRequest struct {
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// CloneRequest names either a workspace to copy or a state exported from
+// GET /state.
+type CloneRequest struct {
+	From   string    `json:"from,omitempty"`
+	Export *Snapshot `json:"export,omitempty"`
+	Name   string    `json:"name,omitempty"`
+}
+
+type APIKeyRequest struct {
+	Name        string `json:"name,omitempty"`
+	WorkspaceID string `json:"workspaceId,omitempty"`
+	Scope       string `json:"scope"`
+}
+
+// ClaimRequest says what to do with the state a visitor built before signing
+// in: adopt, merge or discard.
+type ClaimRequest struct {
+	Action string `json:"action"`
+}
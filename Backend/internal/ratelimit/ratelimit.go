@@ -0,0 +1,100 @@
+// Package ratelimit keeps token buckets in memory. Every replica counts on
+// its own, so a client spread over n replicas gets up to n times the budget.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that have refilled are forgotten.
+const sweepInterval = time.Minute
+
+// Limiter allows Limit requests per Period for each key, in bursts of up to
+// Limit.
+type Limiter struct {
+	limit  int
+	period time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Result describes a key's bucket after a call to Allow.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request is allowed; zero when
+	// this one was.
+	RetryAfter time.Duration
+}
+
+// New returns a limiter; limit and period must be positive.
+func New(limit int, period time.Duration) *Limiter {
+	return &Limiter{limit: limit, period: period, now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+func (l *Limiter) Limit() int            { return l.limit }
+func (l *Limiter) Period() time.Duration { return l.period }
+
+// Allow takes a token from key's bucket if there is one.
+func (l *Limiter) Allow(key string) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.limit), b.tokens+now.Sub(b.updated).Seconds()*l.rate())
+	b.updated = now
+
+	res := Result{Limit: l.limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = l.after(1 - b.tokens)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = l.after(float64(l.limit) - b.tokens)
+	return res
+}
+
+// rate is in tokens per second.
+func (l *Limiter) rate() float64 {
+	return float64(l.limit) / l.period.Seconds()
+}
+
+// after is how long it takes to refill n tokens.
+func (l *Limiter) after(n float64) time.Duration {
+	return time.Duration(math.Ceil(n / l.rate() * float64(time.Second)))
+}
+
+// sweep drops buckets that would be full by now, which is the same as not
+// having one.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if now.Sub(b.updated) >= l.after(float64(l.limit)-b.tokens) {
+			delete(l.buckets, k)
+		}
+	}
+}
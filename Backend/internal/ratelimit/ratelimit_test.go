@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := New(3, 3*time.Second)
+	l.now = func() time.Time { return now }
+
+	for i := 2; i >= 0; i-- {
+		r := l.Allow("alice")
+		if !r.Allowed || r.Remaining != i || r.Limit != 3 {
+			t.Fatalf("request %d: %+v", 3-i, r)
+		}
+	}
+	r := l.Allow("alice")
+	if r.Allowed || r.RetryAfter != time.Second || r.Reset != 3*time.Second {
+		t.Fatalf("over the limit: %+v", r)
+	}
+	if r := l.Allow("bob"); !r.Allowed {
+		t.Fatalf("keys must not share a bucket: %+v", r)
+	}
+
+	now = now.Add(1500 * time.Millisecond)
+	if r := l.Allow("alice"); !r.Allowed || r.Remaining != 0 {
+		t.Fatalf("after refilling 1.5 tokens: %+v", r)
+	}
+	if r := l.Allow("alice"); r.Allowed || r.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("half a token left: %+v", r)
+	}
+}
+
+func TestLimiter_SweepsFullBuckets(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := New(10, time.Second)
+	l.now = func() time.Time { return now }
+
+	l.Allow("alice")
+	now = now.Add(2 * sweepInterval)
+	l.Allow("bob")
+	if _, ok := l.buckets["alice"]; ok || len(l.buckets) != 1 {
+		t.Fatalf("buckets=%v", l.buckets)
+	}
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+// APIKey lets a machine client act as UserID in one workspace, limited to
+// Scope. Only the SHA-256 of the secret is stored.
+type APIKey struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"userId"`
+	WorkspaceID string     `json:"workspaceId"`
+	Name        string     `json:"name,omitempty"`
+	Scope       string     `json:"scope"`
+	SecretHash  string     `json:"-"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+}
+
+// storedKey is how the memory and file backends persist a key, since the
+// secret hash is left out of APIKey's JSON.
+type storedKey struct {
+	APIKey
+	SecretHash string `json:"secretHash"`
+}
+
+func (s storedKey) key() APIKey {
+	k := s.APIKey
+	k.SecretHash = s.SecretHash
+	return k
+}
+
+// APIKeyStore keeps API keys. Revoked keys are kept so their owners can see
+// them; deleting a workspace deletes its keys.
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, k APIKey) error
+	GetAPIKey(ctx context.Context, id string) (APIKey, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) error
+	// TouchAPIKey records that the key was used at the given time.
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+	// DeleteAPIKeys removes every key the user created, revoked or not.
+	DeleteAPIKeys(ctx context.Context, userID string) error
+}
+
+func (s sqlWorkspaces) createKey(ctx context.Context, k APIKey) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, s.q(`
+INSERT INTO api_keys (id, user_id, workspace_id, name, scope, secret_hash, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)`), k.ID, k.UserID, k.WorkspaceID, k.Name, k.Scope, k.SecretHash, k.CreatedAt)
+	return wrapErr(err)
+}
+
+const keyColumns = `id, user_id, workspace_id, name, scope, secret_hash, created_at, last_used_at, revoked_at`
+
+func scanKey(sc interface{ Scan(...any) error }) (APIKey, error) {
+	var (
+		k             APIKey
+		used, revoked sql.NullTime
+	)
+	if err := sc.Scan(&k.ID, &k.UserID, &k.WorkspaceID, &k.Name, &k.Scope, &k.SecretHash, &k.CreatedAt, &used, &revoked); err != nil {
+		return APIKey{}, err
+	}
+	k.CreatedAt = k.CreatedAt.UTC()
+	k.LastUsedAt, k.RevokedAt = utcPtr(used), utcPtr(revoked)
+	return k, nil
+}
+
+func (s sqlWorkspaces) getKey(ctx context.Context, id string) (APIKey, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	k, err := scanKey(s.db.QueryRowContext(ctx, s.q(`SELECT `+keyColumns+` FROM api_keys WHERE id = ?`), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrNotFound
+	}
+	return k, wrapErr(err)
+}
+
+func (s sqlWorkspaces) listKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		s.q(`SELECT `+keyColumns+` FROM api_keys WHERE user_id = ? ORDER BY created_at, id`), userID)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	var out []APIKey
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		out = append(out, k)
+	}
+	return out, wrapErr(rows.Err())
+}
+
+func (s sqlWorkspaces) revokeKey(ctx context.Context, userID, id string, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx,
+		s.q(`UPDATE api_keys SET revoked_at = COALESCE(revoked_at, ?) WHERE user_id = ? AND id = ?`), at, userID, id)
+	if err != nil {
+		return wrapErr(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return wrapErr(err)
+	}
+	if n > 0 {
+		return nil
+	}
+	// MySQL counts changed rows only, so revoking twice affects nothing.
+	var one int
+	err = s.db.QueryRowContext(ctx, s.q(`SELECT 1 FROM api_keys WHERE user_id = ? AND id = ?`), userID, id).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return wrapErr(err)
+}
+
+func (s sqlWorkspaces) touchKey(ctx context.Context, id string, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, s.q(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`), at, id)
+	return wrapErr(err)
+}
+
+func (s sqlWorkspaces) deleteKeys(ctx context.Context, userID string) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, s.q(`DELETE FROM api_keys WHERE user_id = ?`), userID)
+	return wrapErr(err)
+}
+
+func sortKeys(ks []APIKey) {
+	sort.Slice(ks, func(i, j int) bool {
+		if !ks[i].CreatedAt.Equal(ks[j].CreatedAt) {
+			return ks[i].CreatedAt.Before(ks[j].CreatedAt)
+		}
+		return ks[i].ID < ks[j].ID
+	})
+}
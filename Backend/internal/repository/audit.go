@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AuditEntry records one change to a workspace's classifier: who made it,
+// the operation with its payload, and a diff of the state it changed.
+type AuditEntry struct {
+	ID          int64  `json:"id"`
+	WorkspaceID string `json:"workspaceId"`
+	Actor       string `json:"actor"`
+	// Via is how the actor authenticated: token, header, cookie, or
+	// apikey:<key ID>.
+	Via       string          `json:"via,omitempty"`
+	RequestID string          `json:"requestId,omitempty"`
+	Operation string          `json:"operation"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Diff      json.RawMessage `json:"diff"`
+	// Properties are the property names the change touched, which
+	// ListAudit can filter on.
+	Properties []string  `json:"properties,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// AuditQuery selects audit entries. Empty fields match everything; at least
+// one of WorkspaceID and Actor is required.
+type AuditQuery struct {
+	WorkspaceID string
+	Actor       string
+	// Since is inclusive and Until exclusive.
+	Since, Until time.Time
+	Operation    string
+	Property     string
+	// Before pages backwards: only entries with a smaller ID are returned.
+	Before int64
+	Limit  int
+}
+
+func (q AuditQuery) matches(e AuditEntry) bool {
+	return (q.WorkspaceID == "" || e.WorkspaceID == q.WorkspaceID) &&
+		(q.Actor == "" || e.Actor == q.Actor) &&
+		(q.Since.IsZero() || !e.At.Before(q.Since)) &&
+		(q.Until.IsZero() || e.At.Before(q.Until)) &&
+		(q.Operation == "" || e.Operation == q.Operation) &&
+		(q.Property == "" || slices.Contains(e.Properties, q.Property)) &&
+		(q.Before <= 0 || e.ID < q.Before)
+}
+
+// AuditStore keeps the audit log. Entries are appended and never changed,
+// except that RedactAuditActor blanks the actor when a user is erased. They
+// outlive their workspace; only DeleteAudit, for erasing the owner's data,
+// removes them.
+type AuditStore interface {
+	// AppendAudit stores e and returns it with ID set. IDs increase with
+	// every append.
+	AppendAudit(ctx context.Context, e AuditEntry) (AuditEntry, error)
+	// ListAudit returns matching entries, newest first.
+	ListAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error)
+	RedactAuditActor(ctx context.Context, actor string) error
+	DeleteAudit(ctx context.Context, workspaceID string) error
+}
+
+func (s sqlWorkspaces) appendAudit(ctx context.Context, e AuditEntry) (AuditEntry, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return AuditEntry{}, wrapErr(err)
+	}
+	defer tx.Rollback()
+
+	if e, err = s.insertAudit(ctx, tx, e); err != nil {
+		return AuditEntry{}, err
+	}
+	return e, wrapErr(tx.Commit())
+}
+
+// insertAudit adds e and its properties inside tx and returns it with ID set.
+func (s sqlWorkspaces) insertAudit(ctx context.Context, tx *sql.Tx, e AuditEntry) (AuditEntry, error) {
+	query := `
+INSERT INTO audit_log (workspace_id, actor, via, request_id, operation, payload, diff, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	args := []any{e.WorkspaceID, e.Actor, e.Via, e.RequestID, e.Operation, nullableJSON(e.Payload), string(e.Diff), e.At}
+	var err error
+	if s.postgres {
+		err = tx.QueryRowContext(ctx, s.q(query+` RETURNING id`), args...).Scan(&e.ID)
+	} else {
+		var res sql.Result
+		if res, err = tx.ExecContext(ctx, query, args...); err == nil {
+			e.ID, err = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		return AuditEntry{}, wrapErr(err)
+	}
+	for _, p := range e.Properties {
+		if _, err := tx.ExecContext(ctx, s.q(`INSERT INTO audit_log_properties (entry_id, property) VALUES (?, ?)`), e.ID, p); err != nil {
+			return AuditEntry{}, wrapErr(err)
+		}
+	}
+	return e, nil
+}
+
+func (s sqlWorkspaces) listAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	var (
+		where []string
+		args  []any
+	)
+	add := func(cond string, arg any) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if q.WorkspaceID != "" {
+		add(`workspace_id = ?`, q.WorkspaceID)
+	}
+	if q.Actor != "" {
+		add(`actor = ?`, q.Actor)
+	}
+	if !q.Since.IsZero() {
+		add(`created_at >= ?`, q.Since)
+	}
+	if !q.Until.IsZero() {
+		add(`created_at < ?`, q.Until)
+	}
+	if q.Operation != "" {
+		add(`operation = ?`, q.Operation)
+	}
+	if q.Property != "" {
+		add(`id IN (SELECT entry_id FROM audit_log_properties WHERE property = ?)`, q.Property)
+	}
+	if q.Before > 0 {
+		add(`id < ?`, q.Before)
+	}
+	query := `SELECT id, workspace_id, actor, via, request_id, operation, payload, diff, created_at FROM audit_log`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY id DESC`
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.q(query), args...)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	var (
+		out   []AuditEntry
+		index = map[int64]int{}
+	)
+	for rows.Next() {
+		var (
+			e             AuditEntry
+			payload, diff []byte
+		)
+		if err := rows.Scan(&e.ID, &e.WorkspaceID, &e.Actor, &e.Via, &e.RequestID, &e.Operation, &payload, &diff, &e.At); err != nil {
+			return nil, wrapErr(err)
+		}
+		e.At = e.At.UTC()
+		if len(payload) > 0 {
+			e.Payload = json.RawMessage(payload)
+		}
+		e.Diff = json.RawMessage(diff)
+		index[e.ID] = len(out)
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	if len(out) == 0 {
+		return out, nil
+	}
+	return out, s.auditProperties(ctx, out, index)
+}
+
+// auditProperties fills in the properties of the listed entries, which span
+// the ID range of the page.
+func (s sqlWorkspaces) auditProperties(ctx context.Context, out []AuditEntry, index map[int64]int) error {
+	rows, err := s.db.QueryContext(ctx,
+		s.q(`SELECT entry_id, property FROM audit_log_properties WHERE entry_id BETWEEN ? AND ? ORDER BY entry_id, property`),
+		out[len(out)-1].ID, out[0].ID)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id   int64
+			prop string
+		)
+		if err := rows.Scan(&id, &prop); err != nil {
+			return wrapErr(err)
+		}
+		if i, ok := index[id]; ok {
+			out[i].Properties = append(out[i].Properties, prop)
+		}
+	}
+	return wrapErr(rows.Err())
+}
+
+func (s sqlWorkspaces) redactAuditActor(ctx context.Context, actor string) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, s.q(`UPDATE audit_log SET actor = '', via = '' WHERE actor = ?`), actor)
+	return wrapErr(err)
+}
+
+func (s sqlWorkspaces) deleteAudit(ctx context.Context, workspaceID string) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.q(`
+DELETE FROM audit_log_properties
+WHERE entry_id IN (SELECT id FROM audit_log WHERE workspace_id = ?)`), workspaceID); err != nil {
+		return wrapErr(err)
+	}
+	if _, err := tx.ExecContext(ctx, s.q(`DELETE FROM audit_log WHERE workspace_id = ?`), workspaceID); err != nil {
+		return wrapErr(err)
+	}
+	return wrapErr(tx.Commit())
+}
+
+// sortAudit orders entries newest first.
+func sortAudit(es []AuditEntry) {
+	slices.SortFunc(es, func(a, b AuditEntry) int {
+		switch {
+		case a.ID > b.ID:
+			return -1
+		case a.ID < b.ID:
+			return 1
+		}
+		return 0
+	})
+}
+
+// limitAudit applies q's matching and limit to entries sorted newest first.
+func limitAudit(es []AuditEntry, q AuditQuery) []AuditEntry {
+	out := []AuditEntry{}
+	for _, e := range es {
+		if !q.matches(e) {
+			continue
+		}
+		out = append(out, e)
+		if q.Limit > 0 && len(out) == q.Limit {
+			break
+		}
+	}
+	return out
+}
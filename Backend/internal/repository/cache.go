@@ -0,0 +1,368 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+)
+
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+}
+
+type StatsReporter interface {
+	Stats() CacheStats
+}
+
+type cacheEntry struct {
+	userID  string
+	state   State
+	expires time.Time
+}
+
+// CachedRepo keeps recently used states in a bounded LRU in front of another
+// Repository. Writes go through to the inner repository before the cache is
+// updated, and entries expire after ttl so that writes made by other replicas
+// become visible within that bound. Writes for the same user are serialised
+// so the cached value always matches the last write to the inner repository.
+type CachedRepo struct {
+	inner Repository
+	size  int
+	ttl   time.Duration
+	now   func() time.Time
+
+	writeLocks [64]sync.Mutex
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	gen   uint64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+func NewCached(inner Repository, size int, ttl time.Duration) *CachedRepo {
+	if size <= 0 {
+		size = 1
+	}
+	return &CachedRepo{
+		inner: inner,
+		size:  size,
+		ttl:   ttl,
+		now:   time.Now,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+func (c *CachedRepo) GetState(ctx context.Context, userID string) (State, error) {
+	if st, ok := c.lookup(userID); ok {
+		c.hits.Add(1)
+		return st, nil
+	}
+	c.misses.Add(1)
+
+	gen := c.generation()
+	st, err := c.inner.GetState(ctx, userID)
+	if err != nil {
+		return State{}, err
+	}
+	c.storeIfCurrent(userID, st, gen)
+	return cloneState(st), nil
+}
+
+func (c *CachedRepo) UpsertState(ctx context.Context, userID string, st State) error {
+	l := c.writeLock(userID)
+	l.Lock()
+	defer l.Unlock()
+
+	if err := c.inner.UpsertState(ctx, userID, st); err != nil {
+		c.Invalidate(userID)
+		return err
+	}
+	c.store(userID, st)
+	return nil
+}
+
+func (c *CachedRepo) ResetUser(ctx context.Context, userID string) error {
+	l := c.writeLock(userID)
+	l.Lock()
+	defer l.Unlock()
+
+	err := c.inner.ResetUser(ctx, userID)
+	c.Invalidate(userID)
+	return err
+}
+
+// LoadState reads through to the inner repository, since a read-modify-write
+// based on a cached state could overwrite another replica's write. The result
+// refreshes the cache.
+func (c *CachedRepo) LoadState(ctx context.Context, id string) (State, int64, error) {
+	gen := c.generation()
+	st, version, err := c.inner.LoadState(ctx, id)
+	if err != nil {
+		return State{}, 0, err
+	}
+	c.storeIfCurrent(id, st, gen)
+	return st, version, nil
+}
+
+func (c *CachedRepo) CommitState(ctx context.Context, sc StateCommit) error {
+	l := c.writeLock(sc.ID)
+	l.Lock()
+	defer l.Unlock()
+
+	if sc.MoveTo != "" {
+		defer c.Invalidate(sc.MoveTo)
+	}
+	if err := c.inner.CommitState(ctx, sc); err != nil || sc.Reset || sc.MoveTo != "" {
+		c.Invalidate(sc.ID)
+		return err
+	}
+	c.store(sc.ID, sc.State)
+	return nil
+}
+
+func (c *CachedRepo) CreateWorkspace(ctx context.Context, ws Workspace) error {
+	return c.inner.CreateWorkspace(ctx, ws)
+}
+
+func (c *CachedRepo) GetWorkspace(ctx context.Context, id string) (Workspace, error) {
+	return c.inner.GetWorkspace(ctx, id)
+}
+
+func (c *CachedRepo) ListWorkspaces(ctx context.Context, ownerID string) ([]Workspace, error) {
+	return c.inner.ListWorkspaces(ctx, ownerID)
+}
+
+func (c *CachedRepo) RenameWorkspace(ctx context.Context, id, name string, at time.Time) error {
+	return c.inner.RenameWorkspace(ctx, id, name, at)
+}
+
+// DeleteWorkspace drops the workspace's cached state along with it.
+func (c *CachedRepo) DeleteWorkspace(ctx context.Context, id string) error {
+	l := c.writeLock(id)
+	l.Lock()
+	defer l.Unlock()
+
+	err := c.inner.DeleteWorkspace(ctx, id)
+	c.Invalidate(id)
+	return err
+}
+
+func (c *CachedRepo) ScanWorkspaces(ctx context.Context, after string, limit int) ([]WorkspaceActivity, error) {
+	return c.inner.ScanWorkspaces(ctx, after, limit)
+}
+
+func (c *CachedRepo) ScanLegacyStates(ctx context.Context, after string, limit int) ([]LegacyState, error) {
+	return c.inner.ScanLegacyStates(ctx, after, limit)
+}
+
+func (c *CachedRepo) TouchWorkspace(ctx context.Context, id string, at time.Time) error {
+	return c.inner.TouchWorkspace(ctx, id, at)
+}
+
+func (c *CachedRepo) PutGrant(ctx context.Context, g Grant) error {
+	return c.inner.PutGrant(ctx, g)
+}
+
+func (c *CachedRepo) GetGrant(ctx context.Context, workspaceID, userID string) (Grant, error) {
+	return c.inner.GetGrant(ctx, workspaceID, userID)
+}
+
+func (c *CachedRepo) ListGrants(ctx context.Context, workspaceID string) ([]Grant, error) {
+	return c.inner.ListGrants(ctx, workspaceID)
+}
+
+func (c *CachedRepo) ListGrantsFor(ctx context.Context, userID string) ([]Grant, error) {
+	return c.inner.ListGrantsFor(ctx, userID)
+}
+
+func (c *CachedRepo) DeleteGrant(ctx context.Context, workspaceID, userID string) error {
+	return c.inner.DeleteGrant(ctx, workspaceID, userID)
+}
+
+func (c *CachedRepo) CreateShareLink(ctx context.Context, l ShareLink) error {
+	return c.inner.CreateShareLink(ctx, l)
+}
+
+func (c *CachedRepo) GetShareLinkByHash(ctx context.Context, tokenHash string) (ShareLink, error) {
+	return c.inner.GetShareLinkByHash(ctx, tokenHash)
+}
+
+func (c *CachedRepo) ListShareLinks(ctx context.Context, workspaceID string) ([]ShareLink, error) {
+	return c.inner.ListShareLinks(ctx, workspaceID)
+}
+
+func (c *CachedRepo) RevokeShareLink(ctx context.Context, workspaceID, id string, at time.Time) error {
+	return c.inner.RevokeShareLink(ctx, workspaceID, id, at)
+}
+
+func (c *CachedRepo) CreateAPIKey(ctx context.Context, k APIKey) error {
+	return c.inner.CreateAPIKey(ctx, k)
+}
+
+func (c *CachedRepo) GetAPIKey(ctx context.Context, id string) (APIKey, error) {
+	return c.inner.GetAPIKey(ctx, id)
+}
+
+func (c *CachedRepo) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	return c.inner.ListAPIKeys(ctx, userID)
+}
+
+func (c *CachedRepo) RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) error {
+	return c.inner.RevokeAPIKey(ctx, userID, id, at)
+}
+
+func (c *CachedRepo) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	return c.inner.TouchAPIKey(ctx, id, at)
+}
+
+func (c *CachedRepo) DeleteAPIKeys(ctx context.Context, userID string) error {
+	return c.inner.DeleteAPIKeys(ctx, userID)
+}
+
+func (c *CachedRepo) MarkLegacySession(ctx context.Context, hash string, at time.Time) error {
+	return c.inner.MarkLegacySession(ctx, hash, at)
+}
+
+func (c *CachedRepo) AppendAudit(ctx context.Context, e AuditEntry) (AuditEntry, error) {
+	return c.inner.AppendAudit(ctx, e)
+}
+
+func (c *CachedRepo) ListAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	return c.inner.ListAudit(ctx, q)
+}
+
+func (c *CachedRepo) RedactAuditActor(ctx context.Context, actor string) error {
+	return c.inner.RedactAuditActor(ctx, actor)
+}
+
+func (c *CachedRepo) DeleteAudit(ctx context.Context, workspaceID string) error {
+	return c.inner.DeleteAudit(ctx, workspaceID)
+}
+
+func (c *CachedRepo) Invalidate(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if el, ok := c.items[userID]; ok {
+		c.ll.Remove(el)
+		delete(c.items, userID)
+	}
+}
+
+func (c *CachedRepo) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.ll.Init()
+	clear(c.items)
+}
+
+func (c *CachedRepo) Stats() CacheStats {
+	c.mu.Lock()
+	n := c.ll.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   n,
+	}
+}
+
+func (c *CachedRepo) lookup(userID string) (State, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[userID]
+	if !ok {
+		return State{}, false
+	}
+	e := el.Value.(*cacheEntry)
+	if c.ttl > 0 && !c.now().Before(e.expires) {
+		c.ll.Remove(el)
+		delete(c.items, userID)
+		return State{}, false
+	}
+	c.ll.MoveToFront(el)
+	return cloneState(e.state), true
+}
+
+func (c *CachedRepo) writeLock(userID string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(userID))
+	return &c.writeLocks[h.Sum32()%uint32(len(c.writeLocks))]
+}
+
+func (c *CachedRepo) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// storeIfCurrent skips caching a value read from the inner repository when a
+// write or invalidation happened while the read was in flight, since the
+// value may be stale.
+func (c *CachedRepo) storeIfCurrent(userID string, st State, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return
+	}
+	c.put(userID, st)
+}
+
+func (c *CachedRepo) store(userID string, st State) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.put(userID, st)
+}
+
+func (c *CachedRepo) put(userID string, st State) {
+	e := &cacheEntry{userID: userID, state: cloneState(st), expires: c.now().Add(c.ttl)}
+	if el, ok := c.items[userID]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[userID] = c.ll.PushFront(e)
+	for c.ll.Len() > c.size {
+		last := c.ll.Back()
+		c.ll.Remove(last)
+		delete(c.items, last.Value.(*cacheEntry).userID)
+		c.evictions.Add(1)
+	}
+}
+
+func cloneState(st State) State {
+	return State{
+		Class1:       models.Class{Name: st.Class1.Name, Properties: cloneStrings(st.Class1.Properties)},
+		Class2:       models.Class{Name: st.Class2.Name, Properties: cloneStrings(st.Class2.Properties)},
+		GeneralClass: cloneStrings(st.GeneralClass),
+		NoneClass:    cloneStrings(st.NoneClass),
+	}
+}
+
+func cloneStrings(ss []string) []string {
+	if ss == nil {
+		return nil
+	}
+	return append([]string(nil), ss...)
+}
+
+var (
+	_ Repository    = (*CachedRepo)(nil)
+	_ StatsReporter = (*CachedRepo)(nil)
+)
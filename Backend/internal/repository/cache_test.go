@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+)
+
+type countingRepo struct {
+	WorkspaceStore
+	GrantStore
+	ShareLinkStore
+	APIKeyStore
+	AuditStore
+	LegacySessionStore
+	state    map[string]State
+	versions map[string]int64
+	gets     int
+}
+
+func newCountingRepo() *countingRepo {
+	m := NewMemory()
+	return &countingRepo{WorkspaceStore: m, GrantStore: m, ShareLinkStore: m, APIKeyStore: m, AuditStore: m, LegacySessionStore: m,
+		state: make(map[string]State), versions: make(map[string]int64)}
+}
+
+func (m *countingRepo) GetState(_ context.Context, userID string) (State, error) {
+	m.gets++
+	return cloneState(m.state[userID]), nil
+}
+func (m *countingRepo) UpsertState(_ context.Context, userID string, st State) error {
+	m.state[userID] = cloneState(st)
+	m.versions[userID]++
+	return nil
+}
+func (m *countingRepo) ResetUser(_ context.Context, userID string) error {
+	delete(m.state, userID)
+	m.versions[userID]++
+	return nil
+}
+func (m *countingRepo) LoadState(ctx context.Context, id string) (State, int64, error) {
+	st, err := m.GetState(ctx, id)
+	return st, m.versions[id], err
+}
+func (m *countingRepo) CommitState(ctx context.Context, c StateCommit) error {
+	if c.Create != nil {
+		if err := m.CreateWorkspace(ctx, *c.Create); err != nil {
+			return err
+		}
+	} else if m.versions[c.ID] != c.Version {
+		return ErrStale
+	}
+	if c.MoveTo != "" {
+		_ = m.ResetUser(ctx, c.ID)
+		return m.UpsertState(ctx, c.MoveTo, c.State)
+	}
+	if c.Reset {
+		return m.ResetUser(ctx, c.ID)
+	}
+	return m.UpsertState(ctx, c.ID, c.State)
+}
+
+func TestCachedRepo_HitsAndWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	inner := newCountingRepo()
+	c := NewCached(inner, 8, time.Minute)
+
+	st := State{Class1: models.Class{Name: "Cat", Properties: []string{"purr"}}}
+	if err := c.UpsertState(ctx, "u1", st); err != nil {
+		t.Fatal(err)
+	}
+	if inner.state["u1"].Class1.Name != "Cat" {
+		t.Fatalf("upsert not written through: %+v", inner.state["u1"])
+	}
+
+	got, _ := c.GetState(ctx, "u1")
+	if !reflect.DeepEqual(got, st) {
+		t.Fatalf("got=%+v; want %+v", got, st)
+	}
+	if inner.gets != 0 {
+		t.Fatalf("inner gets=%d; want 0", inner.gets)
+	}
+
+	got.Class1.Properties[0] = "mutated"
+	again, _ := c.GetState(ctx, "u1")
+	if again.Class1.Properties[0] != "purr" {
+		t.Fatalf("cached state shared with caller: %v", again.Class1.Properties)
+	}
+
+	if err := c.ResetUser(ctx, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = c.GetState(ctx, "u1")
+	if got.Class1.Name != "" {
+		t.Fatalf("state after reset=%+v; want empty", got)
+	}
+
+	s := c.Stats()
+	if s.Hits != 2 || s.Misses != 1 {
+		t.Fatalf("stats=%+v; want 2 hits, 1 miss", s)
+	}
+}
+
+func TestCachedRepo_TTLAndEviction(t *testing.T) {
+	ctx := context.Background()
+	inner := newCountingRepo()
+	c := NewCached(inner, 2, time.Second)
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+
+	_, _ = c.GetState(ctx, "a")
+	_, _ = c.GetState(ctx, "a")
+	if inner.gets != 1 {
+		t.Fatalf("inner gets=%d; want 1", inner.gets)
+	}
+
+	inner.state["a"] = State{Class1: models.Class{Name: "fresh"}}
+	now = now.Add(2 * time.Second)
+	got, _ := c.GetState(ctx, "a")
+	if got.Class1.Name != "fresh" {
+		t.Fatalf("expired entry served: %+v", got)
+	}
+
+	_, _ = c.GetState(ctx, "b")
+	_, _ = c.GetState(ctx, "c")
+	if s := c.Stats(); s.Entries != 2 || s.Evictions != 1 {
+		t.Fatalf("stats=%+v; want 2 entries, 1 eviction", s)
+	}
+	before := inner.gets
+	_, _ = c.GetState(ctx, "a")
+	if inner.gets != before+1 {
+		t.Fatalf("least recently used entry was not evicted")
+	}
+}
+
+type gatedRepo struct {
+	*countingRepo
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (g *gatedRepo) GetState(ctx context.Context, userID string) (State, error) {
+	st, err := g.countingRepo.GetState(ctx, userID)
+	close(g.reading)
+	<-g.release
+	return st, err
+}
+
+func TestCachedRepo_InFlightReadDoesNotOverwriteWrite(t *testing.T) {
+	ctx := context.Background()
+	inner := &gatedRepo{countingRepo: newCountingRepo(), reading: make(chan struct{}), release: make(chan struct{})}
+	inner.state["u"] = State{Class1: models.Class{Name: "old"}}
+	c := NewCached(inner, 8, time.Minute)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = c.GetState(ctx, "u")
+	}()
+	<-inner.reading
+	if err := c.UpsertState(ctx, "u", State{Class1: models.Class{Name: "new"}}); err != nil {
+		t.Fatal(err)
+	}
+	close(inner.release)
+	<-done
+
+	if st, ok := c.lookup("u"); !ok || st.Class1.Name != "new" {
+		t.Fatalf("cached=%+v ok=%v; want the newer write", st, ok)
+	}
+}
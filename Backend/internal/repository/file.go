@@ -0,0 +1,1141 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+)
+
+const (
+	fileLockName      = ".lock"
+	fileWorkspacesDir = "workspaces"
+	fileGrantsDir     = "grants"
+	fileLinksDir      = "links"
+	fileKeysDir       = "keys"
+	fileAuditDir      = "audit"
+	fileSessionsDir   = "legacy-sessions"
+	fileAuditSeq      = ".seq"
+)
+
+// FileRepo persists one JSON document per user in a local directory. Every
+// write goes to a temporary file that is fsynced and renamed over the old one,
+// so a crash leaves either the previous or the new state on disk and readers
+// never observe a partial file. Writers are serialised within the process by a
+// mutex and across processes by a lock file in the directory.
+type FileRepo struct {
+	dir  string
+	mu   sync.Mutex
+	lock *os.File
+}
+
+type fileRecord struct {
+	UserID       string       `json:"userId"`
+	Class1       models.Class `json:"class1"`
+	Class2       models.Class `json:"class2"`
+	GeneralClass []string     `json:"generalClass"`
+	NoneClass    []string     `json:"noneClass"`
+	UpdatedAt    time.Time    `json:"updatedAt"`
+	// Version counts writes; files written before it existed read as 1.
+	Version int64 `json:"version,omitempty"`
+}
+
+func NewFile(dir string) (*FileRepo, error) {
+	if dir == "" {
+		return nil, errors.New("file repository: empty directory")
+	}
+	for _, sub := range []string{fileWorkspacesDir, fileGrantsDir, fileLinksDir, fileKeysDir, fileAuditDir, fileSessionsDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, fileLockName), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileRepo{dir: dir, lock: lock}, nil
+}
+
+// FileDirFromDSN extracts the directory from a DSN such as file:///var/lib/slc
+// (absolute) or file://data/slc and file:./data (relative).
+func FileDirFromDSN(dsn string) (string, error) {
+	dir, err := dsnPath(dsn, "file")
+	if err != nil {
+		return "", err
+	}
+	if dir == "" {
+		return "", errors.New("file DSN has no path")
+	}
+	return dir, nil
+}
+
+func (r *FileRepo) Close() error {
+	return r.lock.Close()
+}
+
+func (r *FileRepo) path(userID string) string {
+	return filepath.Join(r.dir, base64.RawURLEncoding.EncodeToString([]byte(userID))+".json")
+}
+
+func (r *FileRepo) GetState(ctx context.Context, userID string) (State, error) {
+	if err := ctx.Err(); err != nil {
+		return State{}, wrapErr(err)
+	}
+	st, _, err := r.load(userID)
+	return st, err
+}
+
+func (r *FileRepo) UpsertState(ctx context.Context, userID string, st State) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	return r.writeLocked(func() error {
+		_, version, err := r.load(userID)
+		if err != nil {
+			return err
+		}
+		return r.writeState(userID, version, st)
+	})
+}
+
+// writeState stores st as the version after version; the caller holds the
+// write lock.
+func (r *FileRepo) writeState(userID string, version int64, st State) error {
+	rec := fileRecord{
+		UserID:       userID,
+		Class1:       st.Class1,
+		Class2:       st.Class2,
+		GeneralClass: st.GeneralClass,
+		NoneClass:    st.NoneClass,
+		UpdatedAt:    time.Now().UTC(),
+		Version:      version + 1,
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(r.dir, r.path(userID), data)
+}
+
+func (r *FileRepo) LoadState(ctx context.Context, id string) (State, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return State{}, 0, wrapErr(err)
+	}
+	return r.load(id)
+}
+
+// load reads a state and its version, 0 if there is no file.
+func (r *FileRepo) load(userID string) (State, int64, error) {
+	rec, err := r.read(userID)
+	if errors.Is(err, fs.ErrNotExist) {
+		return State{}, 0, nil
+	}
+	if err != nil {
+		return State{}, 0, err
+	}
+	st := State{
+		Class1:       rec.Class1,
+		Class2:       rec.Class2,
+		GeneralClass: rec.GeneralClass,
+		NoneClass:    rec.NoneClass,
+	}
+	return st, max(rec.Version, 1), nil
+}
+
+func (r *FileRepo) CommitState(ctx context.Context, c StateCommit) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	return r.writeLocked(func() error {
+		_, version, err := r.load(c.ID)
+		if err != nil {
+			return err
+		}
+		if c.Create != nil {
+			if err := r.createWorkspace(*c.Create); err != nil {
+				return err
+			}
+		} else if version != c.Version {
+			return ErrStale
+		}
+		// The files cannot change together, so the entry goes first: a
+		// crash before the state is written leaves an entry for a change
+		// that failed, never a change without its entry.
+		if c.Audit != nil {
+			if _, err := r.appendAudit(*c.Audit); err != nil {
+				return err
+			}
+		}
+		if c.MoveTo != "" {
+			// Written before the source is removed, so a crash in between
+			// leaves the state in both places rather than in neither.
+			_, to, err := r.load(c.MoveTo)
+			if err != nil {
+				return err
+			}
+			if err := r.writeState(c.MoveTo, to, c.State); err != nil {
+				return err
+			}
+			return r.removeState(c.ID)
+		}
+		if c.Reset {
+			return r.removeState(c.ID)
+		}
+		return r.writeState(c.ID, version, c.State)
+	})
+}
+
+func (r *FileRepo) ResetUser(ctx context.Context, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	return r.writeLocked(func() error { return r.removeState(userID) })
+}
+
+func (r *FileRepo) removeState(userID string) error {
+	if err := os.Remove(r.path(userID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return syncDir(r.dir)
+}
+
+func (r *FileRepo) workspacePath(id string) string {
+	return filepath.Join(r.dir, fileWorkspacesDir, base64.RawURLEncoding.EncodeToString([]byte(id))+".json")
+}
+
+// writeLocked runs fn while holding both the in-process and the cross-process
+// write lock.
+func (r *FileRepo) writeLocked(fn func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := lockFile(r.lock); err != nil {
+		return err
+	}
+	defer unlockFile(r.lock)
+	return fn()
+}
+
+func (r *FileRepo) CreateWorkspace(ctx context.Context, ws Workspace) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	return r.writeLocked(func() error { return r.createWorkspace(ws) })
+}
+
+// createWorkspace writes ws unless its ID is taken; the caller holds the
+// write lock.
+func (r *FileRepo) createWorkspace(ws Workspace) error {
+	data, err := json.Marshal(ws)
+	if err != nil {
+		return err
+	}
+	path := r.workspacePath(ws.ID)
+	if _, err := os.Stat(path); err == nil {
+		return ErrConflict
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return writeFileAtomic(filepath.Dir(path), path, data)
+}
+
+func (r *FileRepo) GetWorkspace(ctx context.Context, id string) (Workspace, error) {
+	if err := ctx.Err(); err != nil {
+		return Workspace{}, wrapErr(err)
+	}
+	return r.readWorkspace(r.workspacePath(id))
+}
+
+func (r *FileRepo) ListWorkspaces(ctx context.Context, ownerID string) ([]Workspace, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	entries, err := os.ReadDir(filepath.Join(r.dir, fileWorkspacesDir))
+	if err != nil {
+		return nil, err
+	}
+	var out []Workspace
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		ws, err := r.readWorkspace(filepath.Join(r.dir, fileWorkspacesDir, e.Name()))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ws.OwnerID == ownerID {
+			out = append(out, ws)
+		}
+	}
+	sortWorkspaces(out)
+	return out, nil
+}
+
+func (r *FileRepo) ScanWorkspaces(ctx context.Context, after string, limit int) ([]WorkspaceActivity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	entries, err := os.ReadDir(filepath.Join(r.dir, fileWorkspacesDir))
+	if err != nil {
+		return nil, err
+	}
+	// File names are encoded IDs, which do not sort like the IDs themselves.
+	var all []Workspace
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		ws, err := r.readWorkspace(filepath.Join(r.dir, fileWorkspacesDir, e.Name()))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ws.ID > after {
+			all = append(all, ws)
+		}
+	}
+	slices.SortFunc(all, func(a, b Workspace) int { return strings.Compare(a.ID, b.ID) })
+	if len(all) > limit {
+		all = all[:limit]
+	}
+	out := make([]WorkspaceActivity, 0, len(all))
+	for _, ws := range all {
+		a := WorkspaceActivity{Workspace: ws, LastWrite: ws.UpdatedAt}
+		rec, err := r.read(ws.ID)
+		switch {
+		case err == nil:
+			if rec.UpdatedAt.After(a.LastWrite) {
+				a.LastWrite = rec.UpdatedAt.UTC()
+			}
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+func (r *FileRepo) ScanLegacyStates(ctx context.Context, after string, limit int) ([]LegacyState, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if e.IsDir() || !ok {
+			continue
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(name)
+		if err != nil || string(raw) <= after {
+			continue
+		}
+		switch _, err := os.Stat(r.workspacePath(string(raw))); {
+		case errors.Is(err, fs.ErrNotExist):
+			ids = append(ids, string(raw))
+		case err != nil:
+			return nil, err
+		}
+	}
+	slices.Sort(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	out := make([]LegacyState, 0, len(ids))
+	for _, id := range ids {
+		rec, err := r.read(id)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, LegacyState{UserID: id, LastWrite: rec.UpdatedAt.UTC()})
+	}
+	return out, nil
+}
+
+func (r *FileRepo) TouchWorkspace(ctx context.Context, id string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	path := r.workspacePath(id)
+	err := r.writeLocked(func() error {
+		ws, err := r.readWorkspace(path)
+		if err != nil {
+			return err
+		}
+		ws.AccessedAt = &at
+		data, err := json.Marshal(ws)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(filepath.Dir(path), path, data)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (r *FileRepo) RenameWorkspace(ctx context.Context, id, name string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	path := r.workspacePath(id)
+	return r.writeLocked(func() error {
+		ws, err := r.readWorkspace(path)
+		if err != nil {
+			return err
+		}
+		ws.Name, ws.UpdatedAt = name, at
+		data, err := json.Marshal(ws)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(filepath.Dir(path), path, data)
+	})
+}
+
+func (r *FileRepo) DeleteWorkspace(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	path := r.workspacePath(id)
+	return r.writeLocked(func() error {
+		if err := os.Remove(path); errors.Is(err, fs.ErrNotExist) {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		for _, p := range []string{r.path(id), r.grantsPath(id)} {
+			if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		links, err := r.readLinks(id)
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			if err := os.Remove(r.linkPath(l.TokenHash)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		keys, err := r.readKeys(func(k APIKey) bool { return k.WorkspaceID == id })
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := os.Remove(r.keyPath(k.ID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if err := syncDir(filepath.Dir(path)); err != nil {
+			return err
+		}
+		return syncDir(r.dir)
+	})
+}
+
+// Grants of a workspace are kept together in one file.
+func (r *FileRepo) grantsPath(workspaceID string) string {
+	return filepath.Join(r.dir, fileGrantsDir, base64.RawURLEncoding.EncodeToString([]byte(workspaceID))+".json")
+}
+
+func (r *FileRepo) PutGrant(ctx context.Context, g Grant) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	return r.writeLocked(func() error {
+		if _, err := r.readWorkspace(r.workspacePath(g.WorkspaceID)); err != nil {
+			return err
+		}
+		gs, err := r.readGrants(r.grantsPath(g.WorkspaceID))
+		if err != nil {
+			return err
+		}
+		replaced := false
+		for i := range gs {
+			if gs[i].UserID == g.UserID {
+				gs[i].Role = g.Role
+				replaced = true
+			}
+		}
+		if !replaced {
+			gs = append(gs, g)
+		}
+		return r.writeGrants(g.WorkspaceID, gs)
+	})
+}
+
+func (r *FileRepo) GetGrant(ctx context.Context, workspaceID, userID string) (Grant, error) {
+	if err := ctx.Err(); err != nil {
+		return Grant{}, wrapErr(err)
+	}
+	gs, err := r.readGrants(r.grantsPath(workspaceID))
+	if err != nil {
+		return Grant{}, err
+	}
+	for _, g := range gs {
+		if g.UserID == userID {
+			return g, nil
+		}
+	}
+	return Grant{}, ErrNotFound
+}
+
+func (r *FileRepo) ListGrants(ctx context.Context, workspaceID string) ([]Grant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	gs, err := r.readGrants(r.grantsPath(workspaceID))
+	if err != nil {
+		return nil, err
+	}
+	sortGrants(gs)
+	return gs, nil
+}
+
+func (r *FileRepo) ListGrantsFor(ctx context.Context, userID string) ([]Grant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	entries, err := os.ReadDir(filepath.Join(r.dir, fileGrantsDir))
+	if err != nil {
+		return nil, err
+	}
+	var out []Grant
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		gs, err := r.readGrants(filepath.Join(r.dir, fileGrantsDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range gs {
+			if g.UserID == userID {
+				out = append(out, g)
+			}
+		}
+	}
+	sortGrants(out)
+	return out, nil
+}
+
+func (r *FileRepo) DeleteGrant(ctx context.Context, workspaceID, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	return r.writeLocked(func() error {
+		gs, err := r.readGrants(r.grantsPath(workspaceID))
+		if err != nil {
+			return err
+		}
+		kept := gs[:0]
+		for _, g := range gs {
+			if g.UserID != userID {
+				kept = append(kept, g)
+			}
+		}
+		if len(kept) == len(gs) {
+			return ErrNotFound
+		}
+		return r.writeGrants(workspaceID, kept)
+	})
+}
+
+// readGrants treats a missing file as no grants.
+func (r *FileRepo) readGrants(path string) ([]Grant, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var gs []Grant
+	if err := json.Unmarshal(data, &gs); err != nil {
+		return nil, fmt.Errorf("file repository: corrupt grants %s: %w", filepath.Base(path), err)
+	}
+	return gs, nil
+}
+
+func (r *FileRepo) writeGrants(workspaceID string, gs []Grant) error {
+	path := r.grantsPath(workspaceID)
+	if len(gs) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return syncDir(filepath.Dir(path))
+	}
+	data, err := json.Marshal(gs)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Dir(path), path, data)
+}
+
+func (r *FileRepo) readWorkspace(path string) (Workspace, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Workspace{}, ErrNotFound
+	}
+	if err != nil {
+		return Workspace{}, err
+	}
+	var ws Workspace
+	if err := json.Unmarshal(data, &ws); err != nil {
+		return Workspace{}, fmt.Errorf("file repository: corrupt workspace %s: %w", filepath.Base(path), err)
+	}
+	return ws, nil
+}
+
+// Links are stored one per file, named by token hash so that resolving a
+// token is a single read.
+func (r *FileRepo) linkPath(tokenHash string) string {
+	return filepath.Join(r.dir, fileLinksDir, tokenHash+".json")
+}
+
+func (r *FileRepo) CreateShareLink(ctx context.Context, l ShareLink) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	data, err := json.Marshal(storedLink{ShareLink: l, TokenHash: l.TokenHash})
+	if err != nil {
+		return err
+	}
+	path := r.linkPath(l.TokenHash)
+	return r.writeLocked(func() error {
+		if _, err := r.readWorkspace(r.workspacePath(l.WorkspaceID)); err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err == nil {
+			return ErrConflict
+		}
+		return writeFileAtomic(filepath.Dir(path), path, data)
+	})
+}
+
+func (r *FileRepo) GetShareLinkByHash(ctx context.Context, tokenHash string) (ShareLink, error) {
+	if err := ctx.Err(); err != nil {
+		return ShareLink{}, wrapErr(err)
+	}
+	if !isHex(tokenHash) {
+		return ShareLink{}, ErrNotFound
+	}
+	return r.readLink(r.linkPath(tokenHash))
+}
+
+func (r *FileRepo) ListShareLinks(ctx context.Context, workspaceID string) ([]ShareLink, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	out, err := r.readLinks(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	sortLinks(out)
+	return out, nil
+}
+
+func (r *FileRepo) RevokeShareLink(ctx context.Context, workspaceID, id string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	return r.writeLocked(func() error {
+		links, err := r.readLinks(workspaceID)
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			if l.ID != id {
+				continue
+			}
+			if l.RevokedAt != nil {
+				return nil
+			}
+			l.RevokedAt = &at
+			data, err := json.Marshal(storedLink{ShareLink: l, TokenHash: l.TokenHash})
+			if err != nil {
+				return err
+			}
+			path := r.linkPath(l.TokenHash)
+			return writeFileAtomic(filepath.Dir(path), path, data)
+		}
+		return ErrNotFound
+	})
+}
+
+func (r *FileRepo) readLinks(workspaceID string) ([]ShareLink, error) {
+	entries, err := os.ReadDir(filepath.Join(r.dir, fileLinksDir))
+	if err != nil {
+		return nil, err
+	}
+	var out []ShareLink
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		l, err := r.readLink(filepath.Join(r.dir, fileLinksDir, e.Name()))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if l.WorkspaceID == workspaceID {
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+func (r *FileRepo) readLink(path string) (ShareLink, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ShareLink{}, ErrNotFound
+	}
+	if err != nil {
+		return ShareLink{}, err
+	}
+	var sl storedLink
+	if err := json.Unmarshal(data, &sl); err != nil {
+		return ShareLink{}, fmt.Errorf("file repository: corrupt share link %s: %w", filepath.Base(path), err)
+	}
+	return sl.link(), nil
+}
+
+// Keys are stored one per file, named by key ID.
+func (r *FileRepo) keyPath(id string) string {
+	return filepath.Join(r.dir, fileKeysDir, id+".json")
+}
+
+func (r *FileRepo) MarkLegacySession(ctx context.Context, hash string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	if !isHex(hash) {
+		return fmt.Errorf("file repository: invalid session hash %q", hash)
+	}
+	data, err := json.Marshal(map[string]time.Time{"resignedAt": at})
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(r.dir, fileSessionsDir)
+	path := filepath.Join(dir, hash+".json")
+	return r.writeLocked(func() error {
+		if _, err := os.Stat(path); err == nil {
+			return ErrConflict
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return writeFileAtomic(dir, path, data)
+	})
+}
+
+// validKeyID keeps client-supplied key IDs from escaping the keys directory.
+func validKeyID(id string) bool {
+	rest, ok := strings.CutPrefix(id, "key_")
+	return ok && isHex(rest)
+}
+
+func (r *FileRepo) CreateAPIKey(ctx context.Context, k APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	if !validKeyID(k.ID) {
+		return fmt.Errorf("file repository: invalid API key id %q", k.ID)
+	}
+	data, err := json.Marshal(storedKey{APIKey: k, SecretHash: k.SecretHash})
+	if err != nil {
+		return err
+	}
+	path := r.keyPath(k.ID)
+	return r.writeLocked(func() error {
+		if _, err := r.readWorkspace(r.workspacePath(k.WorkspaceID)); err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err == nil {
+			return ErrConflict
+		}
+		return writeFileAtomic(filepath.Dir(path), path, data)
+	})
+}
+
+func (r *FileRepo) GetAPIKey(ctx context.Context, id string) (APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return APIKey{}, wrapErr(err)
+	}
+	if !validKeyID(id) {
+		return APIKey{}, ErrNotFound
+	}
+	return r.readKey(r.keyPath(id))
+}
+
+func (r *FileRepo) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	out, err := r.readKeys(func(k APIKey) bool { return k.UserID == userID })
+	if err != nil {
+		return nil, err
+	}
+	sortKeys(out)
+	return out, nil
+}
+
+func (r *FileRepo) RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) error {
+	return r.updateKey(ctx, id, func(k *APIKey) error {
+		if k.UserID != userID {
+			return ErrNotFound
+		}
+		if k.RevokedAt == nil {
+			k.RevokedAt = &at
+		}
+		return nil
+	})
+}
+
+func (r *FileRepo) DeleteAPIKeys(ctx context.Context, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	return r.writeLocked(func() error {
+		keys, err := r.readKeys(func(k APIKey) bool { return k.UserID == userID })
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := os.Remove(r.keyPath(k.ID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		return syncDir(filepath.Join(r.dir, fileKeysDir))
+	})
+}
+
+func (r *FileRepo) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	err := r.updateKey(ctx, id, func(k *APIKey) error {
+		k.LastUsedAt = &at
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (r *FileRepo) updateKey(ctx context.Context, id string, fn func(*APIKey) error) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	if !validKeyID(id) {
+		return ErrNotFound
+	}
+	path := r.keyPath(id)
+	return r.writeLocked(func() error {
+		k, err := r.readKey(path)
+		if err != nil {
+			return err
+		}
+		if err := fn(&k); err != nil {
+			return err
+		}
+		data, err := json.Marshal(storedKey{APIKey: k, SecretHash: k.SecretHash})
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(filepath.Dir(path), path, data)
+	})
+}
+
+func (r *FileRepo) readKeys(match func(APIKey) bool) ([]APIKey, error) {
+	entries, err := os.ReadDir(filepath.Join(r.dir, fileKeysDir))
+	if err != nil {
+		return nil, err
+	}
+	var out []APIKey
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		k, err := r.readKey(filepath.Join(r.dir, fileKeysDir, e.Name()))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if match(k) {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+func (r *FileRepo) readKey(path string) (APIKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return APIKey{}, ErrNotFound
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+	var sk storedKey
+	if err := json.Unmarshal(data, &sk); err != nil {
+		return APIKey{}, fmt.Errorf("file repository: corrupt API key %s: %w", filepath.Base(path), err)
+	}
+	return sk.key(), nil
+}
+
+// The audit log of a workspace is one JSON line per entry, appended in
+// place. IDs come from a sequence file shared by all workspaces.
+func (r *FileRepo) auditPath(workspaceID string) string {
+	return filepath.Join(r.dir, fileAuditDir, base64.RawURLEncoding.EncodeToString([]byte(workspaceID))+".jsonl")
+}
+
+func (r *FileRepo) AppendAudit(ctx context.Context, e AuditEntry) (AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return AuditEntry{}, wrapErr(err)
+	}
+	err := r.writeLocked(func() error {
+		var err error
+		e, err = r.appendAudit(e)
+		return err
+	})
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	return e, nil
+}
+
+// appendAudit stores e and returns it with ID set; the caller holds the
+// write lock.
+func (r *FileRepo) appendAudit(e AuditEntry) (AuditEntry, error) {
+	dir := filepath.Join(r.dir, fileAuditDir)
+	seqPath := filepath.Join(dir, fileAuditSeq)
+	var seq int64
+	if data, err := os.ReadFile(seqPath); err == nil {
+		if _, err := fmt.Sscan(string(data), &seq); err != nil {
+			return AuditEntry{}, fmt.Errorf("file repository: corrupt audit sequence: %w", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return AuditEntry{}, err
+	}
+	e.ID = seq + 1
+	if err := writeFileAtomic(dir, seqPath, []byte(strconv.FormatInt(e.ID, 10))); err != nil {
+		return AuditEntry{}, err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	path := r.auditPath(e.WorkspaceID)
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return AuditEntry{}, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return AuditEntry{}, err
+	}
+	if err := f.Close(); err != nil {
+		return AuditEntry{}, err
+	}
+	if errors.Is(statErr, fs.ErrNotExist) {
+		if err := syncDir(dir); err != nil {
+			return AuditEntry{}, err
+		}
+	}
+	return e, nil
+}
+
+func (r *FileRepo) ListAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	var es []AuditEntry
+	if q.WorkspaceID != "" {
+		var err error
+		if es, err = readAudit(r.auditPath(q.WorkspaceID)); err != nil {
+			return nil, err
+		}
+	} else {
+		err := r.eachAuditFile(func(path string) error {
+			got, err := readAudit(path)
+			es = append(es, got...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sortAudit(es)
+	return limitAudit(es, q), nil
+}
+
+func (r *FileRepo) DeleteAudit(ctx context.Context, workspaceID string) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	return r.writeLocked(func() error {
+		if err := os.Remove(r.auditPath(workspaceID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	})
+}
+
+func (r *FileRepo) RedactAuditActor(ctx context.Context, actor string) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	return r.writeLocked(func() error {
+		return r.eachAuditFile(func(path string) error {
+			es, err := readAudit(path)
+			if err != nil {
+				return err
+			}
+			changed := false
+			var buf []byte
+			for _, e := range es {
+				if e.Actor == actor {
+					e.Actor, e.Via, changed = "", "", true
+				}
+				line, err := json.Marshal(e)
+				if err != nil {
+					return err
+				}
+				buf = append(append(buf, line...), '\n')
+			}
+			if !changed {
+				return nil
+			}
+			return writeFileAtomic(filepath.Dir(path), path, buf)
+		})
+	})
+}
+
+func (r *FileRepo) eachAuditFile(fn func(path string) error) error {
+	entries, err := os.ReadDir(filepath.Join(r.dir, fileAuditDir))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".jsonl" {
+			continue
+		}
+		if err := fn(filepath.Join(r.dir, fileAuditDir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readAudit treats a missing file as no entries. A last line without its
+// newline is an append still in progress, or one cut short by a crash, and
+// is skipped.
+func readAudit(path string) ([]AuditEntry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []AuditEntry
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(data[:i], &e); err != nil {
+			return nil, fmt.Errorf("file repository: corrupt audit log %s: %w", filepath.Base(path), err)
+		}
+		out = append(out, e)
+		data = data[i+1:]
+	}
+	return out, nil
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *FileRepo) read(userID string) (fileRecord, error) {
+	data, err := os.ReadFile(r.path(userID))
+	if err != nil {
+		return fileRecord{}, err
+	}
+	var rec fileRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return fileRecord{}, fmt.Errorf("file repository: corrupt state for %q: %w", userID, err)
+	}
+	return rec, nil
+}
+
+func writeFileAtomic(dir, path string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	cleanup := func() { _ = os.Remove(tmpName) }
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		cleanup()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		cleanup()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		cleanup()
+		return err
+	}
+	return syncDir(dir)
+}
+
+var _ Repository = (*FileRepo)(nil)
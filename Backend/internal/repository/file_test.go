@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+)
+
+func TestFileRepo_PersistsAcrossReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	uid := "../weird/ user:ид"
+
+	r, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := State{Class1: models.Class{Name: "Cat", Properties: []string{"purr"}}}
+	if err := r.UpsertState(ctx, uid, st); err != nil {
+		t.Fatal(err)
+	}
+	_ = r.Close()
+
+	r2, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+	got, err := r2.GetState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Class1.Name != "Cat" || len(got.Class1.Properties) != 1 {
+		t.Fatalf("state after reopen=%+v", got)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".tmp-") {
+			t.Fatalf("temporary file left behind: %s", e.Name())
+		}
+		if strings.ContainsAny(strings.TrimSuffix(e.Name(), ".json"), "/. :") && e.Name() != fileLockName {
+			t.Fatalf("user id leaked into file name: %q", e.Name())
+		}
+	}
+}
+
+func TestFileDirFromDSN(t *testing.T) {
+	cases := map[string]string{
+		"file:///var/lib/slc": "/var/lib/slc",
+		"file://data/slc":     "data/slc",
+		"file:./data":         "./data",
+	}
+	for dsn, want := range cases {
+		got, err := FileDirFromDSN(dsn)
+		if err != nil || got != want {
+			t.Fatalf("FileDirFromDSN(%q)=%q,%v; want %q", dsn, got, err, want)
+		}
+	}
+	if _, err := FileDirFromDSN("mysql://x"); err == nil {
+		t.Fatalf("non-file DSN must fail")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package repository
+
+import "os"
+
+// On platforms without flock the in-process mutex is the only guard, so the
+// directory must not be shared between processes. Directory entries cannot be
+// fsynced there either.
+func lockFile(f *os.File) error { return nil }
+
+func unlockFile(f *os.File) {}
+
+func syncDir(dir string) error { return nil }
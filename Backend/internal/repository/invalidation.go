@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ChangeLog is implemented by repositories that record which users' states
+// changed and when, so that caches on other replicas can be invalidated.
+// ChangedSince returns the users changed at or after since together with the
+// store's current time, which should be used as the next cursor.
+type ChangeLog interface {
+	ChangedSince(ctx context.Context, since time.Time) (userIDs []string, now time.Time, err error)
+}
+
+// Invalidator polls a ChangeLog and drops changed users from a CachedRepo.
+// Cached states converge within roughly one interval of a write committed by
+// any replica; if polling fails the whole cache is purged instead.
+type Invalidator struct {
+	cache    *CachedRepo
+	log      ChangeLog
+	interval time.Duration
+	overlap  time.Duration
+	since    time.Time
+}
+
+func NewInvalidator(cache *CachedRepo, cl ChangeLog, interval time.Duration) *Invalidator {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &Invalidator{cache: cache, log: cl, interval: interval, overlap: interval}
+}
+
+func (inv *Invalidator) Run(ctx context.Context) {
+	t := time.NewTicker(inv.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			inv.Poll(ctx)
+		}
+	}
+}
+
+func (inv *Invalidator) Poll(ctx context.Context) {
+	if inv.since.IsZero() {
+		_, now, err := inv.log.ChangedSince(ctx, time.Now())
+		if err != nil {
+			log.Printf("cache invalidation: %v", err)
+			inv.cache.Purge()
+			return
+		}
+		inv.since = now
+		inv.cache.Purge()
+		return
+	}
+
+	users, now, err := inv.log.ChangedSince(ctx, inv.since.Add(-inv.overlap))
+	if err != nil {
+		log.Printf("cache invalidation: %v", err)
+		inv.cache.Purge()
+		return
+	}
+	for _, uid := range users {
+		inv.cache.Invalidate(uid)
+	}
+	inv.since = now
+}
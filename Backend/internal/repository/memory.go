@@ -0,0 +1,722 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryRepo keeps all states in process memory. It is safe for concurrent
+// use. When created with a snapshot path it loads that file on start and
+// writes it back atomically on Close, which is enough for demos and
+// ephemeral environments that should survive a restart.
+type MemoryRepo struct {
+	mu         sync.RWMutex
+	states     map[string]memoryEntry
+	changed    map[string]time.Time
+	horizon    time.Time
+	workspaces map[string]Workspace
+	grants     map[string]map[string]Grant
+	links      map[string]ShareLink
+	keys       map[string]APIKey
+	legacy     map[string]time.Time
+	audit      []AuditEntry
+	auditSeq   int64
+	snapshot   string
+}
+
+type memoryEntry struct {
+	State     State     `json:"state"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Version   int64     `json:"version,omitempty"`
+}
+
+// memorySnapshot is the on-disk format. Snapshots written before workspaces
+// existed are a bare map of states and carry no version.
+type memorySnapshot struct {
+	Version    int                    `json:"version"`
+	States     map[string]memoryEntry `json:"states"`
+	Workspaces map[string]Workspace   `json:"workspaces"`
+	Grants     []Grant                `json:"grants,omitempty"`
+	Links      []storedLink           `json:"links,omitempty"`
+	Keys       []storedKey            `json:"apiKeys,omitempty"`
+	Audit      []AuditEntry           `json:"audit,omitempty"`
+	Legacy     map[string]time.Time   `json:"legacySessions,omitempty"`
+}
+
+func NewMemory() *MemoryRepo {
+	return &MemoryRepo{
+		states:     make(map[string]memoryEntry),
+		changed:    make(map[string]time.Time),
+		workspaces: make(map[string]Workspace),
+		grants:     make(map[string]map[string]Grant),
+		links:      make(map[string]ShareLink),
+		keys:       make(map[string]APIKey),
+		legacy:     make(map[string]time.Time),
+	}
+}
+
+// NewMemoryWithSnapshot restores states from path if it exists and saves them
+// there on Close.
+func NewMemoryWithSnapshot(path string) (*MemoryRepo, error) {
+	r := NewMemory()
+	r.snapshot = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap memorySnapshot
+	if err := json.Unmarshal(data, &snap); err == nil && snap.Version > 0 {
+		if snap.States != nil {
+			r.states = snap.States
+		}
+		if snap.Workspaces != nil {
+			r.workspaces = snap.Workspaces
+		}
+		for _, g := range snap.Grants {
+			r.putGrant(g)
+		}
+		for _, l := range snap.Links {
+			r.links[l.ID] = l.link()
+		}
+		for _, k := range snap.Keys {
+			r.keys[k.ID] = k.key()
+		}
+		if snap.Legacy != nil {
+			r.legacy = snap.Legacy
+		}
+		r.audit = snap.Audit
+		for _, e := range r.audit {
+			r.auditSeq = max(r.auditSeq, e.ID)
+		}
+		return r, nil
+	}
+	if err := json.Unmarshal(data, &r.states); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *MemoryRepo) GetState(ctx context.Context, userID string) (State, error) {
+	if err := ctx.Err(); err != nil {
+		return State{}, wrapErr(err)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return cloneState(r.states[userID].State), nil
+}
+
+func (r *MemoryRepo) UpsertState(ctx context.Context, userID string, st State) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeState(userID, st)
+	return nil
+}
+
+func (r *MemoryRepo) writeState(id string, st State) {
+	now := time.Now()
+	r.states[id] = memoryEntry{State: cloneState(st), UpdatedAt: now, Version: r.version(id) + 1}
+	r.markChanged(id, now)
+}
+
+// version counts the writes of a stored state. Snapshots written before
+// versions existed load as 0, which is bumped to 1 for an existing state.
+func (r *MemoryRepo) version(id string) int64 {
+	e, ok := r.states[id]
+	if !ok {
+		return 0
+	}
+	return max(e.Version, 1)
+}
+
+func (r *MemoryRepo) LoadState(ctx context.Context, id string) (State, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return State{}, 0, wrapErr(err)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return cloneState(r.states[id].State), r.version(id), nil
+}
+
+func (r *MemoryRepo) CommitState(ctx context.Context, c StateCommit) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c.Create != nil {
+		if _, ok := r.workspaces[c.ID]; ok {
+			return ErrConflict
+		}
+		ws := *c.Create
+		ws.Lineage = slices.Clone(ws.Lineage)
+		r.workspaces[ws.ID] = ws
+	} else if r.version(c.ID) != c.Version {
+		return ErrStale
+	}
+	if c.Reset || c.MoveTo != "" {
+		delete(r.states, c.ID)
+		r.markChanged(c.ID, time.Now())
+	}
+	switch {
+	case c.MoveTo != "":
+		r.writeState(c.MoveTo, c.State)
+	case !c.Reset:
+		r.writeState(c.ID, c.State)
+	}
+	if c.Audit != nil {
+		r.appendAudit(*c.Audit)
+	}
+	return nil
+}
+
+func (r *MemoryRepo) ResetUser(ctx context.Context, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.states, userID)
+	r.markChanged(userID, time.Now())
+	return nil
+}
+
+// changeRetention is how long the memory backend remembers which states
+// changed. It only has to outlast an invalidator's interval and overlap.
+const changeRetention = 10 * time.Minute
+
+// markChanged records a change for ChangedSince. Every half retention it
+// forgets changes older than the retention, so the log stays bounded by the
+// states written recently. The caller holds the write lock.
+func (r *MemoryRepo) markChanged(id string, at time.Time) {
+	r.changed[id] = at
+	horizon := at.Add(-changeRetention)
+	if horizon.Sub(r.horizon) < changeRetention/2 {
+		return
+	}
+	for uid, t := range r.changed {
+		if t.Before(horizon) {
+			delete(r.changed, uid)
+		}
+	}
+	r.horizon = horizon
+}
+
+// ChangedSince fails for a since older than the changes it still keeps,
+// which makes an invalidator fall back to purging its cache.
+func (r *MemoryRepo) ChangedSince(ctx context.Context, since time.Time) ([]string, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, time.Time{}, wrapErr(err)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if since.Before(r.horizon) {
+		return nil, time.Time{}, fmt.Errorf("memory repository: changes before %s are no longer kept", r.horizon.Format(time.RFC3339))
+	}
+	var out []string
+	for uid, at := range r.changed {
+		if !at.Before(since) {
+			out = append(out, uid)
+		}
+	}
+	return out, time.Now(), nil
+}
+
+func (r *MemoryRepo) CreateWorkspace(ctx context.Context, ws Workspace) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.workspaces[ws.ID]; ok {
+		return ErrConflict
+	}
+	ws.Lineage = slices.Clone(ws.Lineage)
+	r.workspaces[ws.ID] = ws
+	return nil
+}
+
+func (r *MemoryRepo) GetWorkspace(ctx context.Context, id string) (Workspace, error) {
+	if err := ctx.Err(); err != nil {
+		return Workspace{}, wrapErr(err)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ws, ok := r.workspaces[id]
+	if !ok {
+		return Workspace{}, ErrNotFound
+	}
+	return ws, nil
+}
+
+func (r *MemoryRepo) ListWorkspaces(ctx context.Context, ownerID string) ([]Workspace, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	r.mu.RLock()
+	var out []Workspace
+	for _, ws := range r.workspaces {
+		if ws.OwnerID == ownerID {
+			out = append(out, ws)
+		}
+	}
+	r.mu.RUnlock()
+	sortWorkspaces(out)
+	return out, nil
+}
+
+func (r *MemoryRepo) RenameWorkspace(ctx context.Context, id, name string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ws, ok := r.workspaces[id]
+	if !ok {
+		return ErrNotFound
+	}
+	ws.Name, ws.UpdatedAt = name, at
+	r.workspaces[id] = ws
+	return nil
+}
+
+func (r *MemoryRepo) DeleteWorkspace(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.workspaces[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.workspaces, id)
+	delete(r.grants, id)
+	for lid, l := range r.links {
+		if l.WorkspaceID == id {
+			delete(r.links, lid)
+		}
+	}
+	for kid, k := range r.keys {
+		if k.WorkspaceID == id {
+			delete(r.keys, kid)
+		}
+	}
+	delete(r.states, id)
+	r.markChanged(id, time.Now())
+	return nil
+}
+
+func (r *MemoryRepo) ScanWorkspaces(ctx context.Context, after string, limit int) ([]WorkspaceActivity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var ids []string
+	for id := range r.workspaces {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	out := make([]WorkspaceActivity, 0, len(ids))
+	for _, id := range ids {
+		ws := r.workspaces[id]
+		ws.Lineage = slices.Clone(ws.Lineage)
+		a := WorkspaceActivity{Workspace: ws, LastWrite: ws.UpdatedAt}
+		if e, ok := r.states[id]; ok && e.UpdatedAt.After(a.LastWrite) {
+			a.LastWrite = e.UpdatedAt.UTC()
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+func (r *MemoryRepo) ScanLegacyStates(ctx context.Context, after string, limit int) ([]LegacyState, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var ids []string
+	for id := range r.states {
+		if _, ok := r.workspaces[id]; !ok && id > after {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	out := make([]LegacyState, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, LegacyState{UserID: id, LastWrite: r.states[id].UpdatedAt.UTC()})
+	}
+	return out, nil
+}
+
+func (r *MemoryRepo) TouchWorkspace(ctx context.Context, id string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ws, ok := r.workspaces[id]; ok {
+		ws.AccessedAt = &at
+		r.workspaces[id] = ws
+	}
+	return nil
+}
+
+func (r *MemoryRepo) PutGrant(ctx context.Context, g Grant) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.workspaces[g.WorkspaceID]; !ok {
+		return ErrNotFound
+	}
+	if old, ok := r.grants[g.WorkspaceID][g.UserID]; ok {
+		g.CreatedAt = old.CreatedAt
+	}
+	r.putGrant(g)
+	return nil
+}
+
+func (r *MemoryRepo) putGrant(g Grant) {
+	if r.grants[g.WorkspaceID] == nil {
+		r.grants[g.WorkspaceID] = make(map[string]Grant)
+	}
+	r.grants[g.WorkspaceID][g.UserID] = g
+}
+
+func (r *MemoryRepo) GetGrant(ctx context.Context, workspaceID, userID string) (Grant, error) {
+	if err := ctx.Err(); err != nil {
+		return Grant{}, wrapErr(err)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	g, ok := r.grants[workspaceID][userID]
+	if !ok {
+		return Grant{}, ErrNotFound
+	}
+	return g, nil
+}
+
+func (r *MemoryRepo) ListGrants(ctx context.Context, workspaceID string) ([]Grant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	r.mu.RLock()
+	var out []Grant
+	for _, g := range r.grants[workspaceID] {
+		out = append(out, g)
+	}
+	r.mu.RUnlock()
+	sortGrants(out)
+	return out, nil
+}
+
+func (r *MemoryRepo) ListGrantsFor(ctx context.Context, userID string) ([]Grant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	r.mu.RLock()
+	var out []Grant
+	for _, byUser := range r.grants {
+		if g, ok := byUser[userID]; ok {
+			out = append(out, g)
+		}
+	}
+	r.mu.RUnlock()
+	sortGrants(out)
+	return out, nil
+}
+
+func (r *MemoryRepo) DeleteGrant(ctx context.Context, workspaceID, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.grants[workspaceID][userID]; !ok {
+		return ErrNotFound
+	}
+	delete(r.grants[workspaceID], userID)
+	return nil
+}
+
+func (r *MemoryRepo) CreateShareLink(ctx context.Context, l ShareLink) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.workspaces[l.WorkspaceID]; !ok {
+		return ErrNotFound
+	}
+	if _, ok := r.links[l.ID]; ok {
+		return ErrConflict
+	}
+	r.links[l.ID] = l
+	return nil
+}
+
+func (r *MemoryRepo) GetShareLinkByHash(ctx context.Context, tokenHash string) (ShareLink, error) {
+	if err := ctx.Err(); err != nil {
+		return ShareLink{}, wrapErr(err)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, l := range r.links {
+		if l.TokenHash == tokenHash {
+			return l, nil
+		}
+	}
+	return ShareLink{}, ErrNotFound
+}
+
+func (r *MemoryRepo) ListShareLinks(ctx context.Context, workspaceID string) ([]ShareLink, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	r.mu.RLock()
+	var out []ShareLink
+	for _, l := range r.links {
+		if l.WorkspaceID == workspaceID {
+			out = append(out, l)
+		}
+	}
+	r.mu.RUnlock()
+	sortLinks(out)
+	return out, nil
+}
+
+func (r *MemoryRepo) RevokeShareLink(ctx context.Context, workspaceID, id string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.links[id]
+	if !ok || l.WorkspaceID != workspaceID {
+		return ErrNotFound
+	}
+	if l.RevokedAt == nil {
+		l.RevokedAt = &at
+		r.links[id] = l
+	}
+	return nil
+}
+
+func (r *MemoryRepo) CreateAPIKey(ctx context.Context, k APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.workspaces[k.WorkspaceID]; !ok {
+		return ErrNotFound
+	}
+	if _, ok := r.keys[k.ID]; ok {
+		return ErrConflict
+	}
+	r.keys[k.ID] = k
+	return nil
+}
+
+func (r *MemoryRepo) GetAPIKey(ctx context.Context, id string) (APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return APIKey{}, wrapErr(err)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	k, ok := r.keys[id]
+	if !ok {
+		return APIKey{}, ErrNotFound
+	}
+	return k, nil
+}
+
+func (r *MemoryRepo) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	r.mu.RLock()
+	var out []APIKey
+	for _, k := range r.keys {
+		if k.UserID == userID {
+			out = append(out, k)
+		}
+	}
+	r.mu.RUnlock()
+	sortKeys(out)
+	return out, nil
+}
+
+func (r *MemoryRepo) RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k, ok := r.keys[id]
+	if !ok || k.UserID != userID {
+		return ErrNotFound
+	}
+	if k.RevokedAt == nil {
+		k.RevokedAt = &at
+		r.keys[id] = k
+	}
+	return nil
+}
+
+func (r *MemoryRepo) DeleteAPIKeys(ctx context.Context, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, k := range r.keys {
+		if k.UserID == userID {
+			delete(r.keys, id)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepo) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if k, ok := r.keys[id]; ok {
+		k.LastUsedAt = &at
+		r.keys[id] = k
+	}
+	return nil
+}
+
+func (r *MemoryRepo) MarkLegacySession(ctx context.Context, hash string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.legacy[hash]; ok {
+		return ErrConflict
+	}
+	r.legacy[hash] = at
+	return nil
+}
+
+// AppendAudit keeps entries in ID order, oldest first.
+func (r *MemoryRepo) AppendAudit(ctx context.Context, e AuditEntry) (AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return AuditEntry{}, wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.appendAudit(e), nil
+}
+
+// appendAudit stores e; the caller holds the write lock.
+func (r *MemoryRepo) appendAudit(e AuditEntry) AuditEntry {
+	r.auditSeq++
+	e.ID = r.auditSeq
+	e.Payload, e.Diff, e.Properties = slices.Clone(e.Payload), slices.Clone(e.Diff), slices.Clone(e.Properties)
+	r.audit = append(r.audit, e)
+	return e
+}
+
+func (r *MemoryRepo) ListAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	r.mu.RLock()
+	es := slices.Clone(r.audit)
+	r.mu.RUnlock()
+	slices.Reverse(es)
+	return limitAudit(es, q), nil
+}
+
+func (r *MemoryRepo) DeleteAudit(ctx context.Context, workspaceID string) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.audit = slices.DeleteFunc(r.audit, func(e AuditEntry) bool { return e.WorkspaceID == workspaceID })
+	return nil
+}
+
+func (r *MemoryRepo) RedactAuditActor(ctx context.Context, actor string) error {
+	if err := ctx.Err(); err != nil {
+		return wrapErr(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.audit {
+		if r.audit[i].Actor == actor {
+			r.audit[i].Actor, r.audit[i].Via = "", ""
+		}
+	}
+	return nil
+}
+
+// Save writes the snapshot file, if one is configured.
+func (r *MemoryRepo) Save() error {
+	if r.snapshot == "" {
+		return nil
+	}
+	r.mu.RLock()
+	snap := memorySnapshot{Version: 1, States: r.states, Workspaces: r.workspaces, Audit: r.audit, Legacy: r.legacy}
+	for _, byUser := range r.grants {
+		for _, g := range byUser {
+			snap.Grants = append(snap.Grants, g)
+		}
+	}
+	for _, l := range r.links {
+		snap.Links = append(snap.Links, storedLink{ShareLink: l, TokenHash: l.TokenHash})
+	}
+	for _, k := range r.keys {
+		snap.Keys = append(snap.Keys, storedKey{APIKey: k, SecretHash: k.SecretHash})
+	}
+	data, err := json.Marshal(snap)
+	r.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(r.snapshot)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	return writeFileAtomic(dir, r.snapshot, data)
+}
+
+func (r *MemoryRepo) Close() error {
+	return r.Save()
+}
+
+var (
+	_ Repository = (*MemoryRepo)(nil)
+	_ ChangeLog  = (*MemoryRepo)(nil)
+)
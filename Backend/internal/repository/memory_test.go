@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMemoryRepo_ChangeLogIsPruned(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemory()
+	start := time.Now()
+
+	r.mu.Lock()
+	for i := range 100 {
+		r.markChanged(string(rune('a'+i%26))+"-old", start.Add(time.Duration(i)*time.Millisecond))
+	}
+	r.markChanged("recent", start.Add(changeRetention))
+	r.markChanged("latest", start.Add(changeRetention+changeRetention/2+time.Second))
+	r.mu.Unlock()
+	if n := len(r.changed); n != 2 {
+		t.Fatalf("change log=%v; want only the recent entries", r.changed)
+	}
+
+	users, _, err := r.ChangedSince(ctx, start.Add(changeRetention))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(users)
+	if !slices.Equal(users, []string{"latest", "recent"}) {
+		t.Fatalf("changed=%v", users)
+	}
+	if _, _, err := r.ChangedSince(ctx, start); err == nil {
+		t.Fatalf("asking for pruned changes must fail so the cache is purged")
+	}
+}
@@ -0,0 +1,491 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	AreaClass1  = "class1"
+	AreaClass2  = "class2"
+	AreaGeneral = "general"
+	AreaNone    = "none"
+)
+
+var Areas = []string{AreaClass1, AreaClass2, AreaGeneral, AreaNone}
+
+// NormalizedRepo stores each user's knowledge base in the classifiers,
+// classes and properties tables instead of JSON columns. Upserts only touch
+// the property rows that actually changed.
+type NormalizedRepo struct {
+	DB       *sql.DB
+	Timeouts Timeouts
+}
+
+func NewNormalized(db *sql.DB) *NormalizedRepo {
+	return &NormalizedRepo{DB: db, Timeouts: DefaultTimeouts}
+}
+
+func (r *NormalizedRepo) GetState(ctx context.Context, userID string) (State, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Read)
+	defer cancel()
+
+	var id int64
+	err := r.DB.QueryRowContext(ctx, `SELECT id FROM classifiers WHERE user_id = ?`, userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, wrapErr(err)
+	}
+
+	var st State
+	rows, err := r.DB.QueryContext(ctx, `SELECT slot, name FROM classes WHERE classifier_id = ?`, id)
+	if err != nil {
+		return State{}, wrapErr(err)
+	}
+	for rows.Next() {
+		var (
+			slot int
+			name string
+		)
+		if err := rows.Scan(&slot, &name); err != nil {
+			rows.Close()
+			return State{}, wrapErr(err)
+		}
+		switch slot {
+		case 1:
+			st.Class1.Name = name
+		case 2:
+			st.Class2.Name = name
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return State{}, wrapErr(err)
+	}
+
+	rows, err = r.DB.QueryContext(ctx,
+		`SELECT area, name FROM properties WHERE classifier_id = ? ORDER BY area, position`, id)
+	if err != nil {
+		return State{}, wrapErr(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var area, name string
+		if err := rows.Scan(&area, &name); err != nil {
+			return State{}, wrapErr(err)
+		}
+		if p := areaSlice(&st, area); p != nil {
+			*p = append(*p, name)
+		}
+	}
+	return st, wrapErr(rows.Err())
+}
+
+type propKey struct {
+	area, name string
+}
+
+type propRow struct {
+	propKey
+	pos int
+}
+
+const propBatch = 500
+
+func insertProperties(ctx context.Context, tx *sql.Tx, id int64, rows []propRow) error {
+	for len(rows) > 0 {
+		n := min(len(rows), propBatch)
+		var b strings.Builder
+		b.WriteString(`INSERT INTO properties (classifier_id, area, name, position) VALUES `)
+		args := make([]any, 0, n*4)
+		for i, r := range rows[:n] {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString("(?, ?, ?, ?)")
+			args = append(args, id, r.area, r.name, r.pos)
+		}
+		if _, err := tx.ExecContext(ctx, b.String(), args...); err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+func deleteProperties(ctx context.Context, tx *sql.Tx, id int64, keys []propKey) error {
+	for len(keys) > 0 {
+		n := min(len(keys), propBatch)
+		var b strings.Builder
+		b.WriteString(`DELETE FROM properties WHERE classifier_id = ? AND (area, name) IN (`)
+		args := make([]any, 0, 1+n*2)
+		args = append(args, id)
+		for i, k := range keys[:n] {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString("(?, ?)")
+			args = append(args, k.area, k.name)
+		}
+		b.WriteString(")")
+		if _, err := tx.ExecContext(ctx, b.String(), args...); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+func (r *NormalizedRepo) UpsertState(ctx context.Context, userID string, st State) error {
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Write)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer tx.Rollback()
+
+	if err := r.writeState(ctx, tx, userID, st); err != nil {
+		return wrapErr(err)
+	}
+	return wrapErr(tx.Commit())
+}
+
+func (r *NormalizedRepo) writeState(ctx context.Context, tx *sql.Tx, userID string, st State) error {
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO classifiers (user_id) VALUES (?)
+ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), updated_at = CURRENT_TIMESTAMP`, userID)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO classes (classifier_id, slot, name) VALUES (?, 1, ?), (?, 2, ?)
+ON DUPLICATE KEY UPDATE name = VALUES(name)`,
+		id, st.Class1.Name, id, st.Class2.Name); err != nil {
+		return err
+	}
+
+	existing := make(map[propKey]int)
+	rows, err := tx.QueryContext(ctx,
+		`SELECT area, name, position FROM properties WHERE classifier_id = ? FOR UPDATE`, id)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var (
+			k   propKey
+			pos int
+		)
+		if err := rows.Scan(&k.area, &k.name, &pos); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[k] = pos
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	desired := desiredProperties(st)
+	var (
+		removed []propKey
+		added   []propRow
+	)
+	for k := range existing {
+		if _, keep := desired[k]; !keep {
+			removed = append(removed, k)
+		}
+	}
+	for k, pos := range desired {
+		old, ok := existing[k]
+		switch {
+		case !ok:
+			added = append(added, propRow{propKey: k, pos: pos})
+		case old != pos:
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE properties SET position = ? WHERE classifier_id = ? AND area = ? AND name = ?`,
+				pos, id, k.area, k.name); err != nil {
+				return err
+			}
+		}
+	}
+	if err := deleteProperties(ctx, tx, id, removed); err != nil {
+		return err
+	}
+	if err := insertProperties(ctx, tx, id, added); err != nil {
+		return err
+	}
+
+	return recordChange(ctx, tx, userID)
+}
+
+func (r *NormalizedRepo) ResetUser(ctx context.Context, userID string) error {
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Write)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer tx.Rollback()
+
+	if err := r.deleteState(ctx, tx, userID); err != nil {
+		return wrapErr(err)
+	}
+	return wrapErr(tx.Commit())
+}
+
+func (r *NormalizedRepo) deleteState(ctx context.Context, tx *sql.Tx, userID string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM classifiers WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return recordChange(ctx, tx, userID)
+}
+
+func (r *NormalizedRepo) workspaces() sqlWorkspaces {
+	return sqlWorkspaces{
+		db: r.DB, timeouts: r.Timeouts, stateTable: "classifiers",
+		getState: r.GetState, writeState: r.writeState, deleteState: r.deleteState,
+	}
+}
+
+func (r *NormalizedRepo) LoadState(ctx context.Context, id string) (State, int64, error) {
+	return r.workspaces().loadState(ctx, id)
+}
+
+func (r *NormalizedRepo) CommitState(ctx context.Context, c StateCommit) error {
+	return r.workspaces().commitState(ctx, c)
+}
+
+func (r *NormalizedRepo) CreateWorkspace(ctx context.Context, ws Workspace) error {
+	return r.workspaces().create(ctx, ws)
+}
+
+func (r *NormalizedRepo) GetWorkspace(ctx context.Context, id string) (Workspace, error) {
+	return r.workspaces().get(ctx, id)
+}
+
+func (r *NormalizedRepo) ListWorkspaces(ctx context.Context, ownerID string) ([]Workspace, error) {
+	return r.workspaces().list(ctx, ownerID)
+}
+
+func (r *NormalizedRepo) RenameWorkspace(ctx context.Context, id, name string, at time.Time) error {
+	return r.workspaces().rename(ctx, id, name, at)
+}
+
+func (r *NormalizedRepo) DeleteWorkspace(ctx context.Context, id string) error {
+	return r.workspaces().delete(ctx, id)
+}
+
+func (r *NormalizedRepo) ScanWorkspaces(ctx context.Context, after string, limit int) ([]WorkspaceActivity, error) {
+	return r.workspaces().scan(ctx, after, limit)
+}
+
+func (r *NormalizedRepo) ScanLegacyStates(ctx context.Context, after string, limit int) ([]LegacyState, error) {
+	return r.workspaces().scanLegacy(ctx, after, limit)
+}
+
+func (r *NormalizedRepo) TouchWorkspace(ctx context.Context, id string, at time.Time) error {
+	return r.workspaces().touch(ctx, id, at)
+}
+
+func (r *NormalizedRepo) PutGrant(ctx context.Context, g Grant) error {
+	return r.workspaces().putGrant(ctx, g)
+}
+
+func (r *NormalizedRepo) GetGrant(ctx context.Context, workspaceID, userID string) (Grant, error) {
+	return r.workspaces().getGrant(ctx, workspaceID, userID)
+}
+
+func (r *NormalizedRepo) ListGrants(ctx context.Context, workspaceID string) ([]Grant, error) {
+	return r.workspaces().listGrants(ctx, "workspace_id", workspaceID)
+}
+
+func (r *NormalizedRepo) ListGrantsFor(ctx context.Context, userID string) ([]Grant, error) {
+	return r.workspaces().listGrants(ctx, "user_id", userID)
+}
+
+func (r *NormalizedRepo) DeleteGrant(ctx context.Context, workspaceID, userID string) error {
+	return r.workspaces().deleteGrant(ctx, workspaceID, userID)
+}
+
+func (r *NormalizedRepo) CreateShareLink(ctx context.Context, l ShareLink) error {
+	return r.workspaces().createLink(ctx, l)
+}
+
+func (r *NormalizedRepo) GetShareLinkByHash(ctx context.Context, tokenHash string) (ShareLink, error) {
+	return r.workspaces().getLinkByHash(ctx, tokenHash)
+}
+
+func (r *NormalizedRepo) ListShareLinks(ctx context.Context, workspaceID string) ([]ShareLink, error) {
+	return r.workspaces().listLinks(ctx, workspaceID)
+}
+
+func (r *NormalizedRepo) RevokeShareLink(ctx context.Context, workspaceID, id string, at time.Time) error {
+	return r.workspaces().revokeLink(ctx, workspaceID, id, at)
+}
+
+func (r *NormalizedRepo) CreateAPIKey(ctx context.Context, k APIKey) error {
+	return r.workspaces().createKey(ctx, k)
+}
+
+func (r *NormalizedRepo) GetAPIKey(ctx context.Context, id string) (APIKey, error) {
+	return r.workspaces().getKey(ctx, id)
+}
+
+func (r *NormalizedRepo) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	return r.workspaces().listKeys(ctx, userID)
+}
+
+func (r *NormalizedRepo) RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) error {
+	return r.workspaces().revokeKey(ctx, userID, id, at)
+}
+
+func (r *NormalizedRepo) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	return r.workspaces().touchKey(ctx, id, at)
+}
+
+func (r *NormalizedRepo) DeleteAPIKeys(ctx context.Context, userID string) error {
+	return r.workspaces().deleteKeys(ctx, userID)
+}
+
+func (r *NormalizedRepo) AppendAudit(ctx context.Context, e AuditEntry) (AuditEntry, error) {
+	return r.workspaces().appendAudit(ctx, e)
+}
+
+func (r *NormalizedRepo) ListAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	return r.workspaces().listAudit(ctx, q)
+}
+
+func (r *NormalizedRepo) RedactAuditActor(ctx context.Context, actor string) error {
+	return r.workspaces().redactAuditActor(ctx, actor)
+}
+
+func (r *NormalizedRepo) DeleteAudit(ctx context.Context, workspaceID string) error {
+	return r.workspaces().deleteAudit(ctx, workspaceID)
+}
+
+func (r *NormalizedRepo) MarkLegacySession(ctx context.Context, hash string, at time.Time) error {
+	return r.workspaces().markLegacySession(ctx, hash, at)
+}
+
+func (r *NormalizedRepo) ChangedSince(ctx context.Context, since time.Time) ([]string, time.Time, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Read)
+	defer cancel()
+	return changedSince(ctx, r.DB, since)
+}
+
+// UsersWithProperty lists the users whose given area contains prop.
+func (r *NormalizedRepo) UsersWithProperty(ctx context.Context, area, prop string) ([]string, error) {
+	if !validArea(area) {
+		return nil, fmt.Errorf("bad area %q", area)
+	}
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Read)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT c.user_id
+FROM properties p
+JOIN classifiers c ON c.id = p.classifier_id
+WHERE p.area = ? AND p.name = ?
+ORDER BY c.user_id`, area, prop)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			return nil, wrapErr(err)
+		}
+		out = append(out, uid)
+	}
+	return out, wrapErr(rows.Err())
+}
+
+// ConvertToNormalized copies every user_state row into the normalized tables.
+// It is idempotent, so an interrupted run can simply be restarted.
+func ConvertToNormalized(ctx context.Context, src *MySQLRepo, dst *NormalizedRepo, batch int) (int, error) {
+	if batch <= 0 {
+		batch = 500
+	}
+	n, after := 0, ""
+	for {
+		ids, err := src.UserIDs(ctx, after, batch)
+		if err != nil {
+			return n, err
+		}
+		for _, uid := range ids {
+			st, err := src.GetState(ctx, uid)
+			if err != nil {
+				return n, fmt.Errorf("read %s: %w", uid, err)
+			}
+			if err := dst.UpsertState(ctx, uid, st); err != nil {
+				return n, fmt.Errorf("write %s: %w", uid, err)
+			}
+			n++
+		}
+		if len(ids) < batch {
+			return n, nil
+		}
+		after = ids[len(ids)-1]
+	}
+}
+
+func desiredProperties(st State) map[propKey]int {
+	out := make(map[propKey]int)
+	add := func(area string, props []string) {
+		pos := 0
+		for _, p := range props {
+			k := propKey{area: area, name: p}
+			if _, dup := out[k]; dup {
+				continue
+			}
+			out[k] = pos
+			pos++
+		}
+	}
+	add(AreaClass1, st.Class1.Properties)
+	add(AreaClass2, st.Class2.Properties)
+	add(AreaGeneral, st.GeneralClass)
+	add(AreaNone, st.NoneClass)
+	return out
+}
+
+func areaSlice(st *State, area string) *[]string {
+	switch area {
+	case AreaClass1:
+		return &st.Class1.Properties
+	case AreaClass2:
+		return &st.Class2.Properties
+	case AreaGeneral:
+		return &st.GeneralClass
+	case AreaNone:
+		return &st.NoneClass
+	}
+	return nil
+}
+
+func validArea(area string) bool {
+	return areaSlice(&State{}, area) != nil
+}
+
+var (
+	_ Repository = (*NormalizedRepo)(nil)
+	_ ChangeLog  = (*NormalizedRepo)(nil)
+)
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+)
+
+func TestDesiredProperties_PositionsPerArea(t *testing.T) {
+	st := State{
+		Class1:       models.Class{Name: "Cat", Properties: []string{"purr", "whiskers", "purr"}},
+		Class2:       models.Class{Name: "Dog", Properties: []string{"bark"}},
+		GeneralClass: []string{"tail"},
+		NoneClass:    []string{"purr"},
+	}
+	got := desiredProperties(st)
+	want := map[propKey]int{
+		{AreaClass1, "purr"}:     0,
+		{AreaClass1, "whiskers"}: 1,
+		{AreaClass2, "bark"}:     0,
+		{AreaGeneral, "tail"}:    0,
+		{AreaNone, "purr"}:       0,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows; want %d: %v", len(got), len(want), got)
+	}
+	for k, pos := range want {
+		if got[k] != pos {
+			t.Fatalf("%v position=%d; want %d", k, got[k], pos)
+		}
+	}
+}
+
+func TestAreaSlice(t *testing.T) {
+	var st State
+	for _, a := range Areas {
+		p := areaSlice(&st, a)
+		if p == nil {
+			t.Fatalf("area %q not mapped", a)
+		}
+		*p = append(*p, a)
+	}
+	if st.Class1.Properties[0] != AreaClass1 || st.NoneClass[0] != AreaNone {
+		t.Fatalf("areas mapped to wrong fields: %+v", st)
+	}
+	if validArea("bogus") {
+		t.Fatalf("bogus area must be invalid")
+	}
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+)
+
+func TestMySQLDriverDSN(t *testing.T) {
+	cases := []struct {
+		in, want, model string
+	}{
+		{
+			in:   "mysql://slc:p%40ss@db:3307/slc",
+			want: "slc:p@ss@tcp(db:3307)/slc?charset=utf8mb4&loc=Local&parseTime=true",
+		},
+		{
+			in:    "mysql://root@/slc?model=normalized&socket=/run/mysqld.sock&tls=true",
+			want:  "root:@unix(/run/mysqld.sock)/slc?charset=utf8mb4&loc=Local&parseTime=true&tls=true",
+			model: "normalized",
+		},
+		{
+			in:   "mysql://u:p@localhost/db?parseTime=false",
+			want: "u:p@tcp(localhost:3306)/db?charset=utf8mb4&loc=Local&parseTime=false",
+		},
+	}
+	for _, c := range cases {
+		got, model, err := MySQLDriverDSN(c.in)
+		if err != nil {
+			t.Fatalf("%s: %v", c.in, err)
+		}
+		if got != c.want || model != c.model {
+			t.Fatalf("%s:\n got %q model=%q\nwant %q model=%q", c.in, got, model, c.want, c.model)
+		}
+	}
+	if _, _, err := MySQLDriverDSN("mysql://u:p@host/"); err == nil {
+		t.Fatalf("missing database name must fail")
+	}
+}
+
+func TestOpen_Schemes(t *testing.T) {
+	if r, err := Open("memory://", Options{}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := r.(*MemoryRepo); !ok {
+		t.Fatalf("memory:// opened %T", r)
+	}
+
+	r, err := Open("file://"+t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr, ok := r.(*FileRepo); !ok {
+		t.Fatalf("file:// opened %T", r)
+	} else {
+		_ = fr.Close()
+	}
+
+	for _, dsn := range []string{"", "/var/lib/slc", "redis://x", "file://"} {
+		if _, err := Open(dsn, Options{}); err == nil {
+			t.Fatalf("Open(%q) must fail", dsn)
+		}
+	}
+}
+
+func TestMemoryRepo_Snapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "sub", "snapshot.json")
+
+	r, err := Open("memory://"+path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := r.(*MemoryRepo)
+	st := State{Class1: models.Class{Name: "Cat", Properties: []string{"purr"}}}
+	if err := mr.UpsertState(ctx, "u1", st); err != nil {
+		t.Fatal(err)
+	}
+	if err := mr.CreateWorkspace(ctx, Workspace{ID: "ws1", OwnerID: "u1", Name: "Default"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := NewMemoryWithSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := restored.GetState(ctx, "u1")
+	if got.Class1.Name != "Cat" || len(got.Class1.Properties) != 1 {
+		t.Fatalf("restored state=%+v", got)
+	}
+	if ws, err := restored.GetWorkspace(ctx, "ws1"); err != nil || ws.OwnerID != "u1" {
+		t.Fatalf("restored workspace=%+v err=%v", ws, err)
+	}
+}
+
+func TestMemoryRepo_LegacySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	legacy := `{"u1":{"state":{"Class1":{"name":"Cat","properties":["purr"]}},"updatedAt":"2024-01-01T00:00:00Z"}}`
+	if err := os.WriteFile(path, []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewMemoryWithSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := r.GetState(context.Background(), "u1")
+	if got.Class1.Name != "Cat" {
+		t.Fatalf("legacy snapshot state=%+v", got)
+	}
+}
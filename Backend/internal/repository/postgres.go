@@ -0,0 +1,317 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+)
+
+// PostgresRepo is the PostgreSQL counterpart of MySQLRepo: one user_state row
+// per user with JSONB property columns.
+type PostgresRepo struct {
+	DB       *sql.DB
+	Timeouts Timeouts
+}
+
+func NewPostgres(dsn string) (*PostgresRepo, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &PostgresRepo{DB: db, Timeouts: DefaultTimeouts}, nil
+}
+
+func (r *PostgresRepo) GetState(ctx context.Context, userID string) (State, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Read)
+	defer cancel()
+
+	const q = `
+SELECT class1_name, class2_name, class1_props, class2_props, general_props, none_props
+FROM user_state
+WHERE user_id = $1`
+	var (
+		c1Name, c2Name    string
+		c1pJSON, c2pJSON  []byte
+		genJSON, noneJSON []byte
+	)
+	err := r.DB.QueryRowContext(ctx, q, userID).
+		Scan(&c1Name, &c2Name, &c1pJSON, &c2pJSON, &genJSON, &noneJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, wrapErr(err)
+	}
+
+	var c1p, c2p, gen, none []string
+	_ = json.Unmarshal(c1pJSON, &c1p)
+	_ = json.Unmarshal(c2pJSON, &c2p)
+	_ = json.Unmarshal(genJSON, &gen)
+	_ = json.Unmarshal(noneJSON, &none)
+
+	return State{
+		Class1:       models.Class{Name: c1Name, Properties: c1p},
+		Class2:       models.Class{Name: c2Name, Properties: c2p},
+		GeneralClass: gen,
+		NoneClass:    none,
+	}, nil
+}
+
+func (r *PostgresRepo) UpsertState(ctx context.Context, userID string, st State) error {
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Write)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer tx.Rollback()
+
+	if err := r.writeState(ctx, tx, userID, st); err != nil {
+		return wrapErr(err)
+	}
+	return wrapErr(tx.Commit())
+}
+
+func (r *PostgresRepo) writeState(ctx context.Context, tx *sql.Tx, userID string, st State) error {
+	c1pJSON, _ := json.Marshal(nonNil(st.Class1.Properties))
+	c2pJSON, _ := json.Marshal(nonNil(st.Class2.Properties))
+	genJSON, _ := json.Marshal(nonNil(st.GeneralClass))
+	noneJSON, _ := json.Marshal(nonNil(st.NoneClass))
+
+	const q = `
+INSERT INTO user_state (user_id, class1_name, class2_name, class1_props, class2_props, general_props, none_props)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (user_id) DO UPDATE SET
+  class1_name = EXCLUDED.class1_name,
+  class2_name = EXCLUDED.class2_name,
+  class1_props = EXCLUDED.class1_props,
+  class2_props = EXCLUDED.class2_props,
+  general_props = EXCLUDED.general_props,
+  none_props = EXCLUDED.none_props,
+  updated_at = now()
+`
+	if _, err := tx.ExecContext(ctx, q,
+		userID,
+		st.Class1.Name, st.Class2.Name,
+		string(c1pJSON), string(c2pJSON), string(genJSON), string(noneJSON),
+	); err != nil {
+		return err
+	}
+	return recordChangePostgres(ctx, tx, userID)
+}
+
+func (r *PostgresRepo) ResetUser(ctx context.Context, userID string) error {
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Write)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer tx.Rollback()
+
+	if err := r.deleteState(ctx, tx, userID); err != nil {
+		return wrapErr(err)
+	}
+	return wrapErr(tx.Commit())
+}
+
+func (r *PostgresRepo) deleteState(ctx context.Context, tx *sql.Tx, userID string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_state WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return recordChangePostgres(ctx, tx, userID)
+}
+
+func (r *PostgresRepo) workspaces() sqlWorkspaces {
+	return sqlWorkspaces{
+		db: r.DB, timeouts: r.Timeouts, postgres: true, stateTable: "user_state",
+		getState: r.GetState, writeState: r.writeState, deleteState: r.deleteState,
+	}
+}
+
+func (r *PostgresRepo) LoadState(ctx context.Context, id string) (State, int64, error) {
+	return r.workspaces().loadState(ctx, id)
+}
+
+func (r *PostgresRepo) CommitState(ctx context.Context, c StateCommit) error {
+	return r.workspaces().commitState(ctx, c)
+}
+
+func (r *PostgresRepo) CreateWorkspace(ctx context.Context, ws Workspace) error {
+	return r.workspaces().create(ctx, ws)
+}
+
+func (r *PostgresRepo) GetWorkspace(ctx context.Context, id string) (Workspace, error) {
+	return r.workspaces().get(ctx, id)
+}
+
+func (r *PostgresRepo) ListWorkspaces(ctx context.Context, ownerID string) ([]Workspace, error) {
+	return r.workspaces().list(ctx, ownerID)
+}
+
+func (r *PostgresRepo) RenameWorkspace(ctx context.Context, id, name string, at time.Time) error {
+	return r.workspaces().rename(ctx, id, name, at)
+}
+
+func (r *PostgresRepo) DeleteWorkspace(ctx context.Context, id string) error {
+	return r.workspaces().delete(ctx, id)
+}
+
+func (r *PostgresRepo) ScanWorkspaces(ctx context.Context, after string, limit int) ([]WorkspaceActivity, error) {
+	return r.workspaces().scan(ctx, after, limit)
+}
+
+func (r *PostgresRepo) ScanLegacyStates(ctx context.Context, after string, limit int) ([]LegacyState, error) {
+	return r.workspaces().scanLegacy(ctx, after, limit)
+}
+
+func (r *PostgresRepo) TouchWorkspace(ctx context.Context, id string, at time.Time) error {
+	return r.workspaces().touch(ctx, id, at)
+}
+
+func (r *PostgresRepo) PutGrant(ctx context.Context, g Grant) error {
+	return r.workspaces().putGrant(ctx, g)
+}
+
+func (r *PostgresRepo) GetGrant(ctx context.Context, workspaceID, userID string) (Grant, error) {
+	return r.workspaces().getGrant(ctx, workspaceID, userID)
+}
+
+func (r *PostgresRepo) ListGrants(ctx context.Context, workspaceID string) ([]Grant, error) {
+	return r.workspaces().listGrants(ctx, "workspace_id", workspaceID)
+}
+
+func (r *PostgresRepo) ListGrantsFor(ctx context.Context, userID string) ([]Grant, error) {
+	return r.workspaces().listGrants(ctx, "user_id", userID)
+}
+
+func (r *PostgresRepo) DeleteGrant(ctx context.Context, workspaceID, userID string) error {
+	return r.workspaces().deleteGrant(ctx, workspaceID, userID)
+}
+
+func (r *PostgresRepo) CreateShareLink(ctx context.Context, l ShareLink) error {
+	return r.workspaces().createLink(ctx, l)
+}
+
+func (r *PostgresRepo) GetShareLinkByHash(ctx context.Context, tokenHash string) (ShareLink, error) {
+	return r.workspaces().getLinkByHash(ctx, tokenHash)
+}
+
+func (r *PostgresRepo) ListShareLinks(ctx context.Context, workspaceID string) ([]ShareLink, error) {
+	return r.workspaces().listLinks(ctx, workspaceID)
+}
+
+func (r *PostgresRepo) RevokeShareLink(ctx context.Context, workspaceID, id string, at time.Time) error {
+	return r.workspaces().revokeLink(ctx, workspaceID, id, at)
+}
+
+func (r *PostgresRepo) CreateAPIKey(ctx context.Context, k APIKey) error {
+	return r.workspaces().createKey(ctx, k)
+}
+
+func (r *PostgresRepo) GetAPIKey(ctx context.Context, id string) (APIKey, error) {
+	return r.workspaces().getKey(ctx, id)
+}
+
+func (r *PostgresRepo) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	return r.workspaces().listKeys(ctx, userID)
+}
+
+func (r *PostgresRepo) RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) error {
+	return r.workspaces().revokeKey(ctx, userID, id, at)
+}
+
+func (r *PostgresRepo) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	return r.workspaces().touchKey(ctx, id, at)
+}
+
+func (r *PostgresRepo) DeleteAPIKeys(ctx context.Context, userID string) error {
+	return r.workspaces().deleteKeys(ctx, userID)
+}
+
+func (r *PostgresRepo) AppendAudit(ctx context.Context, e AuditEntry) (AuditEntry, error) {
+	return r.workspaces().appendAudit(ctx, e)
+}
+
+func (r *PostgresRepo) ListAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	return r.workspaces().listAudit(ctx, q)
+}
+
+func (r *PostgresRepo) RedactAuditActor(ctx context.Context, actor string) error {
+	return r.workspaces().redactAuditActor(ctx, actor)
+}
+
+func (r *PostgresRepo) DeleteAudit(ctx context.Context, workspaceID string) error {
+	return r.workspaces().deleteAudit(ctx, workspaceID)
+}
+
+func (r *PostgresRepo) MarkLegacySession(ctx context.Context, hash string, at time.Time) error {
+	return r.workspaces().markLegacySession(ctx, hash, at)
+}
+
+func recordChangePostgres(ctx context.Context, tx *sql.Tx, userID string) error {
+	const q = `
+INSERT INTO user_state_changes (user_id, version, changed_at)
+VALUES ($1, 1, clock_timestamp())
+ON CONFLICT (user_id) DO UPDATE SET
+  version = user_state_changes.version + 1,
+  changed_at = clock_timestamp()`
+	_, err := tx.ExecContext(ctx, q, userID)
+	return err
+}
+
+func (r *PostgresRepo) ChangedSince(ctx context.Context, since time.Time) ([]string, time.Time, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Read)
+	defer cancel()
+
+	var now time.Time
+	if err := r.DB.QueryRowContext(ctx, `SELECT clock_timestamp()`).Scan(&now); err != nil {
+		return nil, time.Time{}, wrapErr(err)
+	}
+	rows, err := r.DB.QueryContext(ctx, `SELECT user_id FROM user_state_changes WHERE changed_at >= $1`, since)
+	if err != nil {
+		return nil, time.Time{}, wrapErr(err)
+	}
+	defer rows.Close()
+
+	var users []string
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			return nil, time.Time{}, wrapErr(err)
+		}
+		users = append(users, uid)
+	}
+	return users, now, wrapErr(rows.Err())
+}
+
+func (r *PostgresRepo) SQLDB() *sql.DB  { return r.DB }
+func (r *PostgresRepo) Dialect() string { return "postgres" }
+func (r *PostgresRepo) Close() error    { return r.DB.Close() }
+
+func nonNil(ss []string) []string {
+	if ss == nil {
+		return []string{}
+	}
+	return ss
+}
+
+var (
+	_ Repository = (*PostgresRepo)(nil)
+	_ ChangeLog  = (*PostgresRepo)(nil)
+	_ SQLBackend = (*PostgresRepo)(nil)
+)
@@ -5,12 +5,17 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 
 	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
 )
 
+// ErrTimeout is returned when a repository operation exceeds its deadline.
+var ErrTimeout = errors.New("repository: operation timed out")
+
 type State struct {
 	Class1       models.Class
 	Class2       models.Class
@@ -18,14 +23,35 @@ type State struct {
 	NoneClass    []string
 }
 
+// Repository stores classifier states keyed by workspace ID, plus the
+// workspace catalog with its grants, share links, API keys and audit log.
+// Rows written before workspaces existed are keyed by user ID and are
+// adopted into the user's default workspace on first use.
 type Repository interface {
-	GetState(userID string) (State, error)
-	UpsertState(userID string, st State) error
-	ResetUser(userID string) error
+	GetState(ctx context.Context, userID string) (State, error)
+	UpsertState(ctx context.Context, userID string, st State) error
+	ResetUser(ctx context.Context, userID string) error
+	WorkspaceStore
+	GrantStore
+	ShareLinkStore
+	APIKeyStore
+	AuditStore
+	VersionedStore
+	LegacySessionStore
+}
+
+// Timeouts bounds individual queries on top of any deadline already carried
+// by the caller's context. A zero value disables the per-operation bound.
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
 }
 
+var DefaultTimeouts = Timeouts{Read: 2 * time.Second, Write: 5 * time.Second}
+
 type MySQLRepo struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Timeouts Timeouts
 }
 
 func New(dsn string) (*MySQLRepo, error) {
@@ -33,32 +59,37 @@ func New(dsn string) (*MySQLRepo, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err := db.Ping(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
 		return nil, err
 	}
-	if err := ensureSchema(db); err != nil {
-		return nil, err
+	return &MySQLRepo{DB: db, Timeouts: DefaultTimeouts}, nil
+}
+
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// wrapErr turns deadline errors into ErrTimeout so callers can tell a slow
+// store apart from other failures without inspecting driver errors.
+func wrapErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
 	}
-	return &MySQLRepo{DB: db}, nil
-}
-
-func ensureSchema(db *sql.DB) error {
-	const ddl = `
-CREATE TABLE IF NOT EXISTS user_state (
-  user_id       VARCHAR(128)  NOT NULL PRIMARY KEY,
-  class1_name   VARCHAR(255)  NOT NULL DEFAULT '',
-  class2_name   VARCHAR(255)  NOT NULL DEFAULT '',
-  class1_props  JSON          NOT NULL,
-  class2_props  JSON          NOT NULL,
-  general_props JSON          NOT NULL,
-  none_props    JSON          NOT NULL,
-  updated_at    TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
-	_, err := db.Exec(ddl)
 	return err
 }
 
-func (r *MySQLRepo) GetState(userID string) (State, error) {
+func (r *MySQLRepo) GetState(ctx context.Context, userID string) (State, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Read)
+	defer cancel()
+
 	const q = `
 SELECT class1_name, class2_name, class1_props, class2_props, general_props, none_props
 FROM user_state
@@ -68,13 +99,13 @@ WHERE user_id = ?`
 		c1pJSON, c2pJSON  []byte
 		genJSON, noneJSON []byte
 	)
-	err := r.DB.QueryRowContext(context.Background(), q, userID).
+	err := r.DB.QueryRowContext(ctx, q, userID).
 		Scan(&c1Name, &c2Name, &c1pJSON, &c2pJSON, &genJSON, &noneJSON)
 	if errors.Is(err, sql.ErrNoRows) {
 		return State{}, nil
 	}
 	if err != nil {
-		return State{}, err
+		return State{}, wrapErr(err)
 	}
 
 	var c1p, c2p, gen, none []string
@@ -91,7 +122,23 @@ WHERE user_id = ?`
 	}, nil
 }
 
-func (r *MySQLRepo) UpsertState(userID string, st State) error {
+func (r *MySQLRepo) UpsertState(ctx context.Context, userID string, st State) error {
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Write)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer tx.Rollback()
+
+	if err := r.writeState(ctx, tx, userID, st); err != nil {
+		return wrapErr(err)
+	}
+	return wrapErr(tx.Commit())
+}
+
+func (r *MySQLRepo) writeState(ctx context.Context, tx *sql.Tx, userID string, st State) error {
 	c1pJSON, _ := json.Marshal(st.Class1.Properties)
 	c2pJSON, _ := json.Marshal(st.Class2.Properties)
 	genJSON, _ := json.Marshal(st.GeneralClass)
@@ -109,17 +156,230 @@ ON DUPLICATE KEY UPDATE
   none_props = VALUES(none_props),
   updated_at = CURRENT_TIMESTAMP
 `
-	_, err := r.DB.ExecContext(context.Background(), q,
+	if _, err := tx.ExecContext(ctx, q,
 		userID,
 		st.Class1.Name, st.Class2.Name,
 		c1pJSON, c2pJSON, genJSON, noneJSON,
-	)
-	return err
+	); err != nil {
+		return err
+	}
+	return recordChange(ctx, tx, userID)
+}
+
+func (r *MySQLRepo) ResetUser(ctx context.Context, userID string) error {
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Write)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer tx.Rollback()
+
+	if err := r.deleteState(ctx, tx, userID); err != nil {
+		return wrapErr(err)
+	}
+	return wrapErr(tx.Commit())
+}
+
+func (r *MySQLRepo) deleteState(ctx context.Context, tx *sql.Tx, userID string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_state WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return recordChange(ctx, tx, userID)
+}
+
+func (r *MySQLRepo) workspaces() sqlWorkspaces {
+	return sqlWorkspaces{
+		db: r.DB, timeouts: r.Timeouts, stateTable: "user_state",
+		getState: r.GetState, writeState: r.writeState, deleteState: r.deleteState,
+	}
+}
+
+func (r *MySQLRepo) LoadState(ctx context.Context, id string) (State, int64, error) {
+	return r.workspaces().loadState(ctx, id)
+}
+
+func (r *MySQLRepo) CommitState(ctx context.Context, c StateCommit) error {
+	return r.workspaces().commitState(ctx, c)
+}
+
+func (r *MySQLRepo) CreateWorkspace(ctx context.Context, ws Workspace) error {
+	return r.workspaces().create(ctx, ws)
+}
+
+func (r *MySQLRepo) GetWorkspace(ctx context.Context, id string) (Workspace, error) {
+	return r.workspaces().get(ctx, id)
+}
+
+func (r *MySQLRepo) ListWorkspaces(ctx context.Context, ownerID string) ([]Workspace, error) {
+	return r.workspaces().list(ctx, ownerID)
+}
+
+func (r *MySQLRepo) RenameWorkspace(ctx context.Context, id, name string, at time.Time) error {
+	return r.workspaces().rename(ctx, id, name, at)
+}
+
+func (r *MySQLRepo) DeleteWorkspace(ctx context.Context, id string) error {
+	return r.workspaces().delete(ctx, id)
+}
+
+func (r *MySQLRepo) ScanWorkspaces(ctx context.Context, after string, limit int) ([]WorkspaceActivity, error) {
+	return r.workspaces().scan(ctx, after, limit)
+}
+
+func (r *MySQLRepo) ScanLegacyStates(ctx context.Context, after string, limit int) ([]LegacyState, error) {
+	return r.workspaces().scanLegacy(ctx, after, limit)
+}
+
+func (r *MySQLRepo) TouchWorkspace(ctx context.Context, id string, at time.Time) error {
+	return r.workspaces().touch(ctx, id, at)
+}
+
+func (r *MySQLRepo) PutGrant(ctx context.Context, g Grant) error {
+	return r.workspaces().putGrant(ctx, g)
 }
 
-func (r *MySQLRepo) ResetUser(userID string) error {
-	_, err := r.DB.Exec(`DELETE FROM user_state WHERE user_id = ?`, userID)
+func (r *MySQLRepo) GetGrant(ctx context.Context, workspaceID, userID string) (Grant, error) {
+	return r.workspaces().getGrant(ctx, workspaceID, userID)
+}
+
+func (r *MySQLRepo) ListGrants(ctx context.Context, workspaceID string) ([]Grant, error) {
+	return r.workspaces().listGrants(ctx, "workspace_id", workspaceID)
+}
+
+func (r *MySQLRepo) ListGrantsFor(ctx context.Context, userID string) ([]Grant, error) {
+	return r.workspaces().listGrants(ctx, "user_id", userID)
+}
+
+func (r *MySQLRepo) DeleteGrant(ctx context.Context, workspaceID, userID string) error {
+	return r.workspaces().deleteGrant(ctx, workspaceID, userID)
+}
+
+func (r *MySQLRepo) CreateShareLink(ctx context.Context, l ShareLink) error {
+	return r.workspaces().createLink(ctx, l)
+}
+
+func (r *MySQLRepo) GetShareLinkByHash(ctx context.Context, tokenHash string) (ShareLink, error) {
+	return r.workspaces().getLinkByHash(ctx, tokenHash)
+}
+
+func (r *MySQLRepo) ListShareLinks(ctx context.Context, workspaceID string) ([]ShareLink, error) {
+	return r.workspaces().listLinks(ctx, workspaceID)
+}
+
+func (r *MySQLRepo) RevokeShareLink(ctx context.Context, workspaceID, id string, at time.Time) error {
+	return r.workspaces().revokeLink(ctx, workspaceID, id, at)
+}
+
+func (r *MySQLRepo) CreateAPIKey(ctx context.Context, k APIKey) error {
+	return r.workspaces().createKey(ctx, k)
+}
+
+func (r *MySQLRepo) GetAPIKey(ctx context.Context, id string) (APIKey, error) {
+	return r.workspaces().getKey(ctx, id)
+}
+
+func (r *MySQLRepo) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	return r.workspaces().listKeys(ctx, userID)
+}
+
+func (r *MySQLRepo) RevokeAPIKey(ctx context.Context, userID, id string, at time.Time) error {
+	return r.workspaces().revokeKey(ctx, userID, id, at)
+}
+
+func (r *MySQLRepo) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	return r.workspaces().touchKey(ctx, id, at)
+}
+
+func (r *MySQLRepo) DeleteAPIKeys(ctx context.Context, userID string) error {
+	return r.workspaces().deleteKeys(ctx, userID)
+}
+
+func (r *MySQLRepo) AppendAudit(ctx context.Context, e AuditEntry) (AuditEntry, error) {
+	return r.workspaces().appendAudit(ctx, e)
+}
+
+func (r *MySQLRepo) ListAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	return r.workspaces().listAudit(ctx, q)
+}
+
+func (r *MySQLRepo) RedactAuditActor(ctx context.Context, actor string) error {
+	return r.workspaces().redactAuditActor(ctx, actor)
+}
+
+func (r *MySQLRepo) DeleteAudit(ctx context.Context, workspaceID string) error {
+	return r.workspaces().deleteAudit(ctx, workspaceID)
+}
+
+func (r *MySQLRepo) MarkLegacySession(ctx context.Context, hash string, at time.Time) error {
+	return r.workspaces().markLegacySession(ctx, hash, at)
+}
+
+func recordChange(ctx context.Context, tx *sql.Tx, userID string) error {
+	const q = `
+INSERT INTO user_state_changes (user_id, version, changed_at)
+VALUES (?, 1, CURRENT_TIMESTAMP(6))
+ON DUPLICATE KEY UPDATE
+  version = version + 1,
+  changed_at = CURRENT_TIMESTAMP(6)`
+	_, err := tx.ExecContext(ctx, q, userID)
 	return err
 }
 
-var _ Repository = (*MySQLRepo)(nil)
+func (r *MySQLRepo) ChangedSince(ctx context.Context, since time.Time) ([]string, time.Time, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Read)
+	defer cancel()
+	return changedSince(ctx, r.DB, since)
+}
+
+func changedSince(ctx context.Context, db *sql.DB, since time.Time) ([]string, time.Time, error) {
+	var now time.Time
+	if err := db.QueryRowContext(ctx, `SELECT CURRENT_TIMESTAMP(6)`).Scan(&now); err != nil {
+		return nil, time.Time{}, wrapErr(err)
+	}
+	rows, err := db.QueryContext(ctx, `SELECT user_id FROM user_state_changes WHERE changed_at >= ?`, since)
+	if err != nil {
+		return nil, time.Time{}, wrapErr(err)
+	}
+	defer rows.Close()
+
+	var users []string
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			return nil, time.Time{}, wrapErr(err)
+		}
+		users = append(users, uid)
+	}
+	return users, now, wrapErr(rows.Err())
+}
+
+// UserIDs pages through stored user IDs in ascending order, starting after
+// the given ID.
+func (r *MySQLRepo) UserIDs(ctx context.Context, after string, limit int) ([]string, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeouts.Read)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT user_id FROM user_state WHERE user_id > ? ORDER BY user_id LIMIT ?`, after, limit)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			return nil, wrapErr(err)
+		}
+		out = append(out, uid)
+	}
+	return out, wrapErr(rows.Err())
+}
+
+var (
+	_ Repository = (*MySQLRepo)(nil)
+	_ ChangeLog  = (*MySQLRepo)(nil)
+)
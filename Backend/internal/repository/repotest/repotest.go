@@ -0,0 +1,1066 @@
+// Package repotest is a conformance suite for repository.Repository
+// implementations. Every backend should pass Run.
+package repotest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+// Factory returns a repository for a single subtest. Implementations may
+// share storage between calls; the suite uses fresh user IDs every time.
+type Factory func(t *testing.T) repository.Repository
+
+func Run(t *testing.T, newRepo Factory) {
+	t.Run("MissingUserIsEmpty", func(t *testing.T) { testMissingUser(t, newRepo(t)) })
+	t.Run("RoundTrip", func(t *testing.T) { testRoundTrip(t, newRepo(t)) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, newRepo(t)) })
+	t.Run("UsersAreIsolated", func(t *testing.T) { testIsolation(t, newRepo(t)) })
+	t.Run("Reset", func(t *testing.T) { testReset(t, newRepo(t)) })
+	t.Run("Unicode", func(t *testing.T) { testUnicode(t, newRepo(t)) })
+	t.Run("LargeState", func(t *testing.T) { testLargeState(t, newRepo(t)) })
+	t.Run("ReturnedStateIsACopy", func(t *testing.T) { testCopy(t, newRepo(t)) })
+	t.Run("ConcurrentUpserts", func(t *testing.T) { testConcurrentUpserts(t, newRepo(t)) })
+	t.Run("VersionedCommits", func(t *testing.T) { testVersionedCommits(t, newRepo(t)) })
+	t.Run("AuditedCommits", func(t *testing.T) { testAuditedCommits(t, newRepo(t)) })
+	t.Run("Workspaces", func(t *testing.T) { testWorkspaces(t, newRepo(t)) })
+	t.Run("ScanWorkspaces", func(t *testing.T) { testScanWorkspaces(t, newRepo(t)) })
+	t.Run("ScanLegacyStates", func(t *testing.T) { testScanLegacyStates(t, newRepo(t)) })
+	t.Run("DeleteWorkspaceRemovesState", func(t *testing.T) { testDeleteWorkspace(t, newRepo(t)) })
+	t.Run("Grants", func(t *testing.T) { testGrants(t, newRepo(t)) })
+	t.Run("ShareLinks", func(t *testing.T) { testShareLinks(t, newRepo(t)) })
+	t.Run("APIKeys", func(t *testing.T) { testAPIKeys(t, newRepo(t)) })
+	t.Run("Audit", func(t *testing.T) { testAudit(t, newRepo(t)) })
+	t.Run("LegacySessions", func(t *testing.T) { testLegacySessions(t, newRepo(t)) })
+}
+
+func UserID(t *testing.T) string {
+	t.Helper()
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return "repotest-" + hex.EncodeToString(b)
+}
+
+func SampleState() repository.State {
+	return repository.State{
+		Class1:       models.Class{Name: "Cat", Properties: []string{"purr", "whiskers"}},
+		Class2:       models.Class{Name: "Dog", Properties: []string{"bark"}},
+		GeneralClass: []string{"tail"},
+		NoneClass:    []string{"blue"},
+	}
+}
+
+// AssertEqual compares states treating nil and empty property lists alike,
+// since backends are free to store either.
+func AssertEqual(t *testing.T, got, want repository.State) {
+	t.Helper()
+	if !reflect.DeepEqual(normalize(got), normalize(want)) {
+		t.Fatalf("state mismatch\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func assertEmpty(t *testing.T, st repository.State) {
+	t.Helper()
+	AssertEqual(t, st, repository.State{})
+}
+
+func normalize(st repository.State) repository.State {
+	nz := func(ss []string) []string {
+		if len(ss) == 0 {
+			return nil
+		}
+		return ss
+	}
+	st.Class1.Properties = nz(st.Class1.Properties)
+	st.Class2.Properties = nz(st.Class2.Properties)
+	st.GeneralClass = nz(st.GeneralClass)
+	st.NoneClass = nz(st.NoneClass)
+	return st
+}
+
+func testMissingUser(t *testing.T, r repository.Repository) {
+	st, err := r.GetState(context.Background(), UserID(t))
+	if err != nil {
+		t.Fatalf("missing user must not be an error: %v", err)
+	}
+	assertEmpty(t, st)
+}
+
+func testRoundTrip(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	uid := UserID(t)
+	want := SampleState()
+	if err := r.UpsertState(ctx, uid, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.GetState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, got, want)
+}
+
+func testOverwrite(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	uid := UserID(t)
+	if err := r.UpsertState(ctx, uid, SampleState()); err != nil {
+		t.Fatal(err)
+	}
+	want := repository.State{
+		Class1:       models.Class{Name: "Kitten", Properties: []string{"whiskers"}},
+		Class2:       models.Class{Name: "Dog", Properties: []string{"bark", "fetch"}},
+		GeneralClass: nil,
+		NoneClass:    []string{"blue", "green"},
+	}
+	if err := r.UpsertState(ctx, uid, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.GetState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, got, want)
+}
+
+func testIsolation(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	a, b := UserID(t), UserID(t)
+	if err := r.UpsertState(ctx, a, SampleState()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.GetState(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEmpty(t, got)
+
+	if err := r.ResetUser(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	got, err = r.GetState(ctx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, got, SampleState())
+}
+
+func testReset(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	uid := UserID(t)
+	if err := r.UpsertState(ctx, uid, SampleState()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ResetUser(ctx, uid); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.GetState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEmpty(t, got)
+
+	if err := r.ResetUser(ctx, uid); err != nil {
+		t.Fatalf("second reset must be a no-op: %v", err)
+	}
+	if err := r.ResetUser(ctx, UserID(t)); err != nil {
+		t.Fatalf("reset of unknown user must be a no-op: %v", err)
+	}
+}
+
+func testUnicode(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	uid := UserID(t) + "-пользователь-用户"
+	want := repository.State{
+		Class1:       models.Class{Name: "Кошка 🐱", Properties: []string{"мурлыканье", "whiskers", "Whiskers"}},
+		Class2:       models.Class{Name: "犬", Properties: []string{"吠える", "e\u0301té", "été"}},
+		GeneralClass: []string{"🐾", "ذيل"},
+		NoneClass:    []string{`quote " and \ backslash`, "tab\tand\nnewline"},
+	}
+	if err := r.UpsertState(ctx, uid, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.GetState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, got, want)
+}
+
+func testLargeState(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	uid := UserID(t)
+	props := func(prefix string, n int) []string {
+		out := make([]string, n)
+		for i := range out {
+			out[i] = fmt.Sprintf("%s-property-%05d", prefix, i)
+		}
+		return out
+	}
+	want := repository.State{
+		Class1:       models.Class{Name: "A", Properties: props("a", 1000)},
+		Class2:       models.Class{Name: "B", Properties: props("b", 1000)},
+		GeneralClass: props("g", 500),
+		NoneClass:    props("n", 500),
+	}
+	if err := r.UpsertState(ctx, uid, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.GetState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, got, want)
+
+	want.Class1.Properties = want.Class1.Properties[:10]
+	want.NoneClass = append(want.NoneClass, "n-extra")
+	if err := r.UpsertState(ctx, uid, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err = r.GetState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, got, want)
+}
+
+func testCopy(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	uid := UserID(t)
+	in := SampleState()
+	if err := r.UpsertState(ctx, uid, in); err != nil {
+		t.Fatal(err)
+	}
+	in.Class1.Properties[0] = "changed-after-upsert"
+
+	got, err := r.GetState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, got, SampleState())
+	got.Class1.Properties[0] = "changed-after-get"
+
+	again, err := r.GetState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, again, SampleState())
+}
+
+func testConcurrentUpserts(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	const workers = 8
+	shared := UserID(t)
+	own := make([]string, workers)
+	for i := range own {
+		own[i] = UserID(t)
+	}
+	stateFor := func(i int) repository.State {
+		st := SampleState()
+		st.Class1.Name = fmt.Sprintf("writer-%d", i)
+		st.NoneClass = []string{fmt.Sprintf("n-%d", i)}
+		return st
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*2)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := r.UpsertState(ctx, shared, stateFor(i)); err != nil {
+				errs <- err
+			}
+			if err := r.UpsertState(ctx, own[i], stateFor(i)); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent upsert: %v", err)
+	}
+
+	for i, uid := range own {
+		got, err := r.GetState(ctx, uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertEqual(t, got, stateFor(i))
+	}
+
+	got, err := r.GetState(ctx, shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < workers; i++ {
+		if reflect.DeepEqual(normalize(got), normalize(stateFor(i))) {
+			return
+		}
+	}
+	t.Fatalf("shared state is not any single writer's state: %+v", got)
+}
+
+func testAuditedCommits(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	ws := Workspace(t, UserID(t), "audited", time.Now())
+	entry := func(op string) *repository.AuditEntry {
+		return &repository.AuditEntry{
+			WorkspaceID: ws.ID, Actor: ws.OwnerID, Operation: op, Diff: json.RawMessage(`{}`),
+			Properties: []string{"purr"}, At: time.Now().UTC().Truncate(time.Microsecond),
+		}
+	}
+	if err := r.CommitState(ctx, repository.StateCommit{ID: ws.ID, State: SampleState(), Create: &ws, Audit: entry("import")}); err != nil {
+		t.Fatal(err)
+	}
+	_, version, err := r.LoadState(ctx, ws.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CommitState(ctx, repository.StateCommit{ID: ws.ID, Version: version, State: SampleState(), Audit: entry("feedback")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Commits that fail leave no entry behind.
+	if err := r.CommitState(ctx, repository.StateCommit{ID: ws.ID, Version: version, Reset: true, Audit: entry("reset")}); !errors.Is(err, repository.ErrStale) {
+		t.Fatalf("stale commit: err=%v; want ErrStale", err)
+	}
+	if err := r.CommitState(ctx, repository.StateCommit{ID: ws.ID, State: SampleState(), Create: &ws, Audit: entry("clone")}); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("create over an existing workspace: err=%v; want ErrConflict", err)
+	}
+
+	es, err := r.ListAudit(ctx, repository.AuditQuery{WorkspaceID: ws.ID, Property: "purr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 || es[0].Operation != "feedback" || es[1].Operation != "import" || es[0].ID <= es[1].ID {
+		t.Fatalf("entries=%+v; want feedback and import", es)
+	}
+}
+
+func testVersionedCommits(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	uid := UserID(t)
+
+	st, v0, err := r.LoadState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEmpty(t, st)
+	if v0 != 0 {
+		t.Fatalf("version of a missing state=%d; want 0", v0)
+	}
+	if err := r.CommitState(ctx, repository.StateCommit{ID: uid, Version: v0, State: SampleState()}); err != nil {
+		t.Fatal(err)
+	}
+	other := SampleState()
+	other.Class1.Name = "Lion"
+	if err := r.CommitState(ctx, repository.StateCommit{ID: uid, Version: v0, State: other}); !errors.Is(err, repository.ErrStale) {
+		t.Fatalf("second commit from version 0: err=%v; want ErrStale", err)
+	}
+
+	st, v1, err := r.LoadState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, st, SampleState())
+	if v1 == v0 {
+		t.Fatalf("version did not change on commit")
+	}
+	if err := r.UpsertState(ctx, uid, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CommitState(ctx, repository.StateCommit{ID: uid, Version: v1, State: SampleState()}); !errors.Is(err, repository.ErrStale) {
+		t.Fatalf("commit after an upsert: err=%v; want ErrStale", err)
+	}
+	got, err := r.GetState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, got, other)
+
+	_, v2, err := r.LoadState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CommitState(ctx, repository.StateCommit{ID: uid, Version: v2, Reset: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = r.GetState(ctx, uid); err != nil {
+		t.Fatal(err)
+	}
+	assertEmpty(t, got)
+
+	dest := UserID(t)
+	if err := r.UpsertState(ctx, uid, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UpsertState(ctx, dest, SampleState()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CommitState(ctx, repository.StateCommit{ID: uid, Version: v2, State: other, MoveTo: dest}); !errors.Is(err, repository.ErrStale) {
+		t.Fatalf("stale move: err=%v; want ErrStale", err)
+	}
+	if got, _ = r.GetState(ctx, dest); got.Class1.Name != SampleState().Class1.Name {
+		t.Fatalf("stale move wrote the destination: %+v", got)
+	}
+	_, v3, err := r.LoadState(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CommitState(ctx, repository.StateCommit{ID: uid, Version: v3, State: other, MoveTo: dest}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = r.GetState(ctx, dest); err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, got, other)
+	if got, err = r.GetState(ctx, uid); err != nil {
+		t.Fatal(err)
+	}
+	assertEmpty(t, got)
+
+	ws := Workspace(t, uid, "created with state", time.Now())
+	if err := r.CommitState(ctx, repository.StateCommit{ID: ws.ID, State: SampleState(), Create: &ws}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetWorkspace(ctx, ws.ID); err != nil {
+		t.Fatalf("created workspace: %v", err)
+	}
+	if got, err = r.GetState(ctx, ws.ID); err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, got, SampleState())
+	if err := r.CommitState(ctx, repository.StateCommit{ID: ws.ID, State: other, Create: &ws}); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("creating an existing workspace: err=%v; want ErrConflict", err)
+	}
+	if got, _ = r.GetState(ctx, ws.ID); got.Class1.Name != SampleState().Class1.Name {
+		t.Fatalf("conflicting create wrote the state: %+v", got)
+	}
+}
+
+// Workspace builds a workspace with timestamps that every backend stores
+// without loss.
+func Workspace(t *testing.T, owner, name string, created time.Time) repository.Workspace {
+	t.Helper()
+	created = created.UTC().Truncate(time.Millisecond)
+	return repository.Workspace{ID: UserID(t), OwnerID: owner, Name: name, CreatedAt: created, UpdatedAt: created}
+}
+
+func testWorkspaces(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	owner, other := UserID(t), UserID(t)
+	now := time.Now()
+	first := Workspace(t, owner, "spam vs ham", now.Add(-time.Minute))
+	second := Workspace(t, owner, "urgent vs routine", now)
+	second.Lineage = []repository.LineageEntry{
+		{Source: repository.LineageWorkspace, WorkspaceID: first.ID, Name: first.Name, ClonedAt: second.CreatedAt},
+		{Source: repository.LineageUpload, ClonedAt: first.CreatedAt},
+	}
+	foreign := Workspace(t, other, "someone else's", now)
+	foreign.Anonymous = true
+	for _, ws := range []repository.Workspace{second, first, foreign} {
+		if err := r.CreateWorkspace(ctx, ws); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.CreateWorkspace(ctx, first); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("duplicate create: err=%v; want ErrConflict", err)
+	}
+
+	got, err := r.GetWorkspace(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWorkspace(t, got, first)
+	got, err = r.GetWorkspace(ctx, foreign.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWorkspace(t, got, foreign)
+	if _, err := r.GetWorkspace(ctx, UserID(t)); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("get missing: err=%v; want ErrNotFound", err)
+	}
+
+	list, err := r.ListWorkspaces(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("list=%+v; want 2 workspaces", list)
+	}
+	assertWorkspace(t, list[0], first)
+	assertWorkspace(t, list[1], second)
+
+	renamedAt := now.Add(time.Minute).UTC().Truncate(time.Millisecond)
+	if err := r.RenameWorkspace(ctx, first.ID, "spam vs eggs", renamedAt); err != nil {
+		t.Fatal(err)
+	}
+	want := first
+	want.Name, want.UpdatedAt = "spam vs eggs", renamedAt
+	got, err = r.GetWorkspace(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWorkspace(t, got, want)
+	if err := r.RenameWorkspace(ctx, UserID(t), "x", renamedAt); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("rename missing: err=%v; want ErrNotFound", err)
+	}
+}
+
+func testScanWorkspaces(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	long := time.Now().Add(-time.Hour)
+	idle := Workspace(t, UserID(t), "idle", long)
+	idle.Anonymous = true
+	written := Workspace(t, UserID(t), "written", long)
+	read := Workspace(t, UserID(t), "read", long)
+	for _, ws := range []repository.Workspace{idle, written, read} {
+		if err := r.CreateWorkspace(ctx, ws); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.UpsertState(ctx, written.ID, SampleState()); err != nil {
+		t.Fatal(err)
+	}
+	accessed := long.Add(45 * time.Minute).UTC().Truncate(time.Microsecond)
+	if err := r.TouchWorkspace(ctx, read.ID, accessed); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.TouchWorkspace(ctx, UserID(t), accessed); err != nil {
+		t.Fatalf("touching an unknown workspace: %v", err)
+	}
+
+	// Other subtests may share the store, so page through everything.
+	seen := map[string]repository.WorkspaceActivity{}
+	after := ""
+	for {
+		page, err := r.ScanWorkspaces(ctx, after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 2 {
+			t.Fatalf("page of %d; want at most 2", len(page))
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, a := range page {
+			if a.ID <= after {
+				t.Fatalf("scan after %q returned %q", after, a.ID)
+			}
+			after = a.ID
+			seen[a.ID] = a
+		}
+	}
+
+	got, ok := seen[idle.ID]
+	if !ok {
+		t.Fatalf("scan missed %s", idle.ID)
+	}
+	assertWorkspace(t, got.Workspace, idle)
+	if !got.LastWrite.Equal(idle.UpdatedAt) {
+		t.Fatalf("idle last write=%v; want %v", got.LastWrite, idle.UpdatedAt)
+	}
+	got, ok = seen[written.ID]
+	if !ok {
+		t.Fatalf("scan missed %s", written.ID)
+	}
+	if !got.LastWrite.After(long.Add(30 * time.Minute)) {
+		t.Fatalf("written last write=%v; want the state's write time", got.LastWrite)
+	}
+	got, ok = seen[read.ID]
+	if !ok {
+		t.Fatalf("scan missed %s", read.ID)
+	}
+	if got.AccessedAt == nil || !got.AccessedAt.Equal(accessed) || !got.LastWrite.Equal(read.UpdatedAt) || !got.LastActive().Equal(accessed) {
+		t.Fatalf("read workspace accessed=%v last write=%v; want accessed %v", got.AccessedAt, got.LastWrite, accessed)
+	}
+	ws, err := r.GetWorkspace(ctx, read.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read.AccessedAt = &accessed
+	assertWorkspace(t, ws, read)
+}
+
+func testScanLegacyStates(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	legacy := UserID(t)
+	if err := r.UpsertState(ctx, legacy, SampleState()); err != nil {
+		t.Fatal(err)
+	}
+	adopted := Workspace(t, UserID(t), "adopted", time.Now())
+	if err := r.CreateWorkspace(ctx, adopted); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UpsertState(ctx, adopted.ID, SampleState()); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]repository.LegacyState{}
+	after := ""
+	for {
+		page, err := r.ScanLegacyStates(ctx, after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 2 {
+			t.Fatalf("page of %d; want at most 2", len(page))
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, l := range page {
+			if l.UserID <= after {
+				t.Fatalf("scan after %q returned %q", after, l.UserID)
+			}
+			after = l.UserID
+			seen[l.UserID] = l
+		}
+	}
+	got, ok := seen[legacy]
+	if !ok {
+		t.Fatalf("scan missed %s", legacy)
+	}
+	if time.Since(got.LastWrite) > time.Minute {
+		t.Fatalf("legacy last write=%v; want the state's write time", got.LastWrite)
+	}
+	if _, ok := seen[adopted.ID]; ok {
+		t.Fatalf("scan returned the state of workspace %s", adopted.ID)
+	}
+}
+
+func testDeleteWorkspace(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	owner := UserID(t)
+	keep := Workspace(t, owner, "keep", time.Now())
+	drop := Workspace(t, owner, "drop", time.Now())
+	for _, ws := range []repository.Workspace{keep, drop} {
+		if err := r.CreateWorkspace(ctx, ws); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.UpsertState(ctx, ws.ID, SampleState()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.DeleteWorkspace(ctx, drop.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetWorkspace(ctx, drop.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("deleted workspace still readable: err=%v", err)
+	}
+	st, err := r.GetState(ctx, drop.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEmpty(t, st)
+	st, err = r.GetState(ctx, keep.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, st, SampleState())
+
+	if err := r.DeleteWorkspace(ctx, drop.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("second delete: err=%v; want ErrNotFound", err)
+	}
+}
+
+func testGrants(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	owner, alice, bob := UserID(t), UserID(t), UserID(t)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	ws := Workspace(t, owner, "shared", now)
+	other := Workspace(t, owner, "other", now)
+	for _, w := range []repository.Workspace{ws, other} {
+		if err := r.CreateWorkspace(ctx, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put := func(w repository.Workspace, user, role string, at time.Time) {
+		t.Helper()
+		if err := r.PutGrant(ctx, repository.Grant{WorkspaceID: w.ID, UserID: user, Role: role, CreatedAt: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(ws, alice, repository.RoleViewer, now)
+	put(ws, bob, repository.RoleEditor, now.Add(time.Second))
+	put(other, alice, repository.RoleEditor, now.Add(2*time.Second))
+	put(ws, alice, repository.RoleEditor, now.Add(time.Hour))
+
+	g, err := r.GetGrant(ctx, ws.ID, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Role != repository.RoleEditor || !g.CreatedAt.Equal(now) {
+		t.Fatalf("updated grant=%+v; want editor created at %v", g, now)
+	}
+	if _, err := r.GetGrant(ctx, ws.ID, owner); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("missing grant: err=%v; want ErrNotFound", err)
+	}
+
+	list, err := r.ListGrants(ctx, ws.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].UserID != alice || list[1].UserID != bob {
+		t.Fatalf("grants of workspace=%+v", list)
+	}
+	list, err = r.ListGrantsFor(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].WorkspaceID != ws.ID || list[1].WorkspaceID != other.ID {
+		t.Fatalf("grants for user=%+v", list)
+	}
+
+	if err := r.DeleteGrant(ctx, ws.ID, bob); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteGrant(ctx, ws.ID, bob); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("second revoke: err=%v; want ErrNotFound", err)
+	}
+
+	if err := r.DeleteWorkspace(ctx, ws.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetGrant(ctx, ws.ID, alice); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("grant survived workspace delete: err=%v", err)
+	}
+	list, err = r.ListGrantsFor(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].WorkspaceID != other.ID {
+		t.Fatalf("grants for user after delete=%+v", list)
+	}
+}
+
+func testShareLinks(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	owner := UserID(t)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	ws := Workspace(t, owner, "public", now)
+	other := Workspace(t, owner, "other", now)
+	for _, w := range []repository.Workspace{ws, other} {
+		if err := r.CreateWorkspace(ctx, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expires := now.Add(24 * time.Hour)
+	hash := func() string {
+		b := make([]byte, 32)
+		_, _ = rand.Read(b)
+		return hex.EncodeToString(b)
+	}
+	permanent := repository.ShareLink{ID: UserID(t), WorkspaceID: ws.ID, TokenHash: hash(), CreatedBy: owner, CreatedAt: now}
+	expiring := repository.ShareLink{ID: UserID(t), WorkspaceID: ws.ID, TokenHash: hash(), CreatedBy: owner, CreatedAt: now.Add(time.Second), ExpiresAt: &expires}
+	foreign := repository.ShareLink{ID: UserID(t), WorkspaceID: other.ID, TokenHash: hash(), CreatedBy: owner, CreatedAt: now}
+	for _, l := range []repository.ShareLink{permanent, expiring, foreign} {
+		if err := r.CreateShareLink(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := r.GetShareLinkByHash(ctx, expiring.TokenHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != expiring.ID || got.WorkspaceID != ws.ID || got.ExpiresAt == nil || !got.ExpiresAt.Equal(expires) || got.RevokedAt != nil {
+		t.Fatalf("link=%+v; want %+v", got, expiring)
+	}
+	if _, err := r.GetShareLinkByHash(ctx, hash()); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("unknown token: err=%v; want ErrNotFound", err)
+	}
+
+	list, err := r.ListShareLinks(ctx, ws.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != permanent.ID || list[0].ExpiresAt != nil || list[1].ID != expiring.ID {
+		t.Fatalf("links=%+v", list)
+	}
+
+	revokedAt := now.Add(time.Minute)
+	if err := r.RevokeShareLink(ctx, ws.ID, permanent.ID, revokedAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RevokeShareLink(ctx, ws.ID, permanent.ID, revokedAt.Add(time.Hour)); err != nil {
+		t.Fatalf("second revoke must succeed: %v", err)
+	}
+	got, err = r.GetShareLinkByHash(ctx, permanent.TokenHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RevokedAt == nil || !got.RevokedAt.Equal(revokedAt) {
+		t.Fatalf("revoked link=%+v; want revokedAt %v", got, revokedAt)
+	}
+	if err := r.RevokeShareLink(ctx, ws.ID, foreign.ID, revokedAt); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("revoke through another workspace: err=%v; want ErrNotFound", err)
+	}
+
+	if err := r.DeleteWorkspace(ctx, ws.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetShareLinkByHash(ctx, expiring.TokenHash); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("link survived workspace delete: err=%v", err)
+	}
+	if _, err := r.GetShareLinkByHash(ctx, foreign.TokenHash); err != nil {
+		t.Fatalf("other workspace's link: %v", err)
+	}
+}
+
+func testAPIKeys(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	user := UserID(t)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	ws := Workspace(t, user, "pipeline", now)
+	other := Workspace(t, user, "other", now)
+	for _, w := range []repository.Workspace{ws, other} {
+		if err := r.CreateWorkspace(ctx, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key := func(workspaceID, scope string, at time.Time) repository.APIKey {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		secret := make([]byte, 32)
+		_, _ = rand.Read(secret)
+		return repository.APIKey{
+			ID: "key_" + hex.EncodeToString(b), UserID: user, WorkspaceID: workspaceID,
+			Name: "cron", Scope: scope, SecretHash: hex.EncodeToString(secret), CreatedAt: at,
+		}
+	}
+	train := key(ws.ID, "train", now)
+	classify := key(ws.ID, "classify", now.Add(time.Second))
+	foreign := key(other.ID, "admin", now.Add(2*time.Second))
+	for _, k := range []repository.APIKey{train, classify, foreign} {
+		if err := r.CreateAPIKey(ctx, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := r.GetAPIKey(ctx, train.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID != user || got.WorkspaceID != ws.ID || got.Scope != "train" || got.SecretHash != train.SecretHash ||
+		!got.CreatedAt.Equal(now) || got.LastUsedAt != nil || got.RevokedAt != nil {
+		t.Fatalf("key=%+v; want %+v", got, train)
+	}
+	if _, err := r.GetAPIKey(ctx, "key_0000000000000000"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("unknown key: err=%v; want ErrNotFound", err)
+	}
+
+	used := now.Add(time.Minute)
+	if err := r.TouchAPIKey(ctx, train.ID, used); err != nil {
+		t.Fatal(err)
+	}
+	list, err := r.ListAPIKeys(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].ID != train.ID || list[1].ID != classify.ID || list[2].ID != foreign.ID {
+		t.Fatalf("keys=%+v", list)
+	}
+	if list[0].LastUsedAt == nil || !list[0].LastUsedAt.Equal(used) {
+		t.Fatalf("lastUsedAt=%v; want %v", list[0].LastUsedAt, used)
+	}
+	if others, err := r.ListAPIKeys(ctx, UserID(t)); err != nil || len(others) != 0 {
+		t.Fatalf("another user's keys=%+v err=%v", others, err)
+	}
+
+	revokedAt := now.Add(time.Hour)
+	if err := r.RevokeAPIKey(ctx, user, classify.ID, revokedAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RevokeAPIKey(ctx, user, classify.ID, revokedAt.Add(time.Hour)); err != nil {
+		t.Fatalf("second revoke must succeed: %v", err)
+	}
+	if got, _ := r.GetAPIKey(ctx, classify.ID); got.RevokedAt == nil || !got.RevokedAt.Equal(revokedAt) {
+		t.Fatalf("revoked key=%+v; want revokedAt %v", got, revokedAt)
+	}
+	if err := r.RevokeAPIKey(ctx, UserID(t), train.ID, revokedAt); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("revoke by another user: err=%v; want ErrNotFound", err)
+	}
+
+	if err := r.DeleteWorkspace(ctx, ws.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetAPIKey(ctx, train.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("key survived workspace delete: err=%v", err)
+	}
+	if _, err := r.GetAPIKey(ctx, foreign.ID); err != nil {
+		t.Fatalf("other workspace's key: %v", err)
+	}
+
+	if err := r.DeleteAPIKeys(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetAPIKey(ctx, foreign.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("key survived deleting the user's keys: err=%v", err)
+	}
+}
+
+func testAudit(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	alice, bob := UserID(t), UserID(t)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	ws := Workspace(t, alice, "audited", now)
+	other := Workspace(t, alice, "other", now)
+	for _, w := range []repository.Workspace{ws, other} {
+		if err := r.CreateWorkspace(ctx, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entry := func(workspaceID, actor, op string, at time.Time, props ...string) repository.AuditEntry {
+		payload, _ := json.Marshal(map[string]any{"area": "class1", "properties": props})
+		return repository.AuditEntry{
+			WorkspaceID: workspaceID, Actor: actor, Via: "token", RequestID: "req-" + op, Operation: op,
+			Payload:    payload,
+			Diff:       json.RawMessage(`{"added":{"class1":["x"]}}`),
+			Properties: props, At: at,
+		}
+	}
+	appends := []repository.AuditEntry{
+		entry(ws.ID, alice, "init", now, "fur", "wings"),
+		entry(ws.ID, bob, "feedback", now.Add(time.Minute), "fur"),
+		entry(other.ID, bob, "prop/add", now.Add(2*time.Minute), "fur"),
+		entry(ws.ID, alice, "classes/rename", now.Add(3*time.Minute)),
+	}
+	var stored []repository.AuditEntry
+	for i, e := range appends {
+		got, err := r.AppendAudit(ctx, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID <= 0 || i > 0 && got.ID <= stored[i-1].ID {
+			t.Fatalf("entry %d got ID %d after %+v", i, got.ID, stored)
+		}
+		stored = append(stored, got)
+	}
+
+	list := func(q repository.AuditQuery) []repository.AuditEntry {
+		t.Helper()
+		got, err := r.ListAudit(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	ids := func(es []repository.AuditEntry) []int64 {
+		out := []int64{}
+		for _, e := range es {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+	expect := func(name string, got []repository.AuditEntry, want ...repository.AuditEntry) {
+		t.Helper()
+		if !reflect.DeepEqual(ids(got), ids(want)) {
+			t.Fatalf("%s: ids=%v; want %v", name, ids(got), ids(want))
+		}
+	}
+
+	all := list(repository.AuditQuery{WorkspaceID: ws.ID})
+	expect("workspace", all, stored[3], stored[1], stored[0])
+	first := all[2]
+	var payload, wantPayload map[string]any
+	_ = json.Unmarshal(first.Payload, &payload)
+	_ = json.Unmarshal(appends[0].Payload, &wantPayload)
+	if first.WorkspaceID != ws.ID || first.Actor != alice || first.Via != "token" || first.RequestID != "req-init" ||
+		first.Operation != "init" || !first.At.Equal(now) || !reflect.DeepEqual(payload, wantPayload) ||
+		!reflect.DeepEqual(first.Properties, []string{"fur", "wings"}) {
+		t.Fatalf("entry=%+v; want %+v", first, appends[0])
+	}
+	var diff map[string]any
+	if err := json.Unmarshal(first.Diff, &diff); err != nil || diff["added"] == nil {
+		t.Fatalf("diff=%s err=%v", first.Diff, err)
+	}
+
+	expect("time range", list(repository.AuditQuery{WorkspaceID: ws.ID, Since: now.Add(time.Minute), Until: now.Add(3 * time.Minute)}), stored[1])
+	expect("operation", list(repository.AuditQuery{WorkspaceID: ws.ID, Operation: "init"}), stored[0])
+	expect("property", list(repository.AuditQuery{WorkspaceID: ws.ID, Property: "fur"}), stored[1], stored[0])
+	expect("actor", list(repository.AuditQuery{Actor: bob}), stored[2], stored[1])
+	expect("page", list(repository.AuditQuery{WorkspaceID: ws.ID, Before: stored[3].ID, Limit: 1}), stored[1])
+
+	if err := r.RedactAuditActor(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+	expect("redacted actor", list(repository.AuditQuery{Actor: bob}))
+	if got := list(repository.AuditQuery{WorkspaceID: other.ID}); len(got) != 1 || got[0].Actor != "" || got[0].Via != "" {
+		t.Fatalf("redacted entry=%+v", got)
+	}
+
+	if err := r.DeleteWorkspace(ctx, ws.ID); err != nil {
+		t.Fatal(err)
+	}
+	expect("deleted workspace", list(repository.AuditQuery{WorkspaceID: ws.ID}), stored[3], stored[1], stored[0])
+	if err := r.DeleteAudit(ctx, ws.ID); err != nil {
+		t.Fatal(err)
+	}
+	expect("purged workspace", list(repository.AuditQuery{WorkspaceID: ws.ID}))
+	expect("other workspace", list(repository.AuditQuery{WorkspaceID: other.ID}), stored[2])
+}
+
+func assertWorkspace(t *testing.T, got, want repository.Workspace) {
+	t.Helper()
+	if got.ID != want.ID || got.OwnerID != want.OwnerID || got.Name != want.Name ||
+		!got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) || got.Anonymous != want.Anonymous ||
+		!sameTime(got.AccessedAt, want.AccessedAt) || !sameLineage(got.Lineage, want.Lineage) {
+		t.Fatalf("workspace mismatch\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func sameLineage(a, b []repository.LineageEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if x.Source != y.Source || x.WorkspaceID != y.WorkspaceID || x.Name != y.Name || !x.ClonedAt.Equal(y.ClonedAt) {
+			return false
+		}
+	}
+	return true
+}
+
+func testLegacySessions(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	sum := sha256.Sum256([]byte(UserID(t)))
+	hash := hex.EncodeToString(sum[:])
+	at := time.Now().UTC().Truncate(time.Millisecond)
+
+	if err := r.MarkLegacySession(ctx, hash, at); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.MarkLegacySession(ctx, hash, at.Add(time.Second)); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("second mark: err=%v; want ErrConflict", err)
+	}
+}
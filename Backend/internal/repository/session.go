@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// LegacySessionStore remembers which anonymous session IDs from before
+// session cookies were signed have been given a signed cookie, so an unsigned
+// cookie is honoured at most once. Only a hash of each ID is stored, and it is
+// kept when the user is erased so an old copy of the cookie stays refused.
+type LegacySessionStore interface {
+	// MarkLegacySession records hash, or returns ErrConflict if it was
+	// recorded before.
+	MarkLegacySession(ctx context.Context, hash string, at time.Time) error
+}
+
+func (s sqlWorkspaces) markLegacySession(ctx context.Context, hash string, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	query := `INSERT INTO legacy_sessions (id_hash, resigned_at) VALUES (?, ?)`
+	if s.postgres {
+		query += ` ON CONFLICT (id_hash) DO NOTHING`
+	} else {
+		query += ` ON DUPLICATE KEY UPDATE id_hash = id_hash`
+	}
+	res, err := s.db.ExecContext(ctx, s.q(query), hash, at)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
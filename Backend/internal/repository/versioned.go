@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrStale is returned by CommitState when the state was written after it
+// was loaded.
+var ErrStale = errors.New("repository: state changed since it was read")
+
+// StateCommit is a conditional write of the state stored under ID.
+type StateCommit struct {
+	ID string
+	// Version is the version LoadState returned. The commit fails with
+	// ErrStale if the stored version differs.
+	Version int64
+	State   State
+	// Reset deletes the state instead of writing State.
+	Reset bool
+	// MoveTo, if set, writes State under MoveTo instead, replacing what is
+	// stored there, and deletes the state under ID.
+	MoveTo string
+	// Create, if set, is a workspace with ID that is created together with
+	// its state. Version is then ignored, and the commit fails with
+	// ErrConflict if the workspace exists.
+	Create *Workspace
+	// Audit, if set, is appended to the audit log with the write, so a
+	// change is never saved without its entry or the other way round.
+	Audit *AuditEntry
+}
+
+// VersionedStore lets replicas sharing one store change a state without
+// losing each other's writes: read it with LoadState, then write it back with
+// CommitState and start over on ErrStale.
+type VersionedStore interface {
+	// LoadState reads the state and its version from the backing store,
+	// never from a cache. A state that was never written has version 0.
+	LoadState(ctx context.Context, id string) (State, int64, error)
+	CommitState(ctx context.Context, c StateCommit) error
+}
+
+// loadState reads the version before the state, so a write landing in
+// between pairs the newer state with the older version and the commit is
+// retried, rather than the other way round.
+func (s sqlWorkspaces) loadState(ctx context.Context, id string) (State, int64, error) {
+	rctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	var version int64
+	err := s.db.QueryRowContext(rctx, s.q(`SELECT version FROM user_state_changes WHERE user_id = ?`), id).Scan(&version)
+	cancel()
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return State{}, 0, wrapErr(err)
+	}
+	st, err := s.getState(ctx, id)
+	if err != nil {
+		return State{}, 0, err
+	}
+	return st, version, nil
+}
+
+func (s sqlWorkspaces) commitState(ctx context.Context, c StateCommit) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer tx.Rollback()
+
+	if c.Create != nil {
+		err = s.insertWorkspace(ctx, tx, *c.Create)
+	} else {
+		err = s.claimVersion(ctx, tx, c.ID, c.Version)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.MoveTo != "":
+		if err = s.deleteState(ctx, tx, c.ID); err == nil {
+			err = s.writeState(ctx, tx, c.MoveTo, c.State)
+		}
+	case c.Reset:
+		err = s.deleteState(ctx, tx, c.ID)
+	default:
+		err = s.writeState(ctx, tx, c.ID, c.State)
+	}
+	if err != nil {
+		return wrapErr(err)
+	}
+	if c.Audit != nil {
+		if _, err := s.insertAudit(ctx, tx, *c.Audit); err != nil {
+			return err
+		}
+	}
+	return wrapErr(tx.Commit())
+}
+
+// claimVersion locks the change row of id for the rest of tx, provided it
+// is still at version; writing the state then bumps it. Version 0 means no
+// row yet, and inserting a placeholder claims it: a concurrent insert waits
+// for tx and then finds the row taken.
+func (s sqlWorkspaces) claimVersion(ctx context.Context, tx *sql.Tx, id string, version int64) error {
+	if version == 0 {
+		query := `INSERT INTO user_state_changes (user_id, version, changed_at) VALUES (?, 0, ?)`
+		if s.postgres {
+			query += ` ON CONFLICT (user_id) DO NOTHING`
+		} else {
+			query += ` ON DUPLICATE KEY UPDATE user_id = user_id`
+		}
+		res, err := tx.ExecContext(ctx, s.q(query), id, time.Now().UTC())
+		if err != nil {
+			return wrapErr(err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return wrapErr(err)
+		} else if n == 0 {
+			return ErrStale
+		}
+		return nil
+	}
+	var got int64
+	err := tx.QueryRowContext(ctx, s.q(`SELECT version FROM user_state_changes WHERE user_id = ? FOR UPDATE`), id).Scan(&got)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrStale
+	}
+	if err != nil {
+		return wrapErr(err)
+	}
+	if got != version {
+		return ErrStale
+	}
+	return nil
+}
@@ -0,0 +1,585 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("repository: not found")
+	ErrConflict = errors.New("repository: already exists")
+)
+
+// Workspace is a named classifier owned by one user. Its state is stored
+// under the workspace ID through GetState, UpsertState and ResetUser.
+type Workspace struct {
+	ID        string         `json:"id"`
+	OwnerID   string         `json:"ownerId"`
+	Name      string         `json:"name"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+	Lineage   []LineageEntry `json:"lineage,omitempty"`
+	// Anonymous marks workspaces created by visitors without an account,
+	// which the retention janitor may expire sooner.
+	Anonymous bool `json:"anonymous,omitempty"`
+	// AccessedAt is when the workspace was last opened, for reading or
+	// writing; it is kept to within the interval the service touches at.
+	AccessedAt *time.Time `json:"accessedAt,omitempty"`
+}
+
+const (
+	LineageWorkspace = "workspace"
+	LineageUpload    = "upload"
+)
+
+// LineageEntry records where a cloned workspace's state came from. A
+// workspace's lineage lists its direct source first, followed by that
+// source's own lineage.
+type LineageEntry struct {
+	Source      string    `json:"source"`
+	WorkspaceID string    `json:"workspaceId,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	ClonedAt    time.Time `json:"clonedAt"`
+}
+
+// WorkspaceActivity is a workspace with the time it or its state was last
+// written.
+type WorkspaceActivity struct {
+	Workspace
+	LastWrite time.Time
+}
+
+// LastActive is the later of the workspace's last write and last access.
+func (a WorkspaceActivity) LastActive() time.Time {
+	if a.AccessedAt != nil && a.AccessedAt.After(a.LastWrite) {
+		return *a.AccessedAt
+	}
+	return a.LastWrite
+}
+
+// LegacyState is state stored under a bare user ID, from before workspaces
+// existed, that no workspace has adopted yet.
+type LegacyState struct {
+	UserID    string
+	LastWrite time.Time
+}
+
+// WorkspaceStore keeps the workspace catalog. CreateWorkspace returns
+// ErrConflict if the ID is taken; lookups of unknown IDs return ErrNotFound.
+// DeleteWorkspace also removes the workspace's state. ScanWorkspaces pages
+// through all workspaces in ID order, starting after the given ID, and
+// ScanLegacyStates likewise through the states no workspace owns.
+// TouchWorkspace records an access and ignores unknown IDs.
+type WorkspaceStore interface {
+	CreateWorkspace(ctx context.Context, ws Workspace) error
+	GetWorkspace(ctx context.Context, id string) (Workspace, error)
+	ListWorkspaces(ctx context.Context, ownerID string) ([]Workspace, error)
+	RenameWorkspace(ctx context.Context, id, name string, at time.Time) error
+	DeleteWorkspace(ctx context.Context, id string) error
+	ScanWorkspaces(ctx context.Context, after string, limit int) ([]WorkspaceActivity, error)
+	ScanLegacyStates(ctx context.Context, after string, limit int) ([]LegacyState, error)
+	TouchWorkspace(ctx context.Context, id string, at time.Time) error
+}
+
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+)
+
+// Grant gives a user other than the owner access to a workspace.
+type Grant struct {
+	WorkspaceID string    `json:"workspaceId"`
+	UserID      string    `json:"userId"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// GrantStore keeps workspace grants. PutGrant replaces the role of an
+// existing grant. Deleting a workspace deletes its grants.
+type GrantStore interface {
+	PutGrant(ctx context.Context, g Grant) error
+	GetGrant(ctx context.Context, workspaceID, userID string) (Grant, error)
+	ListGrants(ctx context.Context, workspaceID string) ([]Grant, error)
+	ListGrantsFor(ctx context.Context, userID string) ([]Grant, error)
+	DeleteGrant(ctx context.Context, workspaceID, userID string) error
+}
+
+// ShareLink lets anyone holding its token read a workspace. Only the SHA-256
+// of the token is stored.
+type ShareLink struct {
+	ID          string     `json:"id"`
+	WorkspaceID string     `json:"workspaceId"`
+	TokenHash   string     `json:"-"`
+	CreatedBy   string     `json:"createdBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+}
+
+// storedLink is how the memory and file backends persist a link, since the
+// token hash is left out of ShareLink's JSON.
+type storedLink struct {
+	ShareLink
+	TokenHash string `json:"tokenHash"`
+}
+
+func (s storedLink) link() ShareLink {
+	l := s.ShareLink
+	l.TokenHash = s.TokenHash
+	return l
+}
+
+// ShareLinkStore keeps share links. Revoked links are kept so owners can see
+// them; deleting a workspace deletes its links.
+type ShareLinkStore interface {
+	CreateShareLink(ctx context.Context, l ShareLink) error
+	GetShareLinkByHash(ctx context.Context, tokenHash string) (ShareLink, error)
+	ListShareLinks(ctx context.Context, workspaceID string) ([]ShareLink, error)
+	RevokeShareLink(ctx context.Context, workspaceID, id string, at time.Time) error
+}
+
+// sqlWorkspaces implements the workspace catalog stores on the workspaces
+// table and its dependents for both SQL dialects, along with versioned state
+// writes. writeState and deleteState write or remove the state row(s) of a
+// workspace inside the caller's transaction and record the change. stateTable
+// holds one row per state, keyed by user_id, whose updated_at is the state's
+// last write.
+type sqlWorkspaces struct {
+	db          *sql.DB
+	timeouts    Timeouts
+	postgres    bool
+	getState    func(ctx context.Context, id string) (State, error)
+	writeState  func(ctx context.Context, tx *sql.Tx, id string, st State) error
+	deleteState func(ctx context.Context, tx *sql.Tx, id string) error
+	stateTable  string
+}
+
+func (s sqlWorkspaces) q(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (s sqlWorkspaces) create(ctx context.Context, ws Workspace) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+	return s.insertWorkspace(ctx, s.db, ws)
+}
+
+// insertWorkspace adds ws through db, which is the database or a
+// transaction, and returns ErrConflict if the ID is taken.
+func (s sqlWorkspaces) insertWorkspace(ctx context.Context, db interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+}, ws Workspace) error {
+	var lineage []byte
+	if len(ws.Lineage) > 0 {
+		var err error
+		if lineage, err = json.Marshal(ws.Lineage); err != nil {
+			return err
+		}
+	}
+
+	query := `INSERT INTO workspaces (id, owner_id, name, created_at, updated_at, lineage, anonymous) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	if s.postgres {
+		query += ` ON CONFLICT (id) DO NOTHING`
+	} else {
+		query += ` ON DUPLICATE KEY UPDATE id = id`
+	}
+	res, err := db.ExecContext(ctx, s.q(query), ws.ID, ws.OwnerID, ws.Name, ws.CreatedAt, ws.UpdatedAt, nullableJSON(lineage), ws.Anonymous)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+const workspaceColumns = `id, owner_id, name, created_at, updated_at, lineage, anonymous, accessed_at`
+
+// scanWorkspace reads workspaceColumns followed by any extra columns.
+func scanWorkspace(sc interface{ Scan(...any) error }, extra ...any) (Workspace, error) {
+	var (
+		ws       Workspace
+		lineage  []byte
+		accessed sql.NullTime
+	)
+	dest := append([]any{&ws.ID, &ws.OwnerID, &ws.Name, &ws.CreatedAt, &ws.UpdatedAt, &lineage, &ws.Anonymous, &accessed}, extra...)
+	if err := sc.Scan(dest...); err != nil {
+		return Workspace{}, err
+	}
+	ws.CreatedAt, ws.UpdatedAt = ws.CreatedAt.UTC(), ws.UpdatedAt.UTC()
+	ws.AccessedAt = utcPtr(accessed)
+	if len(lineage) > 0 {
+		if err := json.Unmarshal(lineage, &ws.Lineage); err != nil {
+			return Workspace{}, fmt.Errorf("workspace %s: bad lineage: %w", ws.ID, err)
+		}
+	}
+	return ws, nil
+}
+
+// nullableJSON passes an absent document as NULL rather than an empty string,
+// which JSON columns reject.
+func nullableJSON(b []byte) any {
+	if b == nil {
+		return nil
+	}
+	return string(b)
+}
+
+func (s sqlWorkspaces) get(ctx context.Context, id string) (Workspace, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	ws, err := scanWorkspace(s.db.QueryRowContext(ctx, s.q(`SELECT `+workspaceColumns+` FROM workspaces WHERE id = ?`), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Workspace{}, ErrNotFound
+	}
+	return ws, wrapErr(err)
+}
+
+func (s sqlWorkspaces) list(ctx context.Context, ownerID string) ([]Workspace, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		s.q(`SELECT `+workspaceColumns+` FROM workspaces WHERE owner_id = ? ORDER BY created_at, id`), ownerID)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	var out []Workspace
+	for rows.Next() {
+		ws, err := scanWorkspace(rows)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		out = append(out, ws)
+	}
+	return out, wrapErr(rows.Err())
+}
+
+func (s sqlWorkspaces) scan(ctx context.Context, after string, limit int) ([]WorkspaceActivity, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, s.q(`
+SELECT w.id, w.owner_id, w.name, w.created_at, w.updated_at, w.lineage, w.anonymous, w.accessed_at, s.updated_at
+FROM workspaces w LEFT JOIN `+s.stateTable+` s ON s.user_id = w.id
+WHERE w.id > ?
+ORDER BY w.id
+LIMIT ?`), after, limit)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	var out []WorkspaceActivity
+	for rows.Next() {
+		var stateWrite sql.NullTime
+		ws, err := scanWorkspace(rows, &stateWrite)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		a := WorkspaceActivity{Workspace: ws, LastWrite: ws.UpdatedAt}
+		if stateWrite.Valid && stateWrite.Time.After(a.LastWrite) {
+			a.LastWrite = stateWrite.Time.UTC()
+		}
+		out = append(out, a)
+	}
+	return out, wrapErr(rows.Err())
+}
+
+func (s sqlWorkspaces) scanLegacy(ctx context.Context, after string, limit int) ([]LegacyState, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, s.q(`
+SELECT s.user_id, s.updated_at
+FROM `+s.stateTable+` s LEFT JOIN workspaces w ON w.id = s.user_id
+WHERE w.id IS NULL AND s.user_id > ?
+ORDER BY s.user_id
+LIMIT ?`), after, limit)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	var out []LegacyState
+	for rows.Next() {
+		var l LegacyState
+		if err := rows.Scan(&l.UserID, &l.LastWrite); err != nil {
+			return nil, wrapErr(err)
+		}
+		l.LastWrite = l.LastWrite.UTC()
+		out = append(out, l)
+	}
+	return out, wrapErr(rows.Err())
+}
+
+func (s sqlWorkspaces) touch(ctx context.Context, id string, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, s.q(`UPDATE workspaces SET accessed_at = ? WHERE id = ?`), at, id)
+	return wrapErr(err)
+}
+
+func (s sqlWorkspaces) rename(ctx context.Context, id, name string, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, s.q(`UPDATE workspaces SET name = ?, updated_at = ? WHERE id = ?`), name, at, id)
+	if err != nil {
+		return wrapErr(err)
+	}
+	return checkAffected(res)
+}
+
+func (s sqlWorkspaces) delete(ctx context.Context, id string) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, s.q(`DELETE FROM workspaces WHERE id = ?`), id)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if err := checkAffected(res); err != nil {
+		return err
+	}
+	if err := s.deleteState(ctx, tx, id); err != nil {
+		return wrapErr(err)
+	}
+	return wrapErr(tx.Commit())
+}
+
+func (s sqlWorkspaces) putGrant(ctx context.Context, g Grant) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	query := `INSERT INTO workspace_grants (workspace_id, user_id, role, created_at) VALUES (?, ?, ?, ?)`
+	if s.postgres {
+		query += ` ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = EXCLUDED.role`
+	} else {
+		query += ` ON DUPLICATE KEY UPDATE role = VALUES(role)`
+	}
+	_, err := s.db.ExecContext(ctx, s.q(query), g.WorkspaceID, g.UserID, g.Role, g.CreatedAt)
+	return wrapErr(err)
+}
+
+const grantColumns = `workspace_id, user_id, role, created_at`
+
+func (s sqlWorkspaces) getGrant(ctx context.Context, workspaceID, userID string) (Grant, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	var g Grant
+	err := s.db.QueryRowContext(ctx,
+		s.q(`SELECT `+grantColumns+` FROM workspace_grants WHERE workspace_id = ? AND user_id = ?`), workspaceID, userID).
+		Scan(&g.WorkspaceID, &g.UserID, &g.Role, &g.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Grant{}, ErrNotFound
+	}
+	if err != nil {
+		return Grant{}, wrapErr(err)
+	}
+	g.CreatedAt = g.CreatedAt.UTC()
+	return g, nil
+}
+
+func (s sqlWorkspaces) listGrants(ctx context.Context, column, value string) ([]Grant, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		s.q(`SELECT `+grantColumns+` FROM workspace_grants WHERE `+column+` = ? ORDER BY created_at, workspace_id, user_id`), value)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	var out []Grant
+	for rows.Next() {
+		var g Grant
+		if err := rows.Scan(&g.WorkspaceID, &g.UserID, &g.Role, &g.CreatedAt); err != nil {
+			return nil, wrapErr(err)
+		}
+		g.CreatedAt = g.CreatedAt.UTC()
+		out = append(out, g)
+	}
+	return out, wrapErr(rows.Err())
+}
+
+func (s sqlWorkspaces) deleteGrant(ctx context.Context, workspaceID, userID string) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx,
+		s.q(`DELETE FROM workspace_grants WHERE workspace_id = ? AND user_id = ?`), workspaceID, userID)
+	if err != nil {
+		return wrapErr(err)
+	}
+	return checkAffected(res)
+}
+
+func (s sqlWorkspaces) createLink(ctx context.Context, l ShareLink) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, s.q(`
+INSERT INTO share_links (id, workspace_id, token_hash, created_by, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?)`), l.ID, l.WorkspaceID, l.TokenHash, l.CreatedBy, l.CreatedAt, l.ExpiresAt)
+	return wrapErr(err)
+}
+
+const linkColumns = `id, workspace_id, token_hash, created_by, created_at, expires_at, revoked_at`
+
+func scanLink(sc interface{ Scan(...any) error }) (ShareLink, error) {
+	var (
+		l                ShareLink
+		expires, revoked sql.NullTime
+	)
+	if err := sc.Scan(&l.ID, &l.WorkspaceID, &l.TokenHash, &l.CreatedBy, &l.CreatedAt, &expires, &revoked); err != nil {
+		return ShareLink{}, err
+	}
+	l.CreatedAt = l.CreatedAt.UTC()
+	l.ExpiresAt, l.RevokedAt = utcPtr(expires), utcPtr(revoked)
+	return l, nil
+}
+
+func utcPtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	u := t.Time.UTC()
+	return &u
+}
+
+func (s sqlWorkspaces) getLinkByHash(ctx context.Context, hash string) (ShareLink, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	l, err := scanLink(s.db.QueryRowContext(ctx, s.q(`SELECT `+linkColumns+` FROM share_links WHERE token_hash = ?`), hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShareLink{}, ErrNotFound
+	}
+	return l, wrapErr(err)
+}
+
+func (s sqlWorkspaces) listLinks(ctx context.Context, workspaceID string) ([]ShareLink, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		s.q(`SELECT `+linkColumns+` FROM share_links WHERE workspace_id = ? ORDER BY created_at, id`), workspaceID)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	var out []ShareLink
+	for rows.Next() {
+		l, err := scanLink(rows)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		out = append(out, l)
+	}
+	return out, wrapErr(rows.Err())
+}
+
+func (s sqlWorkspaces) revokeLink(ctx context.Context, workspaceID, id string, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx,
+		s.q(`UPDATE share_links SET revoked_at = COALESCE(revoked_at, ?) WHERE workspace_id = ? AND id = ?`),
+		at, workspaceID, id)
+	if err != nil {
+		return wrapErr(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return wrapErr(err)
+	}
+	if n > 0 {
+		return nil
+	}
+	// MySQL counts changed rows only, so revoking twice affects nothing.
+	var one int
+	err = s.db.QueryRowContext(ctx, s.q(`SELECT 1 FROM share_links WHERE workspace_id = ? AND id = ?`), workspaceID, id).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return wrapErr(err)
+}
+
+// checkAffected maps an UPDATE or DELETE that matched nothing to ErrNotFound.
+// Renames always bump updated_at, so MySQL's changed-rows count is non-zero
+// for existing workspaces too.
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return wrapErr(err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// sortWorkspaces orders workspaces the way the SQL backends list them.
+func sortWorkspaces(ws []Workspace) {
+	sort.Slice(ws, func(i, j int) bool {
+		if !ws[i].CreatedAt.Equal(ws[j].CreatedAt) {
+			return ws[i].CreatedAt.Before(ws[j].CreatedAt)
+		}
+		return ws[i].ID < ws[j].ID
+	})
+}
+
+func sortGrants(gs []Grant) {
+	sort.Slice(gs, func(i, j int) bool {
+		if !gs[i].CreatedAt.Equal(gs[j].CreatedAt) {
+			return gs[i].CreatedAt.Before(gs[j].CreatedAt)
+		}
+		if gs[i].WorkspaceID != gs[j].WorkspaceID {
+			return gs[i].WorkspaceID < gs[j].WorkspaceID
+		}
+		return gs[i].UserID < gs[j].UserID
+	})
+}
+
+func sortLinks(ls []ShareLink) {
+	sort.Slice(ls, func(i, j int) bool {
+		if !ls[i].CreatedAt.Equal(ls[j].CreatedAt) {
+			return ls[i].CreatedAt.Before(ls[j].CreatedAt)
+		}
+		return ls[i].ID < ls[j].ID
+	})
+}
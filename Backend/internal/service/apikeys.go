@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+// Scope limits what an API key may do. A key never exceeds the role its
+// user holds in the key's workspace.
+type Scope string
+
+const (
+	ScopeClassify Scope = "classify"
+	ScopeTrain    Scope = "train"
+	ScopeAdmin    Scope = "admin"
+)
+
+var scopeRoles = map[Scope]Role{ScopeClassify: RoleViewer, ScopeTrain: RoleEditor, ScopeAdmin: RoleOwner}
+
+// Role is the highest workspace role the scope grants.
+func (s Scope) Role() (Role, bool) {
+	r, ok := scopeRoles[s]
+	return r, ok
+}
+
+const (
+	apiKeyPrefix = "slc_"
+	// keyTouchInterval bounds how often a busy key writes its last-used time.
+	keyTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidScope   = errors.New("scope must be classify, train or admin")
+	ErrInvalidKeyName = errors.New("key name must be at most 100 characters")
+	ErrKeyNotFound    = errors.New("API key not found")
+	ErrInvalidKey     = errors.New("invalid or revoked API key")
+	ErrKeyScope       = errors.New("this API key is not allowed to do this")
+)
+
+// IsAPIKey reports whether a bearer credential looks like an API key rather
+// than a signed token.
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, apiKeyPrefix)
+}
+
+// CreateKey issues a key that acts as the user in the workspace, or in their
+// default workspace when id is empty. The user must hold the role the scope
+// grants. The secret is returned only here; the store keeps its hash.
+func (w *Workspaces) CreateKey(ctx context.Context, userID, id, name string, scope Scope) (repository.APIKey, string, error) {
+	role, ok := scope.Role()
+	if !ok {
+		return repository.APIKey{}, "", ErrInvalidScope
+	}
+	a, err := w.Resolve(ctx, userID, id, role)
+	if err != nil {
+		return repository.APIKey{}, "", err
+	}
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > maxWorkspaceName {
+		return repository.APIKey{}, "", ErrInvalidKeyName
+	}
+
+	idBytes := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return repository.APIKey{}, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return repository.APIKey{}, "", err
+	}
+	keyID, secretHex := hex.EncodeToString(idBytes), hex.EncodeToString(secret)
+
+	k := repository.APIKey{
+		ID:          "key_" + keyID,
+		UserID:      userID,
+		WorkspaceID: a.ID,
+		Name:        name,
+		Scope:       string(scope),
+		SecretHash:  hashToken(secretHex),
+		CreatedAt:   w.timestamp(),
+	}
+	if err := w.repo.CreateAPIKey(ctx, k); err != nil {
+		return repository.APIKey{}, "", notFound(err)
+	}
+	return k, apiKeyPrefix + keyID + "_" + secretHex, nil
+}
+
+func (w *Workspaces) Keys(ctx context.Context, userID string) ([]repository.APIKey, error) {
+	return w.repo.ListAPIKeys(ctx, userID)
+}
+
+func (w *Workspaces) RevokeKey(ctx context.Context, userID, keyID string) error {
+	err := w.repo.RevokeAPIKey(ctx, userID, keyID, w.timestamp())
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrKeyNotFound
+	}
+	return err
+}
+
+// AuthenticateKey returns the key a credential belongs to and records its
+// use.
+func (w *Workspaces) AuthenticateKey(ctx context.Context, credential string) (repository.APIKey, error) {
+	keyID, secret, ok := strings.Cut(strings.TrimPrefix(credential, apiKeyPrefix), "_")
+	if !ok || !IsAPIKey(credential) {
+		return repository.APIKey{}, ErrInvalidKey
+	}
+	k, err := w.repo.GetAPIKey(ctx, "key_"+keyID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return repository.APIKey{}, ErrInvalidKey
+	}
+	if err != nil {
+		return repository.APIKey{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(k.SecretHash)) != 1 || k.RevokedAt != nil {
+		return repository.APIKey{}, ErrInvalidKey
+	}
+
+	now := w.timestamp()
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= keyTouchInterval {
+		if err := w.repo.TouchAPIKey(ctx, k.ID, now); err != nil {
+			log.Printf("[key=%s] record last use: %v", k.ID, err)
+		} else {
+			k.LastUsedAt = &now
+		}
+	}
+	return k, nil
+}
+
+// KeyAccess is Resolve for requests authenticated by an API key: the key
+// reaches only its own workspace, within its scope and its user's role.
+func (w *Workspaces) KeyAccess(ctx context.Context, k repository.APIKey, id string, need Role) (Access, error) {
+	if id == "" {
+		id = k.WorkspaceID
+	}
+	if role, _ := Scope(k.Scope).Role(); id != k.WorkspaceID || !role.Allows(need) {
+		return Access{}, ErrKeyScope
+	}
+	return w.require(ctx, k.UserID, id, need)
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func TestWorkspaces_APIKeys(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	ws.now = func() time.Time { return now }
+
+	if _, _, err := ws.CreateKey(ctx, "alice", "", "cron", "root"); !errors.Is(err, ErrInvalidScope) {
+		t.Fatalf("bad scope err=%v", err)
+	}
+	k, secret, err := ws.CreateKey(ctx, "alice", "", "cron", ScopeTrain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, _ := ws.Default(ctx, "alice")
+	if k.WorkspaceID != def.ID || k.SecretHash == "" || !IsAPIKey(secret) {
+		t.Fatalf("key=%+v secret=%q", k, secret)
+	}
+
+	got, err := ws.AuthenticateKey(ctx, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != k.ID || got.LastUsedAt == nil || !got.LastUsedAt.Equal(now) {
+		t.Fatalf("authenticated key=%+v", got)
+	}
+	tampered := secret[:len(secret)-1] + "0"
+	if tampered == secret {
+		tampered = secret[:len(secret)-1] + "1"
+	}
+	if _, err := ws.AuthenticateKey(ctx, tampered); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("tampered secret err=%v", err)
+	}
+	for _, bad := range []string{"slc_", "slc_nokey", "slc_0000000000000000_00"} {
+		if _, err := ws.AuthenticateKey(ctx, bad); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("AuthenticateKey(%q) err=%v", bad, err)
+		}
+	}
+
+	if _, err := ws.KeyAccess(ctx, got, "", RoleEditor); err != nil {
+		t.Fatalf("train key feedback: %v", err)
+	}
+	if _, err := ws.KeyAccess(ctx, got, "", RoleOwner); !errors.Is(err, ErrKeyScope) {
+		t.Fatalf("train key reset err=%v", err)
+	}
+	other, _ := ws.Create(ctx, "alice", "other")
+	if _, err := ws.KeyAccess(ctx, got, other.ID, RoleViewer); !errors.Is(err, ErrKeyScope) {
+		t.Fatalf("key used on another workspace err=%v", err)
+	}
+
+	if err := ws.RevokeKey(ctx, "bob", k.ID); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("revoke by another user err=%v", err)
+	}
+	if err := ws.RevokeKey(ctx, "alice", k.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.AuthenticateKey(ctx, secret); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("revoked key err=%v", err)
+	}
+}
+
+func TestWorkspaces_APIKeyCannotExceedRole(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWorkspaces(repository.NewMemory())
+
+	shared, _ := ws.Create(ctx, "alice", "shared")
+	if _, err := ws.Share(ctx, "alice", shared.ID, "bob", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ws.CreateKey(ctx, "bob", shared.ID, "", ScopeTrain); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("viewer creating a train key err=%v", err)
+	}
+	k, secret, err := ws.CreateKey(ctx, "bob", shared.ID, "", ScopeClassify)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Losing access to the workspace disables the key too.
+	if err := ws.Revoke(ctx, "alice", shared.ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ws.AuthenticateKey(ctx, secret)
+	if err != nil || got.ID != k.ID {
+		t.Fatalf("key=%+v err=%v", got, err)
+	}
+	if _, err := ws.KeyAccess(ctx, got, "", RoleViewer); !errors.Is(err, ErrWorkspaceNotFound) {
+		t.Fatalf("key after grant revoked err=%v", err)
+	}
+}
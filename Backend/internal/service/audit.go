@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID tags ctx with the ID of the request it serves, which audit
+// entries record.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Actor is who changes a classifier, as recorded in its audit log. Via says
+// how they authenticated; see repository.AuditEntry.
+type Actor struct {
+	UserID string
+	Via    string
+}
+
+// AuditDiff is the compact change an audit entry records: renamed classes
+// and, per area, the properties that entered or left it. A property moved
+// between areas is removed from one and added to the other.
+type AuditDiff struct {
+	Classes map[string]ClassRename `json:"classes,omitempty"`
+	Added   map[string][]string    `json:"added,omitempty"`
+	Removed map[string][]string    `json:"removed,omitempty"`
+}
+
+type ClassRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func diffStates(before, after repository.State) AuditDiff {
+	var d AuditDiff
+	classes := []struct {
+		key      string
+		from, to string
+	}{
+		{"class1", before.Class1.Name, after.Class1.Name},
+		{"class2", before.Class2.Name, after.Class2.Name},
+	}
+	for _, c := range classes {
+		if c.from != c.to {
+			if d.Classes == nil {
+				d.Classes = map[string]ClassRename{}
+			}
+			d.Classes[c.key] = ClassRename{From: c.from, To: c.to}
+		}
+	}
+	areas := []struct {
+		key      string
+		from, to []string
+	}{
+		{"class1", before.Class1.Properties, after.Class1.Properties},
+		{"class2", before.Class2.Properties, after.Class2.Properties},
+		{"general", before.GeneralClass, after.GeneralClass},
+		{"none", before.NoneClass, after.NoneClass},
+	}
+	for _, a := range areas {
+		if added := sortStrings(diff(a.to, a.from)); len(added) > 0 {
+			if d.Added == nil {
+				d.Added = map[string][]string{}
+			}
+			d.Added[a.key] = added
+		}
+		if removed := sortStrings(diff(a.from, a.to)); len(removed) > 0 {
+			if d.Removed == nil {
+				d.Removed = map[string][]string{}
+			}
+			d.Removed[a.key] = removed
+		}
+	}
+	return d
+}
+
+// properties lists every property the diff names.
+func (d AuditDiff) properties() []string {
+	var out []string
+	for _, ps := range d.Added {
+		out = append(out, ps...)
+	}
+	for _, ps := range d.Removed {
+		out = append(out, ps...)
+	}
+	return out
+}
+
+// maxAuditProperty is the longest property the audit log indexes for
+// filtering. Longer ones still appear in the payload and diff.
+const maxAuditProperty = 255
+
+// AuditOperations are the operations the audit log records, named like
+// their endpoints.
+var AuditOperations = []string{
+	"init", "feedback", "reset", "prop/add", "prop/remove", "prop/move", "prop/rename", "classes/rename",
+	"clone", "import", "claim", "delete",
+}
+
+// auditOp describes a change for the audit log: the operation, named like
+// its endpoint, the request payload and the properties it names.
+type auditOp struct {
+	name       string
+	payload    any
+	properties []string
+}
+
+// auditEntry describes the change from before to after for the workspace's
+// audit log. It goes into the commit of that change, which fails rather
+// than saving a change the log would miss.
+func (u *userService) auditEntry(ctx context.Context, op auditOp, before, after repository.State) (*repository.AuditEntry, error) {
+	d := diffStates(before, after)
+	e := repository.AuditEntry{
+		WorkspaceID: u.workspaceID,
+		Actor:       u.actor.UserID,
+		Via:         u.actor.Via,
+		RequestID:   requestID(ctx),
+		Operation:   op.name,
+		At:          time.Now().UTC(),
+	}
+	var err error
+	if op.payload != nil {
+		if e.Payload, err = json.Marshal(op.payload); err != nil {
+			return nil, fmt.Errorf("audit %s: %w", op.name, err)
+		}
+	}
+	if e.Diff, err = json.Marshal(d); err != nil {
+		return nil, fmt.Errorf("audit %s: %w", op.name, err)
+	}
+	for _, p := range unique(append(slices.Clone(op.properties), d.properties()...)) {
+		if utf8.RuneCountInString(p) <= maxAuditProperty {
+			e.Properties = append(e.Properties, p)
+		}
+	}
+	slices.Sort(e.Properties)
+	return &e, nil
+}
+
+// DefaultAuditLimit and MaxAuditLimit bound a page of the audit log.
+const (
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+// AuditPage is a page of an audit log, newest first. Next, when set, is the
+// Before of the following page.
+type AuditPage struct {
+	Entries []repository.AuditEntry `json:"entries"`
+	Next    int64                   `json:"next,omitempty"`
+}
+
+// Audit returns a page of the audit log of a workspace the user owns, given
+// or default. q's WorkspaceID is replaced by the resolved one.
+func (w *Workspaces) Audit(ctx context.Context, userID, id string, q repository.AuditQuery) (AuditPage, error) {
+	a, err := w.Resolve(ctx, userID, id, RoleOwner)
+	if err != nil {
+		return AuditPage{}, err
+	}
+	return w.audit(ctx, a.ID, q)
+}
+
+// KeyAudit is Audit for a request authenticated by an API key.
+func (w *Workspaces) KeyAudit(ctx context.Context, k repository.APIKey, id string, q repository.AuditQuery) (AuditPage, error) {
+	a, err := w.KeyAccess(ctx, k, id, RoleOwner)
+	if err != nil {
+		return AuditPage{}, err
+	}
+	return w.audit(ctx, a.ID, q)
+}
+
+func (w *Workspaces) audit(ctx context.Context, workspaceID string, q repository.AuditQuery) (AuditPage, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultAuditLimit
+	}
+	limit = min(limit, MaxAuditLimit)
+	// Reading one more than asked for tells whether another page follows.
+	q.WorkspaceID, q.Actor, q.Limit = workspaceID, "", limit+1
+	es, err := w.repo.ListAudit(ctx, q)
+	if err != nil {
+		return AuditPage{}, err
+	}
+	p := AuditPage{Entries: nonNil(es)}
+	if len(es) > limit {
+		p.Entries = es[:limit]
+		p.Next = es[limit-1].ID
+	}
+	return p, nil
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func TestWorkspaces_AuditRecordsEveryChange(t *testing.T) {
+	ctx := WithRequestID(context.Background(), "req-1")
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+	mine, err := ws.Default(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := ws.ClassifierAs(mine.ID, Actor{UserID: "alice", Via: "token"})
+
+	steps := []struct {
+		op  string
+		run func() error
+	}{
+		{"init", func() error {
+			return svc.Init(ctx, models.Class{Name: "Cat", Properties: []string{"fur"}}, models.Class{Name: "Owl", Properties: []string{"wings"}})
+		}},
+		{"feedback", func() error { return svc.Feedback(ctx, "class1", []string{"whiskers"}) }},
+		{"prop/add", func() error { return svc.AddProperty(ctx, "general", "eyes") }},
+		{"prop/move", func() error { return svc.MoveProperty(ctx, "class1", "class2", "fur") }},
+		{"prop/rename", func() error { return svc.RenameProperty(ctx, "class2", "fur", "feathers") }},
+		{"prop/remove", func() error { return svc.RemoveProperty(ctx, "general", "eyes") }},
+		{"classes/rename", func() error { return svc.RenameClass(ctx, "class2", "Hawk") }},
+		{"reset", func() error { return svc.Reset(ctx) }},
+	}
+	for _, s := range steps {
+		if err := s.run(); err != nil {
+			t.Fatalf("%s: %v", s.op, err)
+		}
+	}
+
+	page, err := ws.Audit(ctx, "alice", mine.ID, repository.AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != len(steps) {
+		t.Fatalf("entries=%+v", page.Entries)
+	}
+	byOp := map[string]repository.AuditEntry{}
+	for i, e := range page.Entries {
+		if want := steps[len(steps)-1-i].op; e.Operation != want {
+			t.Fatalf("entry %d is %q; want %q", i, e.Operation, want)
+		}
+		if e.Actor != "alice" || e.Via != "token" || e.RequestID != "req-1" || e.WorkspaceID != mine.ID {
+			t.Fatalf("entry=%+v", e)
+		}
+		byOp[e.Operation] = e
+	}
+
+	diffOf := func(op string) AuditDiff {
+		t.Helper()
+		var d AuditDiff
+		if err := json.Unmarshal(byOp[op].Diff, &d); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	if d := diffOf("prop/move"); !reflect.DeepEqual(d, AuditDiff{
+		Added: map[string][]string{"class2": {"fur"}}, Removed: map[string][]string{"class1": {"fur"}},
+	}) {
+		t.Fatalf("move diff=%+v", d)
+	}
+	if d := diffOf("classes/rename"); !reflect.DeepEqual(d, AuditDiff{Classes: map[string]ClassRename{"class2": {From: "Owl", To: "Hawk"}}}) {
+		t.Fatalf("rename diff=%+v", d)
+	}
+	if d := diffOf("reset"); d.Classes["class1"].From != "Cat" || !reflect.DeepEqual(d.Removed["class2"], []string{"feathers", "wings"}) {
+		t.Fatalf("reset diff=%+v", d)
+	}
+	var payload models.MovePropertyRequest
+	if err := json.Unmarshal(byOp["prop/move"].Payload, &payload); err != nil || payload != (models.MovePropertyRequest{From: "class1", To: "class2", Property: "fur"}) {
+		t.Fatalf("move payload=%s err=%v", byOp["prop/move"].Payload, err)
+	}
+	if got := byOp["prop/rename"].Properties; !reflect.DeepEqual(got, []string{"feathers", "fur"}) {
+		t.Fatalf("rename properties=%v", got)
+	}
+
+	page, err = ws.Audit(ctx, "alice", mine.ID, repository.AuditQuery{Property: "fur"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for _, e := range page.Entries {
+		ops = append(ops, e.Operation)
+	}
+	if !reflect.DeepEqual(ops, []string{"prop/rename", "prop/move", "init"}) {
+		t.Fatalf("entries naming fur=%v", ops)
+	}
+
+	if _, err := ws.Share(ctx, "alice", mine.ID, "bob", RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.Audit(ctx, "bob", mine.ID, repository.AuditQuery{}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("editor reading audit: err=%v; want ErrForbidden", err)
+	}
+}
+
+func TestWorkspaces_EraseRedactsAuditEntries(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+	theirs, err := ws.Create(ctx, "bob", "bob's")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.ClassifierAs(theirs.ID, Actor{UserID: "alice"}).AddProperty(ctx, "general", "fur"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ws.Export(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := data.Counts(); c.AuditEntries != 1 || data.AuditEntries[0].WorkspaceID != theirs.ID {
+		t.Fatalf("export=%+v", data)
+	}
+	rep, err := ws.Erase(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.Verified {
+		t.Fatalf("report=%+v", rep)
+	}
+	page, err := ws.Audit(ctx, "bob", theirs.ID, repository.AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Actor != "" || page.Entries[0].Operation != "prop/add" {
+		t.Fatalf("bob's audit after erasing alice=%+v", page.Entries)
+	}
+}
+
+func TestWorkspaces_AuditRecordsNewWorkspaces(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+	cat, owl := models.Class{Name: "Cat", Properties: []string{"fur"}}, models.Class{Name: "Owl", Properties: []string{"wings"}}
+
+	src, err := ws.Default(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Classifier(src.ID).Init(ctx, cat, owl); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := ws.Import(ctx, "alice", "", models.Snapshot{Class1: cat, Class2: owl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloned, err := ws.Clone(ctx, "alice", src.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const visitor = "anon_00112233445566778899aabbccddeeff"
+	anonCtx := WithAnonymous(ctx)
+	for _, name := range []string{"", "extra"} {
+		var w repository.Workspace
+		if name == "" {
+			w, err = ws.Default(anonCtx, visitor)
+		} else {
+			w, err = ws.Create(anonCtx, visitor, name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ws.Classifier(w.ID).Init(ctx, cat, owl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	claimed, err := ws.ClaimAnonymous(ctx, "bob", visitor, ClaimAdopt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 2 {
+		t.Fatalf("claimed=%+v", claimed)
+	}
+
+	for _, c := range []struct {
+		workspace, op, actor string
+	}{
+		{imported.ID, "import", "alice"},
+		{cloned.ID, "clone", "alice"},
+		{claimed[0].ID, "claim", "bob"},
+		{claimed[1].ID, "claim", "bob"},
+	} {
+		es, err := repo.ListAudit(ctx, repository.AuditQuery{WorkspaceID: c.workspace})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(es) == 0 || es[0].Operation != c.op || es[0].Actor != c.actor {
+			t.Fatalf("%s entries=%+v; want %s by %s", c.workspace, es, c.op, c.actor)
+		}
+		var d AuditDiff
+		if err := json.Unmarshal(es[0].Diff, &d); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(d.Added["class1"], []string{"fur"}) {
+			t.Fatalf("%s %s diff=%+v", c.workspace, c.op, d)
+		}
+	}
+}
+
+func TestWorkspaces_AuditOutlivesDelete(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+	var owned [2]repository.Workspace
+	for i, name := range []string{"triage", "spare"} {
+		w, err := ws.Create(ctx, "alice", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ws.Classifier(w.ID).Init(ctx, models.Class{Name: "Cat", Properties: []string{"fur"}}, models.Class{Name: "Owl"}); err != nil {
+			t.Fatal(err)
+		}
+		owned[i] = w
+	}
+
+	if err := ws.Delete(ctx, "alice", owned[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	es, err := repo.ListAudit(ctx, repository.AuditQuery{WorkspaceID: owned[0].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 || es[0].Operation != "delete" || es[0].Actor != "alice" || es[1].Operation != "init" {
+		t.Fatalf("audit after delete=%+v; want delete then init", es)
+	}
+	var d AuditDiff
+	if err := json.Unmarshal(es[0].Diff, &d); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d.Removed["class1"], []string{"fur"}) {
+		t.Fatalf("delete diff=%+v", d)
+	}
+
+	// Erasing the owner purges the log of the workspaces they still own.
+	data, err := ws.Export(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.Erase(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+	if es, _ := repo.ListAudit(ctx, repository.AuditQuery{WorkspaceID: owned[1].ID}); len(es) != 0 {
+		t.Fatalf("audit after erase=%+v", es)
+	}
+}
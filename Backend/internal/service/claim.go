@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+// ClaimAction is what a user who signs in does with the state they built as
+// an anonymous visitor.
+type ClaimAction string
+
+const (
+	// ClaimAdopt replaces the user's default workspace state with the
+	// visitor's.
+	ClaimAdopt ClaimAction = "adopt"
+	// ClaimMerge folds the visitor's state into the user's, see mergeStates.
+	ClaimMerge ClaimAction = "merge"
+	// ClaimDiscard drops the visitor's state.
+	ClaimDiscard ClaimAction = "discard"
+)
+
+var ErrInvalidClaim = errors.New("action must be adopt, merge or discard")
+
+// Unclaimed is what an anonymous session would hand over: its workspaces,
+// default first, and the default workspace's state.
+type Unclaimed struct {
+	Workspaces []repository.Workspace `json:"workspaces"`
+	State      models.Snapshot        `json:"state"`
+}
+
+func (w *Workspaces) Unclaimed(ctx context.Context, anonID string) (Unclaimed, error) {
+	list, key, err := w.anonymousWorkspaces(ctx, anonID)
+	if err != nil {
+		return Unclaimed{}, err
+	}
+	snap, err := NewWorkspaceService(w.repo, key).Snapshot(ctx)
+	if err != nil {
+		return Unclaimed{}, err
+	}
+	if list == nil {
+		list = []repository.Workspace{}
+	}
+	return Unclaimed{Workspaces: list, State: snap}, nil
+}
+
+// ClaimAnonymous hands the state of the anonymous session anonID to userID
+// and then deletes everything the session owned, including state kept under
+// the bare session ID. Adopting or merging applies to the session's default
+// workspace; its other workspaces become new workspaces of the user. It
+// returns the user's workspaces that received state. A claim that failed
+// part way can be retried: adopting and merging the same state again changes
+// nothing, and workspaces already copied are not copied twice.
+func (w *Workspaces) ClaimAnonymous(ctx context.Context, userID, anonID string, action ClaimAction) ([]repository.Workspace, error) {
+	switch action {
+	case ClaimAdopt, ClaimMerge, ClaimDiscard:
+	default:
+		return nil, ErrInvalidClaim
+	}
+	if anonID == "" || anonID == userID {
+		return nil, nil
+	}
+	list, key, err := w.anonymousWorkspaces(ctx, anonID)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []repository.Workspace
+	if action != ClaimDiscard {
+		if out, err = w.takeOver(ctx, userID, key, list, action); err != nil {
+			return nil, err
+		}
+	}
+	// The default workspace goes last: while it exists, a retry still tells
+	// it apart from the others.
+	for i := len(list) - 1; i >= 0; i-- {
+		if err := w.repo.DeleteWorkspace(ctx, list[i].ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return out, w.repo.ResetUser(ctx, anonID)
+}
+
+// anonymousWorkspaces returns the session's workspaces with the default one
+// first, and the key its default state is stored under: the default
+// workspace, or the bare session ID for a visitor from before workspaces or
+// whose default workspace is already gone. Another workspace never stands in
+// for the default one.
+func (w *Workspaces) anonymousWorkspaces(ctx context.Context, anonID string) ([]repository.Workspace, string, error) {
+	list, err := w.repo.ListWorkspaces(ctx, anonID)
+	if err != nil {
+		return nil, "", err
+	}
+	id := defaultWorkspaceID(anonID)
+	for i, ws := range list {
+		if ws.ID == id {
+			copy(list[1:i+1], list[:i])
+			list[0] = ws
+			return list, id, nil
+		}
+	}
+	return list, anonID, nil
+}
+
+func (w *Workspaces) takeOver(ctx context.Context, userID, key string, list []repository.Workspace, action ClaimAction) ([]repository.Workspace, error) {
+	var out []repository.Workspace
+	st, err := w.repo.GetState(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if st.Class1.Name != "" || st.Class2.Name != "" {
+		dst, err := w.Default(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		// Like any other change, this is held to the quotas and applied to
+		// the latest state.
+		svc := &userService{repo: w.repo, workspaceID: dst.ID, quotas: w.Quotas, actor: Actor{UserID: userID}}
+		op := auditOp{name: "claim", payload: map[string]string{"action": string(action)}}
+		_, err = svc.withState(ctx, op, func(ms *memoryService) {
+			next := copyState(st)
+			if action == ClaimMerge {
+				cur := repository.State{Class1: ms.class1, Class2: ms.class2, GeneralClass: ms.generalClass, NoneClass: ms.noneClass}
+				next = mergeStates(cur, st)
+			}
+			ms.class1, ms.class2, ms.generalClass, ms.noneClass = next.Class1, next.Class2, next.GeneralClass, next.NoneClass
+		})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, dst)
+	}
+
+	for _, ws := range list {
+		if ws.ID == key {
+			continue
+		}
+		c, err := w.claimWorkspace(ctx, userID, ws, action)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// claimWorkspace copies an anonymous workspace to userID under an ID derived
+// from both, returning the copy an earlier or concurrent claim already made.
+func (w *Workspaces) claimWorkspace(ctx context.Context, userID string, ws repository.Workspace, action ClaimAction) (repository.Workspace, error) {
+	id := claimedWorkspaceID(userID, ws.ID)
+	switch c, err := w.repo.GetWorkspace(ctx, id); {
+	case err == nil:
+		return c, nil
+	case !errors.Is(err, repository.ErrNotFound):
+		return repository.Workspace{}, err
+	}
+	st, err := w.repo.GetState(ctx, ws.ID)
+	if err != nil {
+		return repository.Workspace{}, err
+	}
+	entry := repository.LineageEntry{Source: repository.LineageWorkspace, WorkspaceID: ws.ID, Name: ws.Name}
+	op := auditOp{name: "claim", payload: map[string]string{"action": string(action)}}
+	c, err := w.createWithState(ctx, id, userID, ws.Name, entry, ws.Lineage, st, op)
+	if errors.Is(err, repository.ErrConflict) {
+		return w.repo.GetWorkspace(ctx, id)
+	}
+	return c, err
+}
+
+// claimedWorkspaceID, like defaultWorkspaceID, must not reveal the IDs it is
+// derived from.
+func claimedWorkspaceID(userID, sourceID string) string {
+	sum := sha256.Sum256([]byte("claimed-workspace:" + userID + "\x00" + sourceID))
+	return "ws_" + hex.EncodeToString(sum[:12])
+}
+
+type area int
+
+const (
+	areaNone area = iota
+	areaClass1
+	areaClass2
+	areaGeneral
+)
+
+// mergeStates unions src into dst. Classes are matched by name, so the same
+// pair trained in the opposite order lines up; otherwise dst keeps its names
+// and src's classes map by position. A property the two sides put in
+// different areas follows the classifier's own rules: evidence for both
+// classes makes it general, and any class outranks none.
+func mergeStates(dst, src repository.State) repository.State {
+	switch {
+	case src.Class1.Name == "" && src.Class2.Name == "":
+		return copyState(dst)
+	case dst.Class1.Name == "" && dst.Class2.Name == "":
+		return copyState(src)
+	}
+	if (src.Class1.Name == dst.Class2.Name || src.Class2.Name == dst.Class1.Name) &&
+		src.Class1.Name != dst.Class1.Name && src.Class2.Name != dst.Class2.Name {
+		src.Class1, src.Class2 = src.Class2, src.Class1
+	}
+
+	areas := make(map[string]area)
+	var order []string
+	for _, st := range []repository.State{dst, src} {
+		byArea := [...][]string{
+			areaNone:    st.NoneClass,
+			areaClass1:  st.Class1.Properties,
+			areaClass2:  st.Class2.Properties,
+			areaGeneral: st.GeneralClass,
+		}
+		for a, props := range byArea {
+			for _, p := range props {
+				prev, seen := areas[p]
+				if !seen {
+					order = append(order, p)
+					areas[p] = area(a)
+					continue
+				}
+				areas[p] = combineAreas(prev, area(a))
+			}
+		}
+	}
+
+	out := repository.State{
+		Class1: models.Class{Name: dst.Class1.Name, Properties: []string{}},
+		Class2: models.Class{Name: dst.Class2.Name, Properties: []string{}},
+	}
+	out.GeneralClass, out.NoneClass = []string{}, []string{}
+	for _, p := range order {
+		switch areas[p] {
+		case areaClass1:
+			out.Class1.Properties = append(out.Class1.Properties, p)
+		case areaClass2:
+			out.Class2.Properties = append(out.Class2.Properties, p)
+		case areaGeneral:
+			out.GeneralClass = append(out.GeneralClass, p)
+		default:
+			out.NoneClass = append(out.NoneClass, p)
+		}
+	}
+	return out
+}
+
+func combineAreas(a, b area) area {
+	switch {
+	case a == b:
+		return a
+	case a == areaNone:
+		return b
+	case b == areaNone:
+		return a
+	}
+	return areaGeneral
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func TestMergeStates(t *testing.T) {
+	dst := repository.State{
+		Class1:       models.Class{Name: "cat", Properties: []string{"purr", "claws"}},
+		Class2:       models.Class{Name: "dog", Properties: []string{"bark"}},
+		GeneralClass: []string{"fur"},
+		NoneClass:    []string{"wheels"},
+	}
+	// The visitor trained the same pair the other way round.
+	src := repository.State{
+		Class1:       models.Class{Name: "dog", Properties: []string{"claws", "fetch"}},
+		Class2:       models.Class{Name: "cat", Properties: []string{"purr", "whiskers", "wheels"}},
+		GeneralClass: []string{"bark"},
+		NoneClass:    []string{"wings"},
+	}
+	got := mergeStates(dst, src)
+	want := repository.State{
+		Class1:       models.Class{Name: "cat", Properties: []string{"purr", "whiskers", "wheels"}},
+		Class2:       models.Class{Name: "dog", Properties: []string{"fetch"}},
+		GeneralClass: []string{"claws", "bark", "fur"},
+		NoneClass:    []string{"wings"},
+	}
+	for _, st := range []*repository.State{&got, &want} {
+		st.Class1.Properties = sortStrings(st.Class1.Properties)
+		st.Class2.Properties = sortStrings(st.Class2.Properties)
+		st.GeneralClass = sortStrings(st.GeneralClass)
+		st.NoneClass = sortStrings(st.NoneClass)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("merged=%+v\nwant  %+v", got, want)
+	}
+
+	if got := mergeStates(repository.State{}, src); got.Class1.Name != "dog" || len(got.Class2.Properties) != 3 {
+		t.Fatalf("merge into empty=%+v", got)
+	}
+	if got := mergeStates(dst, repository.State{}); !reflect.DeepEqual(got, copyState(dst)) {
+		t.Fatalf("merge of empty=%+v", got)
+	}
+}
+
+func TestWorkspaces_ClaimAnonymous(t *testing.T) {
+	ctx := context.Background()
+	setup := func(t *testing.T) (*Workspaces, repository.Repository, repository.Workspace, repository.Workspace) {
+		t.Helper()
+		repo := repository.NewMemory()
+		ws := NewWorkspaces(repo)
+		anon, err := ws.Default(ctx, "visitor")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewWorkspaceService(repo, anon.ID).Init(ctx, models.Class{Name: "Cat", Properties: []string{"purr"}}, models.Class{Name: "Dog"}); err != nil {
+			t.Fatal(err)
+		}
+		extra, err := ws.Create(ctx, "visitor", "birds")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewWorkspaceService(repo, extra.ID).Init(ctx, models.Class{Name: "Owl"}, models.Class{Name: "Hawk"}); err != nil {
+			t.Fatal(err)
+		}
+		mine, err := ws.Default(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewWorkspaceService(repo, mine.ID).Init(ctx, models.Class{Name: "Cat"}, models.Class{Name: "Dog", Properties: []string{"bark"}}); err != nil {
+			t.Fatal(err)
+		}
+		return ws, repo, anon, mine
+	}
+	gone := func(t *testing.T, repo repository.Repository) {
+		t.Helper()
+		if left, _ := repo.ListWorkspaces(ctx, "visitor"); len(left) != 0 {
+			t.Fatalf("anonymous workspaces left: %+v", left)
+		}
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		ws, repo, anon, mine := setup(t)
+		if u, err := ws.Unclaimed(ctx, "visitor"); err != nil || len(u.Workspaces) != 2 || u.Workspaces[0].ID != anon.ID || u.State.Class1.Name != "Cat" {
+			t.Fatalf("unclaimed=%+v err=%v", u, err)
+		}
+		got, err := ws.ClaimAnonymous(ctx, "alice", "visitor", ClaimMerge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].ID != mine.ID || got[1].OwnerID != "alice" || got[1].Name != "birds" {
+			t.Fatalf("claimed=%+v", got)
+		}
+		st, _ := repo.GetState(ctx, mine.ID)
+		if !reflect.DeepEqual(st.Class1.Properties, []string{"purr"}) || !reflect.DeepEqual(st.Class2.Properties, []string{"bark"}) {
+			t.Fatalf("merged state=%+v", st)
+		}
+		if st, _ := repo.GetState(ctx, got[1].ID); st.Class1.Name != "Owl" {
+			t.Fatalf("copied workspace state=%+v", st)
+		}
+		gone(t, repo)
+	})
+
+	t.Run("adopt", func(t *testing.T) {
+		ws, repo, _, mine := setup(t)
+		if _, err := ws.ClaimAnonymous(ctx, "alice", "visitor", ClaimAdopt); err != nil {
+			t.Fatal(err)
+		}
+		st, _ := repo.GetState(ctx, mine.ID)
+		if !reflect.DeepEqual(st.Class1.Properties, []string{"purr"}) || len(st.Class2.Properties) != 0 {
+			t.Fatalf("adopted state=%+v", st)
+		}
+		gone(t, repo)
+	})
+
+	t.Run("discard", func(t *testing.T) {
+		ws, repo, _, mine := setup(t)
+		got, err := ws.ClaimAnonymous(ctx, "alice", "visitor", ClaimDiscard)
+		if err != nil || len(got) != 0 {
+			t.Fatalf("discard=%+v err=%v", got, err)
+		}
+		st, _ := repo.GetState(ctx, mine.ID)
+		if len(st.Class1.Properties) != 0 || !reflect.DeepEqual(st.Class2.Properties, []string{"bark"}) {
+			t.Fatalf("state after discard=%+v", st)
+		}
+		if list, _ := repo.ListWorkspaces(ctx, "alice"); len(list) != 1 {
+			t.Fatalf("alice's workspaces=%+v", list)
+		}
+		gone(t, repo)
+	})
+
+	t.Run("legacy row", func(t *testing.T) {
+		repo := repository.NewMemory()
+		ws := NewWorkspaces(repo)
+		if err := repo.UpsertState(ctx, "visitor", repository.State{Class1: models.Class{Name: "cat"}, Class2: models.Class{Name: "dog"}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ws.ClaimAnonymous(ctx, "alice", "visitor", ClaimAdopt); err != nil {
+			t.Fatal(err)
+		}
+		if st, _ := repo.GetState(ctx, "visitor"); st.Class1.Name != "" {
+			t.Fatalf("anonymous row left: %+v", st)
+		}
+		mine, _ := ws.Default(ctx, "alice")
+		if st, _ := repo.GetState(ctx, mine.ID); st.Class1.Name != "cat" {
+			t.Fatalf("adopted legacy state=%+v", st)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		for _, failing := range []string{"default", "other"} {
+			_, repo, anon, mine := setup(t)
+			flaky := &failingDeletes{Repository: repo, fail: 1, only: anon.ID}
+			if failing == "other" {
+				list, _ := repo.ListWorkspaces(ctx, "visitor")
+				for _, w := range list {
+					if w.ID != anon.ID {
+						flaky.only = w.ID
+					}
+				}
+			}
+			ws := NewWorkspaces(flaky)
+			if _, err := ws.ClaimAnonymous(ctx, "alice", "visitor", ClaimAdopt); !errors.Is(err, errDeleteFailed) {
+				t.Fatalf("%s delete fails: err=%v; want the claim to fail while cleaning up", failing, err)
+			}
+			got, err := ws.ClaimAnonymous(ctx, "alice", "visitor", ClaimAdopt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Workspaces copied and deleted by the first attempt are not
+			// reported again.
+			if len(got) == 0 || got[0].ID != mine.ID {
+				t.Fatalf("%s delete fails: claimed on retry=%+v", failing, got)
+			}
+			if list, _ := repo.ListWorkspaces(ctx, "alice"); len(list) != 2 {
+				t.Fatalf("%s delete fails: alice's workspaces after a retried claim=%+v; want default and one copy", failing, list)
+			}
+			st, _ := repo.GetState(ctx, mine.ID)
+			if st.Class1.Name != "Cat" || !reflect.DeepEqual(st.Class1.Properties, []string{"purr"}) || len(st.Class2.Properties) != 0 {
+				t.Fatalf("%s delete fails: default state after retry=%+v; want the visitor's default", failing, st)
+			}
+			gone(t, repo)
+		}
+	})
+
+	t.Run("default gone", func(t *testing.T) {
+		ws, repo, anon, mine := setup(t)
+		if err := repo.DeleteWorkspace(ctx, anon.ID); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ws.ClaimAnonymous(ctx, "alice", "visitor", ClaimAdopt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Name != "birds" {
+			t.Fatalf("claimed=%+v; want only the copy", got)
+		}
+		if st, _ := repo.GetState(ctx, mine.ID); st.Class1.Name != "Cat" || !reflect.DeepEqual(st.Class2.Properties, []string{"bark"}) {
+			t.Fatalf("another workspace was adopted as the default: %+v", st)
+		}
+		gone(t, repo)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		ws, repo, _, _ := setup(t)
+		if _, err := ws.ClaimAnonymous(ctx, "alice", "visitor", "keep"); !errors.Is(err, ErrInvalidClaim) {
+			t.Fatalf("err=%v", err)
+		}
+		if _, err := ws.ClaimAnonymous(ctx, "visitor", "visitor", ClaimDiscard); err != nil {
+			t.Fatal(err)
+		}
+		if left, _ := repo.ListWorkspaces(ctx, "visitor"); len(left) != 2 {
+			t.Fatalf("claiming one's own session must not delete it: %+v", left)
+		}
+	})
+}
+
+var errDeleteFailed = errors.New("delete failed")
+
+// failingDeletes fails the first fail workspace deletions, of only that
+// workspace if only is set.
+type failingDeletes struct {
+	repository.Repository
+	fail int
+	only string
+}
+
+func (r *failingDeletes) DeleteWorkspace(ctx context.Context, id string) error {
+	if r.fail > 0 && (r.only == "" || r.only == id) {
+		r.fail--
+		return errDeleteFailed
+	}
+	return r.Repository.DeleteWorkspace(ctx, id)
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"unicode/utf8"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+var ErrInvalidExport = errors.New("export must name both classes and list each property in one area only")
+
+// Clone copies the state of a workspace the user can read into a new
+// workspace they own. The copy records where it came from, followed by the
+// source's own lineage; later changes to either side do not affect the other.
+func (w *Workspaces) Clone(ctx context.Context, userID, sourceID, name string) (repository.Workspace, error) {
+	src, err := w.require(ctx, userID, sourceID, RoleViewer)
+	if err != nil {
+		return repository.Workspace{}, err
+	}
+	if name == "" {
+		name = truncateName("Copy of " + src.Name)
+	}
+	st, err := w.repo.GetState(ctx, src.ID)
+	if err != nil {
+		return repository.Workspace{}, err
+	}
+	entry := repository.LineageEntry{
+		Source:      repository.LineageWorkspace,
+		WorkspaceID: src.ID,
+		Name:        src.Name,
+	}
+	op := auditOp{name: "clone", payload: models.CloneRequest{From: src.ID, Name: name}}
+	return w.createWithState(ctx, randomWorkspaceID(), userID, name, entry, src.Lineage, st, op)
+}
+
+// Import creates a workspace from a state previously downloaded from
+// GET /state.
+func (w *Workspaces) Import(ctx context.Context, userID, name string, export models.Snapshot) (repository.Workspace, error) {
+	st, err := stateFromExport(export)
+	if err != nil {
+		return repository.Workspace{}, err
+	}
+	if name == "" {
+		name = truncateName(st.Class1.Name + " vs " + st.Class2.Name)
+	}
+	entry := repository.LineageEntry{Source: repository.LineageUpload}
+	// The diff records the uploaded state, so the payload leaves it out.
+	op := auditOp{name: "import", payload: models.CloneRequest{Name: name}}
+	return w.createWithState(ctx, randomWorkspaceID(), userID, name, entry, nil, st, op)
+}
+
+// createWithState creates the workspace id together with its state and the
+// audit log entry for op, so a failure leaves none of them behind.
+func (w *Workspaces) createWithState(ctx context.Context, id, userID, name string, entry repository.LineageEntry, parent []repository.LineageEntry, st repository.State, op auditOp) (repository.Workspace, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return repository.Workspace{}, err
+	}
+	if _, err := w.Default(ctx, userID); err != nil {
+		return repository.Workspace{}, err
+	}
+	if err := w.checkWorkspaces(ctx, userID); err != nil {
+		return repository.Workspace{}, err
+	}
+	if err := w.Quotas.checkState(Usage{}, 0, st); err != nil {
+		return repository.Workspace{}, err
+	}
+	ws := w.newWorkspace(ctx, id, userID, name)
+	entry.ClonedAt = ws.CreatedAt
+	ws.Lineage = append([]repository.LineageEntry{entry}, parent...)
+
+	svc := &userService{workspaceID: ws.ID, actor: Actor{UserID: userID}}
+	audit, err := svc.auditEntry(ctx, op, repository.State{}, st)
+	if err != nil {
+		return repository.Workspace{}, err
+	}
+	if err := w.repo.CommitState(ctx, repository.StateCommit{ID: ws.ID, State: copyState(st), Create: &ws, Audit: audit}); err != nil {
+		return repository.Workspace{}, err
+	}
+	return ws, nil
+}
+
+// stateFromExport accepts the GET /state format and rejects documents the
+// classifier could not have produced.
+func stateFromExport(s models.Snapshot) (repository.State, error) {
+	st := repository.State{
+		Class1:       models.Class{Name: norm(s.Class1.Name), Properties: unique(s.Class1.Properties)},
+		Class2:       models.Class{Name: norm(s.Class2.Name), Properties: unique(s.Class2.Properties)},
+		GeneralClass: unique(s.GeneralClass),
+		NoneClass:    unique(s.NoneClass),
+	}
+	if st.Class1.Name == "" || st.Class2.Name == "" {
+		return repository.State{}, ErrInvalidExport
+	}
+	seen := make(map[string]struct{})
+	for _, area := range [][]string{st.Class1.Properties, st.Class2.Properties, st.GeneralClass, st.NoneClass} {
+		for _, p := range area {
+			if contains(seen, p) {
+				return repository.State{}, ErrInvalidExport
+			}
+			seen[p] = struct{}{}
+		}
+	}
+	return st, nil
+}
+
+func copyState(st repository.State) repository.State {
+	return repository.State{
+		Class1:       models.Class{Name: st.Class1.Name, Properties: append([]string(nil), st.Class1.Properties...)},
+		Class2:       models.Class{Name: st.Class2.Name, Properties: append([]string(nil), st.Class2.Properties...)},
+		GeneralClass: append([]string(nil), st.GeneralClass...),
+		NoneClass:    append([]string(nil), st.NoneClass...),
+	}
+}
+
+// truncateName keeps a generated default name within the name limit.
+func truncateName(name string) string {
+	for utf8.RuneCountInString(name) > maxWorkspaceName {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
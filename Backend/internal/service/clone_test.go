@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func TestWorkspaces_CloneCopiesStateAndLineage(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+
+	src, err := ws.Create(ctx, "alice", "spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewWorkspaceService(repo, src.ID).Init(ctx, models.Class{Name: "Spam", Properties: []string{"offer"}}, models.Class{Name: "Ham"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ws.Clone(ctx, "bob", src.ID, ""); !errors.Is(err, ErrWorkspaceNotFound) {
+		t.Fatalf("clone without access err=%v", err)
+	}
+	if _, err := ws.Share(ctx, "alice", src.ID, "bob", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	first, err := ws.Clone(ctx, "bob", src.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.OwnerID != "bob" || first.Name != "Copy of spam" {
+		t.Fatalf("clone=%+v", first)
+	}
+	if len(first.Lineage) != 1 || first.Lineage[0].WorkspaceID != src.ID || first.Lineage[0].Source != repository.LineageWorkspace {
+		t.Fatalf("lineage=%+v", first.Lineage)
+	}
+
+	// Training the copy leaves the source untouched.
+	if err := NewWorkspaceService(repo, first.ID).Feedback(ctx, "class1", []string{"winner"}); err != nil {
+		t.Fatal(err)
+	}
+	orig, _ := repo.GetState(ctx, src.ID)
+	if len(orig.Class1.Properties) != 1 {
+		t.Fatalf("source changed after training the clone: %+v", orig.Class1)
+	}
+
+	second, err := ws.Clone(ctx, "bob", first.ID, "mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Lineage) != 2 || second.Lineage[0].WorkspaceID != first.ID || second.Lineage[1].WorkspaceID != src.ID {
+		t.Fatalf("nested lineage=%+v", second.Lineage)
+	}
+	st, _ := repo.GetState(ctx, second.ID)
+	if st.Class1.Name != "Spam" || len(st.Class1.Properties) != 2 {
+		t.Fatalf("cloned state=%+v", st)
+	}
+}
+
+func TestWorkspaces_Import(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+
+	got, err := ws.Import(ctx, "bob", "", models.Snapshot{
+		Class1:       models.Class{Name: "Cat", Properties: []string{"purr", " purr "}},
+		Class2:       models.Class{Name: "Dog", Properties: []string{"bark"}},
+		GeneralClass: []string{"fur"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Cat vs Dog" || len(got.Lineage) != 1 || got.Lineage[0].Source != repository.LineageUpload {
+		t.Fatalf("imported=%+v", got)
+	}
+	st, _ := repo.GetState(ctx, got.ID)
+	if len(st.Class1.Properties) != 1 || st.GeneralClass[0] != "fur" {
+		t.Fatalf("imported state=%+v", st)
+	}
+
+	for _, bad := range []models.Snapshot{
+		{Class1: models.Class{Name: "Cat"}},
+		{Class1: models.Class{Name: "Cat", Properties: []string{"fur"}}, Class2: models.Class{Name: "Dog"}, NoneClass: []string{"fur"}},
+	} {
+		if _, err := ws.Import(ctx, "bob", "", bad); !errors.Is(err, ErrInvalidExport) {
+			t.Fatalf("Import(%+v) err=%v", bad, err)
+		}
+	}
+}
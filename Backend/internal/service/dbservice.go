@@ -1,29 +1,36 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"log"
+	"slices"
 
 	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
 	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
 )
 
 type userService struct {
-	repo   repository.Repository
-	userID string
+	repo        repository.Repository
+	workspaceID string
+	quotas      Quotas
+	actor       Actor
 }
 
-func NewUserService(repo repository.Repository, userID string) Service {
-	return &userService{repo: repo, userID: userID}
+func NewWorkspaceService(repo repository.Repository, workspaceID string) Service {
+	return &userService{repo: repo, workspaceID: workspaceID}
 }
 
-func (u *userService) withState(fn func(*memoryService)) (models.Snapshot, error) {
-
-	st, err := u.repo.GetState(u.userID)
+func (u *userService) load(ctx context.Context) (*memoryService, error) {
+	st, err := u.repo.GetState(ctx, u.workspaceID)
 	if err != nil {
-		log.Printf("[user=%s] load state error: %v", u.userID, err)
-		return models.Snapshot{}, err
+		log.Printf("[workspace=%s] load state error: %v", u.workspaceID, err)
+		return nil, err
 	}
+	return memoryFrom(st), nil
+}
 
+func memoryFrom(st repository.State) *memoryService {
 	mem := &memoryService{}
 	if st.Class1.Name != "" || st.Class2.Name != "" {
 		mem.class1 = st.Class1
@@ -31,6 +38,49 @@ func (u *userService) withState(fn func(*memoryService)) (models.Snapshot, error
 		mem.generalClass = st.GeneralClass
 		mem.noneClass = st.NoneClass
 	}
+	return mem
+}
+
+func (u *userService) view(ctx context.Context, fn func(*memoryService)) (models.Snapshot, error) {
+	mem, err := u.load(ctx)
+	if err != nil {
+		return models.Snapshot{}, err
+	}
+	fn(mem)
+	return mem.snapshot(), nil
+}
+
+// maxCommitAttempts bounds how often a change is retried after losing a race
+// with a concurrent writer, possibly on another replica.
+const maxCommitAttempts = 5
+
+// withState applies fn to the stored state and saves the result together
+// with its audit log entry as op. The state is read from the backing store rather
+// than a cache and written back only if nobody wrote it in between;
+// otherwise fn is applied again to the newer state.
+func (u *userService) withState(ctx context.Context, op auditOp, fn func(*memoryService)) (models.Snapshot, error) {
+	for attempt := 1; ; attempt++ {
+		snap, err := u.tryState(ctx, op, fn)
+		if errors.Is(err, repository.ErrStale) && attempt < maxCommitAttempts {
+			continue
+		}
+		if err != nil {
+			log.Printf("[workspace=%s] save state error: %v", u.workspaceID, err)
+		}
+		return snap, err
+	}
+}
+
+func (u *userService) tryState(ctx context.Context, op auditOp, fn func(*memoryService)) (models.Snapshot, error) {
+	st, version, err := u.repo.LoadState(ctx, u.workspaceID)
+	if err != nil {
+		return models.Snapshot{}, err
+	}
+	mem := memoryFrom(st)
+	// fn may edit the loaded slices in place, so measure and copy them first.
+	before := repository.State{Class1: mem.class1, Class2: mem.class2, GeneralClass: mem.generalClass, NoneClass: mem.noneClass}
+	beforeUsage, beforeLong := usageOf(before), u.quotas.tooLong(before)
+	before = cloneState(before)
 
 	fn(mem)
 
@@ -40,30 +90,52 @@ func (u *userService) withState(fn func(*memoryService)) (models.Snapshot, error
 		GeneralClass: mem.generalClass,
 		NoneClass:    mem.noneClass,
 	}
-	if err := u.repo.UpsertState(u.userID, newState); err != nil {
-		log.Printf("[user=%s] save state error: %v", u.userID, err)
+	if err := u.quotas.checkState(beforeUsage, beforeLong, newState); err != nil {
 		return models.Snapshot{}, err
 	}
-	return mem.Snapshot(), nil
+	audit, err := u.auditEntry(ctx, op, before, newState)
+	if err != nil {
+		return models.Snapshot{}, err
+	}
+	c := repository.StateCommit{ID: u.workspaceID, Version: version, State: newState, Audit: audit}
+	if err := u.repo.CommitState(ctx, c); err != nil {
+		return models.Snapshot{}, err
+	}
+	return mem.snapshot(), nil
 }
 
-func (u *userService) Init(c1, c2 models.Class) {
-	_, _ = u.withState(func(ms *memoryService) { ms.Init(c1, c2) })
+func cloneState(st repository.State) repository.State {
+	st.Class1.Properties = slices.Clone(st.Class1.Properties)
+	st.Class2.Properties = slices.Clone(st.Class2.Properties)
+	st.GeneralClass = slices.Clone(st.GeneralClass)
+	st.NoneClass = slices.Clone(st.NoneClass)
+	return st
+}
+
+func (u *userService) Init(ctx context.Context, c1, c2 models.Class) error {
+	op := auditOp{
+		name:       "init",
+		payload:    models.InitRequest{Class1: c1, Class2: c2},
+		properties: union(c1.Properties, c2.Properties),
+	}
+	_, err := u.withState(ctx, op, func(ms *memoryService) { _ = ms.Init(ctx, c1, c2) })
+	return err
 }
 
-func (u *userService) Classify(props []string) models.ClassifyResponse {
+func (u *userService) Classify(ctx context.Context, props []string) (models.ClassifyResponse, error) {
 	var out models.ClassifyResponse
-	_, _ = u.withState(func(ms *memoryService) { out = ms.Classify(props) })
-	return out
+	_, err := u.view(ctx, func(ms *memoryService) { out, _ = ms.Classify(ctx, props) })
+	return out, err
 }
 
-func (u *userService) Feedback(variant string, props []string) {
-	_, _ = u.withState(func(ms *memoryService) { ms.Feedback(variant, props) })
+func (u *userService) Feedback(ctx context.Context, variant string, props []string) error {
+	op := auditOp{name: "feedback", payload: models.FeedbackRequest{Variant: variant, Properties: props}, properties: props}
+	_, err := u.withState(ctx, op, func(ms *memoryService) { _ = ms.Feedback(ctx, variant, props) })
+	return err
 }
 
-func (u *userService) Snapshot() models.Snapshot {
-	snap, _ := u.withState(func(ms *memoryService) { /* no-op */ })
-	return snap
+func (u *userService) Snapshot(ctx context.Context) (models.Snapshot, error) {
+	return u.view(ctx, func(ms *memoryService) { /* no-op */ })
 }
 
 var _ Service = (*userService)(nil)
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+var (
+	ErrLinkNotFound  = errors.New("share link not found")
+	ErrLinkGone      = errors.New("share link expired or was revoked")
+	ErrInvalidExpiry = errors.New("expiresAt must be in the future")
+)
+
+// CreateLink makes a read-only share link for the workspace. The token is
+// returned only here; the store keeps its hash.
+func (w *Workspaces) CreateLink(ctx context.Context, userID, id string, expiresAt *time.Time) (repository.ShareLink, string, error) {
+	if _, err := w.require(ctx, userID, id, RoleOwner); err != nil {
+		return repository.ShareLink{}, "", err
+	}
+	now := w.timestamp()
+	if expiresAt != nil {
+		at := expiresAt.UTC().Truncate(time.Microsecond)
+		if !at.After(now) {
+			return repository.ShareLink{}, "", ErrInvalidExpiry
+		}
+		expiresAt = &at
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return repository.ShareLink{}, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	idBytes := make([]byte, 8)
+	_, _ = rand.Read(idBytes)
+
+	l := repository.ShareLink{
+		ID:          "lnk_" + hex.EncodeToString(idBytes),
+		WorkspaceID: id,
+		TokenHash:   hashToken(token),
+		CreatedBy:   userID,
+		CreatedAt:   now,
+		ExpiresAt:   expiresAt,
+	}
+	if err := w.repo.CreateShareLink(ctx, l); err != nil {
+		return repository.ShareLink{}, "", notFound(err)
+	}
+	return l, token, nil
+}
+
+func (w *Workspaces) Links(ctx context.Context, userID, id string) ([]repository.ShareLink, error) {
+	if _, err := w.require(ctx, userID, id, RoleOwner); err != nil {
+		return nil, err
+	}
+	return w.repo.ListShareLinks(ctx, id)
+}
+
+func (w *Workspaces) RevokeLink(ctx context.Context, userID, id, linkID string) error {
+	if _, err := w.require(ctx, userID, id, RoleOwner); err != nil {
+		return err
+	}
+	err := w.repo.RevokeShareLink(ctx, id, linkID, w.timestamp())
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrLinkNotFound
+	}
+	return err
+}
+
+// ResolveLink returns the workspace a share token grants read access to.
+func (w *Workspaces) ResolveLink(ctx context.Context, token string) (repository.Workspace, error) {
+	l, err := w.repo.GetShareLinkByHash(ctx, hashToken(token))
+	if errors.Is(err, repository.ErrNotFound) {
+		return repository.Workspace{}, ErrLinkNotFound
+	}
+	if err != nil {
+		return repository.Workspace{}, err
+	}
+	if l.RevokedAt != nil || (l.ExpiresAt != nil && !w.now().Before(*l.ExpiresAt)) {
+		return repository.Workspace{}, ErrLinkGone
+	}
+	ws, err := w.repo.GetWorkspace(ctx, l.WorkspaceID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return repository.Workspace{}, ErrLinkNotFound
+	}
+	if err != nil {
+		return repository.Workspace{}, err
+	}
+	w.touch(ctx, ws)
+	return ws, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
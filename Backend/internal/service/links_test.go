@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func TestWorkspaces_LinkExpiry(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWorkspaces(repository.NewMemory())
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ws.now = func() time.Time { return now }
+
+	w, err := ws.Create(ctx, "owner", "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := now.Add(time.Hour)
+	link, token, err := ws.CreateLink(ctx, "owner", w.ID, &expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.TokenHash == token || link.TokenHash != hashToken(token) {
+		t.Fatalf("token must be stored hashed")
+	}
+
+	got, err := ws.ResolveLink(ctx, token)
+	if err != nil || got.ID != w.ID {
+		t.Fatalf("resolve=%+v err=%v", got, err)
+	}
+	now = expires
+	if _, err := ws.ResolveLink(ctx, token); !errors.Is(err, ErrLinkGone) {
+		t.Fatalf("expired link: err=%v; want ErrLinkGone", err)
+	}
+
+	if err := ws.Delete(ctx, "owner", w.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.ResolveLink(ctx, token); !errors.Is(err, ErrLinkNotFound) {
+		t.Fatalf("link of deleted workspace: err=%v; want ErrLinkNotFound", err)
+	}
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
@@ -106,12 +107,13 @@ func TestExplain(t *testing.T) {
 }
 
 func TestMemoryService_Init_Classify_Feedback_Snapshot(t *testing.T) {
+	ctx := context.Background()
 	ms := NewMemoryService().(*memoryService)
-	ms.Init(
+	ms.Init(ctx,
 		models.Class{Name: "Cat", Properties: []string{"whiskers", "purr", "whiskers"}},
 		models.Class{Name: "Dog", Properties: []string{"bark", "tail", "whiskers"}},
 	)
-	snap := ms.Snapshot()
+	snap, _ := ms.Snapshot(ctx)
 	if !reflect.DeepEqual(snap.GeneralClass, []string{"whiskers"}) {
 		t.Fatalf("general=%v; want [whiskers]", snap.GeneralClass)
 	}
@@ -119,23 +121,23 @@ func TestMemoryService_Init_Classify_Feedback_Snapshot(t *testing.T) {
 		t.Fatalf("whiskers must not remain in class properties")
 	}
 
-	resp := ms.Classify([]string{"purr"})
+	resp, _ := ms.Classify(ctx, []string{"purr"})
 	if resp.Guess != "Cat" {
 		t.Fatalf("guess=%q; want Cat", resp.Guess)
 	}
 
-	ms.Feedback("none", []string{"purr", "new_unknown"})
-	snap = ms.Snapshot()
+	ms.Feedback(ctx, "none", []string{"purr", "new_unknown"})
+	snap, _ = ms.Snapshot(ctx)
 	if !reflect.DeepEqual(snap.NoneClass, []string{"new_unknown"}) {
 		t.Fatalf("none=%v; want [new_unknown]", snap.NoneClass)
 	}
-	ms.Feedback("class2", []string{"tail", "fur"})
-	snap = ms.Snapshot()
+	ms.Feedback(ctx, "class2", []string{"tail", "fur"})
+	snap, _ = ms.Snapshot(ctx)
 	if !contains(toSet(snap.Class2.Properties), "tail") || !contains(toSet(snap.Class2.Properties), "fur") {
 		t.Fatalf("class2 props missing: %v", snap.Class2.Properties)
 	}
-	ms.Feedback("class1", []string{"purr"})
-	snap2 := ms.Snapshot()
+	ms.Feedback(ctx, "class1", []string{"purr"})
+	snap2, _ := ms.Snapshot(ctx)
 	if !contains(toSet(snap2.Class1.Properties), "purr") {
 		t.Fatalf("class1 must include purr")
 	}
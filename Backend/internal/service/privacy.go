@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+// UserData is everything stored about one user ID, as returned by Export.
+// A store that keeps rows per user must be gathered here and cleared in
+// Erase, so that privacy requests stay complete.
+type UserData struct {
+	UserID     string          `json:"userId"`
+	Workspaces []WorkspaceData `json:"workspaces"`
+	// SharedWithMe lists the grants other owners gave the user.
+	SharedWithMe []repository.Grant  `json:"sharedWithMe"`
+	APIKeys      []repository.APIKey `json:"apiKeys"`
+	// LegacyState is state saved under the bare user ID before workspaces
+	// existed and not yet moved into a workspace.
+	LegacyState *models.Snapshot `json:"legacyState,omitempty"`
+	// AuditEntries are the changes the user made, in any workspace, newest
+	// first.
+	AuditEntries []repository.AuditEntry `json:"auditEntries"`
+}
+
+// WorkspaceData is a workspace the user owns with everything kept for it.
+type WorkspaceData struct {
+	repository.Workspace
+	State      models.Snapshot        `json:"state"`
+	Grants     []repository.Grant     `json:"grants"`
+	ShareLinks []repository.ShareLink `json:"shareLinks"`
+}
+
+// DataCounts summarises a UserData.
+type DataCounts struct {
+	Workspaces   int `json:"workspaces"`
+	SharedWithMe int `json:"sharedWithMe"`
+	APIKeys      int `json:"apiKeys"`
+	LegacyStates int `json:"legacyStates"`
+	AuditEntries int `json:"auditEntries"`
+}
+
+func (d UserData) Counts() DataCounts {
+	c := DataCounts{
+		Workspaces: len(d.Workspaces), SharedWithMe: len(d.SharedWithMe),
+		APIKeys: len(d.APIKeys), AuditEntries: len(d.AuditEntries),
+	}
+	if d.LegacyState != nil {
+		c.LegacyStates = 1
+	}
+	return c
+}
+
+// Encode is how exports are written out, as downloads and archives.
+func (d UserData) Encode() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+// Digest is the SHA-256 of Encode, so an erasure report can be matched to the
+// export taken before it.
+func (d UserData) Digest() (string, error) {
+	b, err := d.Encode()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Export gathers everything stored about the user. Unlike List it creates
+// nothing, so exporting an unknown user returns empty data.
+func (w *Workspaces) Export(ctx context.Context, userID string) (UserData, error) {
+	d := UserData{UserID: userID, Workspaces: []WorkspaceData{}}
+	owned, err := w.repo.ListWorkspaces(ctx, userID)
+	if err != nil {
+		return UserData{}, err
+	}
+	for _, ws := range owned {
+		wd := WorkspaceData{Workspace: ws}
+		if wd.State, err = NewWorkspaceService(w.repo, ws.ID).Snapshot(ctx); err != nil {
+			return UserData{}, err
+		}
+		if wd.Grants, err = w.repo.ListGrants(ctx, ws.ID); err != nil {
+			return UserData{}, err
+		}
+		if wd.ShareLinks, err = w.repo.ListShareLinks(ctx, ws.ID); err != nil {
+			return UserData{}, err
+		}
+		wd.Grants, wd.ShareLinks = nonNil(wd.Grants), nonNil(wd.ShareLinks)
+		d.Workspaces = append(d.Workspaces, wd)
+	}
+	if d.SharedWithMe, err = w.repo.ListGrantsFor(ctx, userID); err != nil {
+		return UserData{}, err
+	}
+	if d.APIKeys, err = w.repo.ListAPIKeys(ctx, userID); err != nil {
+		return UserData{}, err
+	}
+	if d.LegacyState, err = w.legacyState(ctx, userID); err != nil {
+		return UserData{}, err
+	}
+	if d.AuditEntries, err = w.auditBy(ctx, userID); err != nil {
+		return UserData{}, err
+	}
+	d.SharedWithMe, d.APIKeys = nonNil(d.SharedWithMe), nonNil(d.APIKeys)
+	return d, nil
+}
+
+// auditBy pages through every audit entry the user is the actor of.
+func (w *Workspaces) auditBy(ctx context.Context, userID string) ([]repository.AuditEntry, error) {
+	out := []repository.AuditEntry{}
+	q := repository.AuditQuery{Actor: userID, Limit: MaxAuditLimit}
+	for {
+		page, err := w.repo.ListAudit(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, page...)
+		if len(page) < q.Limit {
+			return out, nil
+		}
+		q.Before = page[len(page)-1].ID
+	}
+}
+
+// nonNil makes empty lists encode as [] rather than null.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+// legacyState returns state kept under the bare user ID, if any. As in
+// adoptLegacyState, an ID that names a workspace is not the user's.
+func (w *Workspaces) legacyState(ctx context.Context, userID string) (*models.Snapshot, error) {
+	switch _, err := w.repo.GetWorkspace(ctx, userID); {
+	case err == nil:
+		return nil, nil
+	case !errors.Is(err, repository.ErrNotFound):
+		return nil, err
+	}
+	snap, err := NewWorkspaceService(w.repo, userID).Snapshot(ctx)
+	if err != nil || snap.Class1.Name == "" && snap.Class2.Name == "" {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// ErasureReport records what Erase removed and whether anything was left.
+type ErasureReport struct {
+	UserID string     `json:"userId"`
+	Erased DataCounts `json:"erased"`
+	// Digest is the SHA-256 of the erased data as Export returned it.
+	Digest string `json:"digest"`
+	// Remaining counts what a fresh Export found afterwards, such as a
+	// workspace created while the erasure ran. Verified is true when it
+	// found nothing.
+	Remaining DataCounts `json:"remaining"`
+	Verified  bool       `json:"verified"`
+}
+
+// Erase deletes the data d, taken by Export, and exports the user again to
+// verify nothing is left. Owned workspaces go with their state, grants,
+// share links, API keys and audit log; the user's grants on other workspaces
+// and any keys they made there are removed too, and the audit entries they
+// left there are kept without their name.
+func (w *Workspaces) Erase(ctx context.Context, d UserData) (ErasureReport, error) {
+	digest, err := d.Digest()
+	if err != nil {
+		return ErasureReport{}, err
+	}
+	rep := ErasureReport{UserID: d.UserID, Erased: d.Counts(), Digest: digest}
+	for _, ws := range d.Workspaces {
+		if err := w.repo.DeleteWorkspace(ctx, ws.ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return rep, err
+		}
+		// Deleting a workspace keeps its audit log; erasing its owner does not.
+		if err := w.repo.DeleteAudit(ctx, ws.ID); err != nil {
+			return rep, err
+		}
+	}
+	for _, g := range d.SharedWithMe {
+		if err := w.repo.DeleteGrant(ctx, g.WorkspaceID, g.UserID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return rep, err
+		}
+	}
+	if err := w.repo.DeleteAPIKeys(ctx, d.UserID); err != nil {
+		return rep, err
+	}
+	if d.LegacyState != nil {
+		if err := w.repo.ResetUser(ctx, d.UserID); err != nil {
+			return rep, err
+		}
+	}
+	if err := w.repo.RedactAuditActor(ctx, d.UserID); err != nil {
+		return rep, err
+	}
+
+	left, err := w.Export(ctx, d.UserID)
+	if err != nil {
+		return rep, err
+	}
+	rep.Remaining = left.Counts()
+	rep.Verified = rep.Remaining == DataCounts{}
+	return rep, nil
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func TestWorkspaces_ExportAndErase(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+
+	mine, err := ws.Default(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Classifier(mine.ID).Init(ctx, models.Class{Name: "Cat"}, models.Class{Name: "Owl"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.Share(ctx, "alice", mine.ID, "bob", RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ws.CreateLink(ctx, "alice", mine.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	theirs, err := ws.Create(ctx, "bob", "bob's")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.Share(ctx, "bob", theirs.ID, "alice", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ws.CreateKey(ctx, "alice", theirs.ID, "cron", ScopeClassify); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ws.Export(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := data.Counts(); c != (DataCounts{Workspaces: 1, SharedWithMe: 1, APIKeys: 1}) {
+		t.Fatalf("counts=%+v", c)
+	}
+	if w := data.Workspaces[0]; w.State.Class1.Name != "Cat" || len(w.Grants) != 1 || len(w.ShareLinks) != 1 {
+		t.Fatalf("workspace data=%+v", w)
+	}
+
+	rep, err := ws.Erase(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, _ := data.Digest()
+	if !rep.Verified || rep.Erased != data.Counts() || rep.Digest != digest || len(digest) != 64 {
+		t.Fatalf("report=%+v", rep)
+	}
+	if _, err := ws.Get(ctx, "bob", mine.ID); !errors.Is(err, ErrWorkspaceNotFound) {
+		t.Fatalf("erased workspace still shared: %v", err)
+	}
+	if _, err := ws.Get(ctx, "alice", theirs.ID); !errors.Is(err, ErrWorkspaceNotFound) {
+		t.Fatalf("grant to the erased user survived: %v", err)
+	}
+	if _, err := ws.Get(ctx, "bob", theirs.ID); err != nil {
+		t.Fatalf("other user's workspace: %v", err)
+	}
+	if keys, _ := repo.ListAPIKeys(ctx, "alice"); len(keys) != 0 {
+		t.Fatalf("keys=%+v", keys)
+	}
+}
+
+func TestWorkspaces_EraseLegacyState(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+	legacy := repository.State{Class1: models.Class{Name: "Spam"}, Class2: models.Class{Name: "Ham"}}
+	if err := repo.UpsertState(ctx, "carol", legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ws.Export(ctx, "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.LegacyState == nil || data.LegacyState.Class1.Name != "Spam" || len(data.Workspaces) != 0 {
+		t.Fatalf("export=%+v", data)
+	}
+	rep, err := ws.Erase(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.Verified || rep.Erased.LegacyStates != 1 {
+		t.Fatalf("report=%+v", rep)
+	}
+	if st, _ := repo.GetState(ctx, "carol"); st.Class1.Name != "" {
+		t.Fatalf("legacy state survived: %+v", st)
+	}
+}
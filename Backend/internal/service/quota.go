@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+// Quotas caps what one classifier and one user may store. Zero means no
+// limit. The classifier keeps properties only, not the examples it was
+// trained on, and always has two classes, so neither needs a quota.
+type Quotas struct {
+	PropertiesPerArea int `json:"propertiesPerArea"`
+	// Vocabulary counts distinct properties across all areas.
+	Vocabulary int `json:"vocabulary"`
+	// PropertyLength is in characters.
+	PropertyLength    int `json:"propertyLength"`
+	WorkspacesPerUser int `json:"workspacesPerUser"`
+}
+
+var DefaultQuotas = Quotas{PropertiesPerArea: 1000, Vocabulary: 2000, PropertyLength: 100, WorkspacesPerUser: 50}
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaError names the quota a write would exceed.
+type QuotaError struct {
+	Quota string
+	Limit int
+}
+
+func (e *QuotaError) Error() string {
+	switch e.Quota {
+	case "propertiesPerArea":
+		return fmt.Sprintf("%s: an area may hold at most %d properties", ErrQuotaExceeded, e.Limit)
+	case "vocabulary":
+		return fmt.Sprintf("%s: a classifier may know at most %d properties", ErrQuotaExceeded, e.Limit)
+	case "propertyLength":
+		return fmt.Sprintf("%s: properties may be at most %d characters long", ErrQuotaExceeded, e.Limit)
+	case "workspacesPerUser":
+		return fmt.Sprintf("%s: a user may own at most %d workspaces", ErrQuotaExceeded, e.Limit)
+	}
+	return fmt.Sprintf("%s: %s is limited to %d", ErrQuotaExceeded, e.Quota, e.Limit)
+}
+
+func (e *QuotaError) Unwrap() error { return ErrQuotaExceeded }
+
+// Usage is how much of its quotas a classifier uses.
+type Usage struct {
+	Class1          int `json:"class1"`
+	Class2          int `json:"class2"`
+	General         int `json:"general"`
+	None            int `json:"none"`
+	Vocabulary      int `json:"vocabulary"`
+	LongestProperty int `json:"longestProperty"`
+}
+
+func usageOf(st repository.State) Usage {
+	u := Usage{
+		Class1:  len(st.Class1.Properties),
+		Class2:  len(st.Class2.Properties),
+		General: len(st.GeneralClass),
+		None:    len(st.NoneClass),
+	}
+	seen := make(map[string]struct{})
+	for _, area := range [][]string{st.Class1.Properties, st.Class2.Properties, st.GeneralClass, st.NoneClass} {
+		for _, p := range area {
+			seen[p] = struct{}{}
+			u.LongestProperty = max(u.LongestProperty, utf8.RuneCountInString(p))
+		}
+	}
+	u.Vocabulary = len(seen)
+	return u
+}
+
+// tooLong counts the properties over the length limit.
+func (q Quotas) tooLong(st repository.State) int {
+	n := 0
+	for _, area := range [][]string{st.Class1.Properties, st.Class2.Properties, st.GeneralClass, st.NoneClass} {
+		for _, p := range area {
+			if utf8.RuneCountInString(p) > q.PropertyLength {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// checkState refuses a write that takes the classifier over a quota.
+// Writes that leave a classifier as far over as it was are let through, so
+// lowering a quota does not lock users out of cleaning up.
+func (q Quotas) checkState(before Usage, beforeLong int, after repository.State) error {
+	now := usageOf(after)
+	if q.PropertiesPerArea > 0 {
+		areas := [][2]int{{before.Class1, now.Class1}, {before.Class2, now.Class2}, {before.General, now.General}, {before.None, now.None}}
+		for _, a := range areas {
+			if a[1] > q.PropertiesPerArea && a[1] > a[0] {
+				return &QuotaError{Quota: "propertiesPerArea", Limit: q.PropertiesPerArea}
+			}
+		}
+	}
+	if q.Vocabulary > 0 && now.Vocabulary > q.Vocabulary && now.Vocabulary > before.Vocabulary {
+		return &QuotaError{Quota: "vocabulary", Limit: q.Vocabulary}
+	}
+	if q.PropertyLength > 0 && q.tooLong(after) > beforeLong {
+		return &QuotaError{Quota: "propertyLength", Limit: q.PropertyLength}
+	}
+	return nil
+}
+
+// checkWorkspaces refuses a new workspace for a user who owns as many as
+// allowed.
+func (w *Workspaces) checkWorkspaces(ctx context.Context, userID string) error {
+	if w.Quotas.WorkspacesPerUser <= 0 {
+		return nil
+	}
+	owned, err := w.repo.ListWorkspaces(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if len(owned) >= w.Quotas.WorkspacesPerUser {
+		return &QuotaError{Quota: "workspacesPerUser", Limit: w.Quotas.WorkspacesPerUser}
+	}
+	return nil
+}
+
+// UsageReport is a user's standing against their quotas in one workspace.
+type UsageReport struct {
+	Limits      Quotas `json:"limits"`
+	Workspaces  int    `json:"workspaces"`
+	WorkspaceID string `json:"workspaceId"`
+	Classifier  Usage  `json:"classifier"`
+}
+
+// Usage reports the classifier in the workspace the user can view, given or
+// default, and how many workspaces the user owns.
+func (w *Workspaces) Usage(ctx context.Context, userID, id string) (UsageReport, error) {
+	a, err := w.Resolve(ctx, userID, id, RoleViewer)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	return w.usage(ctx, userID, a.ID)
+}
+
+// KeyUsage is Usage for a request authenticated by an API key.
+func (w *Workspaces) KeyUsage(ctx context.Context, k repository.APIKey, id string) (UsageReport, error) {
+	a, err := w.KeyAccess(ctx, k, id, RoleViewer)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	return w.usage(ctx, k.UserID, a.ID)
+}
+
+func (w *Workspaces) usage(ctx context.Context, userID, workspaceID string) (UsageReport, error) {
+	owned, err := w.repo.ListWorkspaces(ctx, userID)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	st, err := w.repo.GetState(ctx, workspaceID)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	return UsageReport{Limits: w.Quotas, Workspaces: len(owned), WorkspaceID: workspaceID, Classifier: usageOf(st)}, nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func TestQuotas_Classifier(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+	ws.Quotas = Quotas{PropertiesPerArea: 3, Vocabulary: 4, PropertyLength: 10}
+	mine, err := ws.Default(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := ws.Classifier(mine.ID)
+	if err := svc.Init(ctx, models.Class{Name: "Cat", Properties: []string{"purr"}}, models.Class{Name: "Dog", Properties: []string{"bark"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	quota := func(err error) string {
+		t.Helper()
+		var qe *QuotaError
+		if !errors.As(err, &qe) || !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("err=%v; want a quota error", err)
+		}
+		return qe.Quota
+	}
+	if q := quota(svc.Feedback(ctx, "class1", []string{"a", "b", "c"})); q != "propertiesPerArea" {
+		t.Fatalf("quota=%s", q)
+	}
+	if err := svc.Feedback(ctx, "class1", []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if q := quota(svc.AddProperty(ctx, "none", "c")); q != "vocabulary" {
+		t.Fatalf("quota=%s", q)
+	}
+	if q := quota(svc.RenameProperty(ctx, "class2", "bark", strings.Repeat("w", 11))); q != "propertyLength" {
+		t.Fatalf("quota=%s", q)
+	}
+	if st, _ := repo.GetState(ctx, mine.ID); len(st.Class1.Properties) != 3 || st.Class2.Properties[0] != "bark" {
+		t.Fatalf("refused writes must not be stored: %+v", st)
+	}
+
+	// After a quota is lowered, writes that do not add to the excess still
+	// go through.
+	ws.Quotas.Vocabulary = 2
+	svc = ws.Classifier(mine.ID)
+	if err := svc.MoveProperty(ctx, "class1", "none", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.RemoveProperty(ctx, "none", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if q := quota(svc.AddProperty(ctx, "none", "a")); q != "vocabulary" {
+		t.Fatalf("quota=%s", q)
+	}
+
+	u, err := ws.Usage(ctx, "alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Usage{Class1: 2, Class2: 1, Vocabulary: 3, LongestProperty: 4}
+	if u.Classifier != want || u.Workspaces != 1 || u.WorkspaceID != mine.ID || u.Limits != ws.Quotas {
+		t.Fatalf("usage=%+v", u)
+	}
+}
+
+func TestQuotas_WorkspacesPerUser(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWorkspaces(repository.NewMemory())
+	ws.Quotas.WorkspacesPerUser = 2
+
+	first, err := ws.Create(ctx, "alice", "one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.Create(ctx, "alice", "two"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("third workspace (counting the default) err=%v", err)
+	}
+	if _, err := ws.Clone(ctx, "alice", first.ID, ""); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("clone over the quota err=%v", err)
+	}
+	if _, err := ws.Create(ctx, "bob", "one"); err != nil {
+		t.Fatalf("quota is per user: %v", err)
+	}
+}
+
+func TestQuotas_ImportCloneAndClaim(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+	theirs, err := ws.Default(ctx, "visitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Classifier(theirs.ID).Init(ctx, models.Class{Name: "Cat", Properties: []string{"purr", "claws"}}, models.Class{Name: "Dog"}); err != nil {
+		t.Fatal(err)
+	}
+	mine, err := ws.Default(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Classifier(mine.ID).Init(ctx, models.Class{Name: "Cat", Properties: []string{"tail"}}, models.Class{Name: "Dog"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.PutGrant(ctx, repository.Grant{WorkspaceID: theirs.ID, UserID: "alice", Role: string(RoleViewer)}); err != nil {
+		t.Fatal(err)
+	}
+
+	ws.Quotas = Quotas{PropertiesPerArea: 2}
+	export := models.Snapshot{
+		Class1: models.Class{Name: "Cat", Properties: []string{"purr", "claws", "whiskers"}},
+		Class2: models.Class{Name: "Dog"},
+	}
+	if _, err := ws.Import(ctx, "alice", "", export); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("import over the quota err=%v", err)
+	}
+	ws.Quotas.PropertiesPerArea = 1
+	if _, err := ws.Clone(ctx, "alice", theirs.ID, ""); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("clone over the quota err=%v", err)
+	}
+	if list, _ := repo.ListWorkspaces(ctx, "alice"); len(list) != 1 {
+		t.Fatalf("refused copies left workspaces: %+v", list)
+	}
+
+	ws.Quotas.PropertiesPerArea = 2
+	if _, err := ws.ClaimAnonymous(ctx, "alice", "visitor", ClaimMerge); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("merge over the quota err=%v", err)
+	}
+	if st, _ := repo.GetState(ctx, mine.ID); len(st.Class1.Properties) != 1 {
+		t.Fatalf("refused merge changed the state: %+v", st)
+	}
+	if left, _ := repo.ListWorkspaces(ctx, "visitor"); len(left) != 1 {
+		t.Fatalf("refused claim deleted the visitor's workspaces: %+v", left)
+	}
+}
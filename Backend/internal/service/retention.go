@@ -0,0 +1,263 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/auth"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+type anonymousKey struct{}
+
+// WithAnonymous marks ctx as serving a visitor without an account. Workspaces
+// created under it are expired by the anonymous retention period.
+func WithAnonymous(ctx context.Context) context.Context {
+	return context.WithValue(ctx, anonymousKey{}, true)
+}
+
+func isAnonymous(ctx context.Context) bool {
+	anon, _ := ctx.Value(anonymousKey{}).(bool)
+	return anon
+}
+
+// DefaultRetentionBatch is how many workspaces Sweep reads at a time unless
+// Retention says otherwise.
+const DefaultRetentionBatch = 500
+
+// Retention says how long workspaces may go unused before Sweep removes them.
+// A workspace is used when it is written or opened, including for classify and
+// through share links; opens are recorded to within workspaceTouchInterval.
+// State under a bare user ID that no workspace adopted expires after its last
+// write. A zero period keeps that kind of workspace forever.
+type Retention struct {
+	Anonymous     time.Duration
+	Authenticated time.Duration
+	BatchSize     int
+	// DryRun counts what would be removed without removing anything.
+	DryRun bool
+	// Archive, when set, receives each workspace and its state as one JSON
+	// line before the workspace is deleted.
+	Archive io.Writer
+}
+
+func (r Retention) expired(anonymous bool, lastActive, now time.Time) bool {
+	ttl := r.Authenticated
+	if anonymous {
+		ttl = r.Anonymous
+	}
+	return ttl > 0 && now.Sub(lastActive) > ttl
+}
+
+// SweepReport is what one Sweep removed, or would have in a dry run.
+// Anonymous and Authenticated count workspaces, Legacy the states under bare
+// user IDs; Scanned counts both.
+type SweepReport struct {
+	DryRun        bool `json:"dryRun"`
+	Scanned       int  `json:"scanned"`
+	Anonymous     int  `json:"anonymous"`
+	Authenticated int  `json:"authenticated"`
+	Legacy        int  `json:"legacy"`
+	Archived      int  `json:"archived"`
+}
+
+// ArchivedWorkspace is one line of a retention archive. State is in the
+// GET /state format, so it can be uploaded again. Legacy state is archived as
+// the default workspace it would have become.
+type ArchivedWorkspace struct {
+	Workspace  repository.Workspace `json:"workspace"`
+	State      models.Snapshot      `json:"state"`
+	LastWrite  time.Time            `json:"lastWrite"`
+	ArchivedAt time.Time            `json:"archivedAt"`
+}
+
+// Sweep removes the workspaces idle past their retention period, together
+// with their state, grants, share links and API keys, and then the idle state
+// left under bare user IDs. It pages through the catalog in batches, so it can
+// run while the API serves requests. A user whose default workspace or legacy
+// state is removed gets a fresh one on their next visit.
+func (w *Workspaces) Sweep(ctx context.Context, r Retention) (SweepReport, error) {
+	if r.BatchSize <= 0 {
+		r.BatchSize = DefaultRetentionBatch
+	}
+	rep := SweepReport{DryRun: r.DryRun}
+	if err := w.sweepWorkspaces(ctx, r, &rep); err != nil {
+		return rep, err
+	}
+	return rep, w.sweepLegacy(ctx, r, &rep)
+}
+
+func (w *Workspaces) sweepWorkspaces(ctx context.Context, r Retention, rep *SweepReport) error {
+	now := w.now()
+	after := ""
+	for {
+		page, err := w.repo.ScanWorkspaces(ctx, after, r.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, a := range page {
+			rep.Scanned++
+			after = a.ID
+			if !r.expired(a.Anonymous, a.LastActive(), now) {
+				continue
+			}
+			if !r.DryRun {
+				removed, err := w.expire(ctx, a, r.Archive)
+				if err != nil {
+					return err
+				}
+				if !removed {
+					continue
+				}
+				if r.Archive != nil {
+					rep.Archived++
+				}
+			}
+			if a.Anonymous {
+				rep.Anonymous++
+			} else {
+				rep.Authenticated++
+			}
+		}
+		if len(page) < r.BatchSize {
+			return nil
+		}
+	}
+}
+
+// sweepLegacy removes idle state under bare user IDs, which Default adopts
+// into a workspace on the user's next visit and nothing else reads.
+func (w *Workspaces) sweepLegacy(ctx context.Context, r Retention, rep *SweepReport) error {
+	now := w.now()
+	after := ""
+	for {
+		page, err := w.repo.ScanLegacyStates(ctx, after, r.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, l := range page {
+			rep.Scanned++
+			after = l.UserID
+			if !r.expired(auth.AnonymousID(l.UserID), l.LastWrite, now) {
+				continue
+			}
+			if !r.DryRun {
+				removed, err := w.expireLegacy(ctx, l, r.Archive)
+				if err != nil {
+					return err
+				}
+				if !removed {
+					continue
+				}
+				if r.Archive != nil {
+					rep.Archived++
+				}
+			}
+			rep.Legacy++
+		}
+		if len(page) < r.BatchSize {
+			return nil
+		}
+	}
+}
+
+// expire archives and deletes one workspace. It reports false if the
+// workspace was already gone, as when another replica swept it first.
+func (w *Workspaces) expire(ctx context.Context, a repository.WorkspaceActivity, archive io.Writer) (bool, error) {
+	if archive != nil {
+		snap, err := NewWorkspaceService(w.repo, a.ID).Snapshot(ctx)
+		if err != nil {
+			return false, err
+		}
+		line, err := json.Marshal(ArchivedWorkspace{Workspace: a.Workspace, State: snap, LastWrite: a.LastWrite, ArchivedAt: w.timestamp()})
+		if err != nil {
+			return false, err
+		}
+		if _, err := archive.Write(append(line, '\n')); err != nil {
+			return false, err
+		}
+	}
+	switch err := w.repo.DeleteWorkspace(ctx, a.ID); {
+	case errors.Is(err, repository.ErrNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+// expireLegacy archives and deletes the state under a bare user ID. It
+// reports false if the state was written, adopted or removed since the scan.
+func (w *Workspaces) expireLegacy(ctx context.Context, l repository.LegacyState, archive io.Writer) (bool, error) {
+	_, version, err := w.repo.LoadState(ctx, l.UserID)
+	if err != nil || version == 0 {
+		return false, err
+	}
+	if archive != nil {
+		snap, err := NewWorkspaceService(w.repo, l.UserID).Snapshot(ctx)
+		if err != nil {
+			return false, err
+		}
+		ws := repository.Workspace{ID: l.UserID, OwnerID: l.UserID, Name: DefaultWorkspaceName, Anonymous: auth.AnonymousID(l.UserID)}
+		line, err := json.Marshal(ArchivedWorkspace{Workspace: ws, State: snap, LastWrite: l.LastWrite, ArchivedAt: w.timestamp()})
+		if err != nil {
+			return false, err
+		}
+		if _, err := archive.Write(append(line, '\n')); err != nil {
+			return false, err
+		}
+	}
+	switch err := w.repo.CommitState(ctx, repository.StateCommit{ID: l.UserID, Version: version, Reset: true}); {
+	case errors.Is(err, repository.ErrStale):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+// Janitor runs Sweep on a schedule and logs what it removed.
+type Janitor struct {
+	workspaces *Workspaces
+	retention  Retention
+	interval   time.Duration
+}
+
+func NewJanitor(w *Workspaces, r Retention, interval time.Duration) *Janitor {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	return &Janitor{workspaces: w, retention: r, interval: interval}
+}
+
+// Run sweeps once straight away and then every interval until ctx is done.
+func (j *Janitor) Run(ctx context.Context) {
+	t := time.NewTicker(j.interval)
+	defer t.Stop()
+	for {
+		j.Sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (j *Janitor) Sweep(ctx context.Context) {
+	rep, err := j.workspaces.Sweep(ctx, j.retention)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("retention: %v", err)
+	}
+	verb := "removed"
+	if rep.DryRun {
+		verb = "would remove"
+	}
+	log.Printf("retention: scanned %d workspaces and legacy states, %s %d anonymous and %d authenticated workspaces and %d legacy states (%d archived)",
+		rep.Scanned, verb, rep.Anonymous, rep.Authenticated, rep.Legacy, rep.Archived)
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func TestWorkspaces_Sweep(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+	now := time.Now()
+	ws.now = func() time.Time { return now }
+
+	anonCtx := WithAnonymous(ctx)
+	idle, err := ws.Default(anonCtx, "visitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trained, err := ws.Create(anonCtx, "visitor", "trained")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Classifier(trained.ID).Init(ctx, models.Class{Name: "Cat"}, models.Class{Name: "Owl"}); err != nil {
+		t.Fatal(err)
+	}
+	member, err := ws.Default(ctx, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !idle.Anonymous || !trained.Anonymous || member.Anonymous {
+		t.Fatalf("anonymous flags: %t %t %t", idle.Anonymous, trained.Anonymous, member.Anonymous)
+	}
+
+	// Nothing is idle long enough yet.
+	policy := Retention{Anonymous: time.Hour, BatchSize: 1}
+	rep, err := ws.Sweep(ctx, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep != (SweepReport{Scanned: 3}) {
+		t.Fatalf("report=%+v", rep)
+	}
+
+	now = now.Add(2 * time.Hour)
+	policy.DryRun = true
+	rep, err = ws.Sweep(ctx, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep != (SweepReport{DryRun: true, Scanned: 3, Anonymous: 2}) {
+		t.Fatalf("dry run report=%+v", rep)
+	}
+	if _, err := repo.GetWorkspace(ctx, trained.ID); err != nil {
+		t.Fatalf("dry run removed a workspace: %v", err)
+	}
+
+	var archive bytes.Buffer
+	policy.DryRun, policy.Archive = false, &archive
+	rep, err = ws.Sweep(ctx, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep != (SweepReport{Scanned: 3, Anonymous: 2, Archived: 2}) {
+		t.Fatalf("report=%+v", rep)
+	}
+	for _, id := range []string{idle.ID, trained.ID} {
+		if _, err := repo.GetWorkspace(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("%s survived: %v", id, err)
+		}
+	}
+	if _, err := repo.GetWorkspace(ctx, member.ID); err != nil {
+		t.Fatalf("member workspace removed: %v", err)
+	}
+
+	archived := map[string]ArchivedWorkspace{}
+	sc := bufio.NewScanner(&archive)
+	for sc.Scan() {
+		var a ArchivedWorkspace
+		if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+			t.Fatal(err)
+		}
+		archived[a.Workspace.ID] = a
+	}
+	if len(archived) != 2 || archived[trained.ID].State.Class1.Name != "Cat" {
+		t.Fatalf("archive=%+v", archived)
+	}
+
+	rep, err = ws.Sweep(ctx, Retention{Authenticated: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep != (SweepReport{Scanned: 1, Authenticated: 1}) {
+		t.Fatalf("authenticated report=%+v", rep)
+	}
+}
+
+func TestWorkspaces_SweepKeepsReadWorkspacesAndExpiresLegacyState(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+	now := time.Now()
+	ws.now = func() time.Time { return now }
+
+	anonCtx := WithAnonymous(ctx)
+	const visitor = "anon_00112233445566778899aabbccddeeff"
+	read, err := ws.Default(anonCtx, visitor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := ws.Create(anonCtx, visitor, "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, token, err := ws.CreateLink(ctx, visitor, shared.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// State from before workspaces, under an unsigned cookie's ID and an
+	// account's, that nobody came back for.
+	const legacy = "00112233445566778899aabbccddeeff"
+	st := repository.State{Class1: models.Class{Name: "Cat"}, Class2: models.Class{Name: "Owl"}}
+	for _, id := range []string{legacy, "member"} {
+		if err := repo.UpsertState(ctx, id, st); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both workspaces are only read past the anonymous period: one by its
+	// owner, as classify does, the other through its share link.
+	now = now.Add(2 * time.Hour)
+	if _, err := ws.Resolve(anonCtx, visitor, "", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.ResolveLink(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	rep, err := ws.Sweep(ctx, Retention{Anonymous: time.Hour, Archive: &archive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep != (SweepReport{Scanned: 4, Legacy: 1, Archived: 1}) {
+		t.Fatalf("report=%+v", rep)
+	}
+	for _, id := range []string{read.ID, shared.ID} {
+		if _, err := repo.GetWorkspace(ctx, id); err != nil {
+			t.Fatalf("workspace %s in use was removed: %v", id, err)
+		}
+	}
+	if _, version, err := repo.LoadState(ctx, legacy); err != nil || version != 0 {
+		t.Fatalf("legacy state version=%d, %v; want removed", version, err)
+	}
+	if got, err := repo.GetState(ctx, "member"); err != nil || got.Class1.Name != "Cat" {
+		t.Fatalf("account's legacy state=%+v, %v; want kept", got, err)
+	}
+	var a ArchivedWorkspace
+	if err := json.Unmarshal(archive.Bytes(), &a); err != nil {
+		t.Fatal(err)
+	}
+	if a.Workspace.ID != legacy || !a.Workspace.Anonymous || a.State.Class1.Name != "Cat" {
+		t.Fatalf("archived=%+v", a)
+	}
+
+	rep, err = ws.Sweep(ctx, Retention{Authenticated: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep != (SweepReport{Scanned: 3, Legacy: 1}) {
+		t.Fatalf("authenticated report=%+v", rep)
+	}
+}
@@ -1,24 +1,26 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"strings"
 	"sync"
 
 	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
 )
 
 type Service interface {
-	Init(c1, c2 models.Class)
-	Classify(props []string) models.ClassifyResponse
-	Feedback(variant string, props []string)
-	Snapshot() models.Snapshot
-	Reset() error
-	RemoveProperty(area, prop string) error
-	MoveProperty(from, to, prop string) error
-	RenameClass(class, name string) error
-	RenameProperty(area, from, to string) error
-	AddProperty(area, prop string) error
+	Init(ctx context.Context, c1, c2 models.Class) error
+	Classify(ctx context.Context, props []string) (models.ClassifyResponse, error)
+	Feedback(ctx context.Context, variant string, props []string) error
+	Snapshot(ctx context.Context) (models.Snapshot, error)
+	Reset(ctx context.Context) error
+	RemoveProperty(ctx context.Context, area, prop string) error
+	MoveProperty(ctx context.Context, from, to, prop string) error
+	RenameClass(ctx context.Context, class, name string) error
+	RenameProperty(ctx context.Context, area, from, to string) error
+	AddProperty(ctx context.Context, area, prop string) error
 }
 
 type memoryService struct {
@@ -31,13 +33,14 @@ type memoryService struct {
 
 func NewMemoryService() Service { return &memoryService{} }
 
-func (u *userService) RenameProperty(area, from, to string) error {
+func (u *userService) RenameProperty(ctx context.Context, area, from, to string) error {
 	from = strings.TrimSpace(from)
 	to = strings.TrimSpace(to)
 	if from == "" || to == "" || from == to {
 		return nil
 	}
-	_, err := u.withState(func(ms *memoryService) {
+	op := auditOp{name: "prop/rename", payload: models.RenamePropertyRequest{Area: area, From: from, To: to}, properties: []string{from, to}}
+	_, err := u.withState(ctx, op, func(ms *memoryService) {
 		rename := func(xs []string) []string {
 
 			foundTo := false
@@ -79,10 +82,11 @@ func (u *userService) RenameProperty(area, from, to string) error {
 	return err
 }
 
-func (s *memoryService) RenameProperty(area, from, to string) error { return nil }
+func (s *memoryService) RenameProperty(_ context.Context, area, from, to string) error { return nil }
 
-func (u *userService) RemoveProperty(area, prop string) error {
-	_, err := u.withState(func(ms *memoryService) {
+func (u *userService) RemoveProperty(ctx context.Context, area, prop string) error {
+	op := auditOp{name: "prop/remove", payload: models.RemovePropertyRequest{Area: area, Property: prop}, properties: []string{prop}}
+	_, err := u.withState(ctx, op, func(ms *memoryService) {
 		switch strings.ToLower(area) {
 		case "class1":
 			ms.class1.Properties = remove(ms.class1.Properties, prop)
@@ -96,15 +100,16 @@ func (u *userService) RemoveProperty(area, prop string) error {
 	})
 	return err
 }
-func (s *memoryService) RemoveProperty(area, prop string) error   { return nil }
-func (s *memoryService) MoveProperty(from, to, prop string) error { return nil }
-func (s *memoryService) RenameClass(class, name string) error     { return nil }
+func (s *memoryService) RemoveProperty(_ context.Context, area, prop string) error   { return nil }
+func (s *memoryService) MoveProperty(_ context.Context, from, to, prop string) error { return nil }
+func (s *memoryService) RenameClass(_ context.Context, class, name string) error     { return nil }
 
-func (u *userService) MoveProperty(from, to, prop string) error {
+func (u *userService) MoveProperty(ctx context.Context, from, to, prop string) error {
 	if strings.EqualFold(from, to) {
 		return nil
 	}
-	_, err := u.withState(func(ms *memoryService) {
+	op := auditOp{name: "prop/move", payload: models.MovePropertyRequest{From: from, To: to, Property: prop}, properties: []string{prop}}
+	_, err := u.withState(ctx, op, func(ms *memoryService) {
 
 		switch strings.ToLower(from) {
 		case "class1":
@@ -131,12 +136,13 @@ func (u *userService) MoveProperty(from, to, prop string) error {
 	return err
 }
 
-func (u *userService) RenameClass(class, name string) error {
+func (u *userService) RenameClass(ctx context.Context, class, name string) error {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return errors.New("empty name")
 	}
-	_, err := u.withState(func(ms *memoryService) {
+	op := auditOp{name: "classes/rename", payload: models.RenameClassRequest{Class: class, Name: name}}
+	_, err := u.withState(ctx, op, func(ms *memoryService) {
 		switch strings.ToLower(class) {
 		case "class1":
 			ms.class1.Name = name
@@ -147,15 +153,29 @@ func (u *userService) RenameClass(class, name string) error {
 	return err
 }
 
-func (s *userService) Reset() error {
-	return s.repo.ResetUser(s.userID)
+func (u *userService) Reset(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		before, version, err := u.repo.LoadState(ctx, u.workspaceID)
+		if err != nil {
+			return err
+		}
+		audit, err := u.auditEntry(ctx, auditOp{name: "reset"}, before, repository.State{})
+		if err != nil {
+			return err
+		}
+		err = u.repo.CommitState(ctx, repository.StateCommit{ID: u.workspaceID, Version: version, Reset: true, Audit: audit})
+		if errors.Is(err, repository.ErrStale) && attempt < maxCommitAttempts {
+			continue
+		}
+		return err
+	}
 }
 
-func (s *memoryService) Reset() error {
+func (s *memoryService) Reset(_ context.Context) error {
 	return nil
 }
 
-func (s *memoryService) Init(c1, c2 models.Class) {
+func (s *memoryService) Init(_ context.Context, c1, c2 models.Class) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -166,9 +186,10 @@ func (s *memoryService) Init(c1, c2 models.Class) {
 	s.class1 = models.Class{Name: c1.Name, Properties: na}
 	s.class2 = models.Class{Name: c2.Name, Properties: nb}
 	s.generalClass = unique(append(s.generalClass, inter...))
+	return nil
 }
 
-func (s *memoryService) Classify(props []string) models.ClassifyResponse {
+func (s *memoryService) Classify(_ context.Context, props []string) (models.ClassifyResponse, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -192,10 +213,10 @@ func (s *memoryService) Classify(props []string) models.ClassifyResponse {
 		resp.Recommendation = "Please confirm or adjust the suggestion."
 	}
 
-	return resp
+	return resp, nil
 }
 
-func (s *memoryService) Feedback(variant string, props []string) {
+func (s *memoryService) Feedback(_ context.Context, variant string, props []string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -223,9 +244,14 @@ func (s *memoryService) Feedback(variant string, props []string) {
 	s.class1.Properties = na2
 	s.class2.Properties = nb2
 	s.generalClass = unique(append(s.generalClass, inter2...))
+	return nil
+}
+
+func (s *memoryService) Snapshot(_ context.Context) (models.Snapshot, error) {
+	return s.snapshot(), nil
 }
 
-func (s *memoryService) Snapshot() models.Snapshot {
+func (s *memoryService) snapshot() models.Snapshot {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -271,12 +297,13 @@ func remove(xs []string, v string) []string {
 	return out
 }
 
-func (u *userService) AddProperty(area, prop string) error {
+func (u *userService) AddProperty(ctx context.Context, area, prop string) error {
 	prop = strings.TrimSpace(prop)
 	if prop == "" {
 		return nil
 	}
-	_, err := u.withState(func(ms *memoryService) {
+	op := auditOp{name: "prop/add", payload: models.AddPropertyRequest{Area: area, Property: prop}, properties: []string{prop}}
+	_, err := u.withState(ctx, op, func(ms *memoryService) {
 		switch strings.ToLower(area) {
 		case "class1":
 			ms.class1.Properties = uniqueAppend(ms.class1.Properties, prop)
@@ -291,4 +318,4 @@ func (u *userService) AddProperty(area, prop string) error {
 	return err
 }
 
-func (s *memoryService) AddProperty(area, prop string) error { return nil }
+func (s *memoryService) AddProperty(_ context.Context, area, prop string) error { return nil }
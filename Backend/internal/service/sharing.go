@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+// Role is what a user may do in a workspace. Viewers classify and read the
+// state, editors also train it, and only the owner may re-initialise, reset,
+// rename, delete or share it.
+type Role string
+
+const (
+	RoleViewer Role = repository.RoleViewer
+	RoleEditor Role = repository.RoleEditor
+	RoleOwner  Role = "owner"
+)
+
+var roleRank = map[Role]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+func (r Role) Allows(need Role) bool {
+	return roleRank[r] >= roleRank[need]
+}
+
+var (
+	ErrForbidden      = errors.New("your role in this workspace does not allow this")
+	ErrInvalidRole    = errors.New("role must be viewer or editor")
+	ErrInvalidGrantee = errors.New("userId is required and must not be the owner")
+	ErrGrantNotFound  = errors.New("grant not found")
+)
+
+// Access is a workspace together with the caller's role in it.
+type Access struct {
+	repository.Workspace
+	Role Role `json:"role"`
+}
+
+// Grants lists who the workspace is shared with. Only the owner may see it.
+func (w *Workspaces) Grants(ctx context.Context, userID, id string) ([]repository.Grant, error) {
+	if _, err := w.require(ctx, userID, id, RoleOwner); err != nil {
+		return nil, err
+	}
+	return w.repo.ListGrants(ctx, id)
+}
+
+// Share grants grantee the role, replacing any role they already had.
+func (w *Workspaces) Share(ctx context.Context, userID, id, grantee string, role Role) (repository.Grant, error) {
+	if _, err := w.require(ctx, userID, id, RoleOwner); err != nil {
+		return repository.Grant{}, err
+	}
+	if role != RoleViewer && role != RoleEditor {
+		return repository.Grant{}, ErrInvalidRole
+	}
+	grantee = strings.TrimSpace(grantee)
+	if grantee == "" || grantee == userID {
+		return repository.Grant{}, ErrInvalidGrantee
+	}
+	g := repository.Grant{WorkspaceID: id, UserID: grantee, Role: string(role), CreatedAt: w.timestamp()}
+	if err := w.repo.PutGrant(ctx, g); err != nil {
+		return repository.Grant{}, notFound(err)
+	}
+	return w.repo.GetGrant(ctx, id, grantee)
+}
+
+// Revoke removes a grant. The owner may revoke anyone; a grantee may only
+// give up their own access.
+func (w *Workspaces) Revoke(ctx context.Context, userID, id, grantee string) error {
+	a, err := w.Get(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if a.Role != RoleOwner && grantee != userID {
+		return ErrForbidden
+	}
+	if err := w.repo.DeleteGrant(ctx, id, grantee); errors.Is(err, repository.ErrNotFound) {
+		return ErrGrantNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+const (
+	DefaultWorkspaceName = "Default"
+	maxWorkspaceName     = 100
+	// workspaceTouchInterval bounds how often a busy workspace writes its
+	// last-access time.
+	workspaceTouchInterval = time.Hour
+)
+
+var (
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+	ErrInvalidName       = errors.New("workspace name must be 1-100 characters")
+)
+
+// Workspaces manages a user's named classifiers. Every user has at least one
+// workspace: the default one is created on first use and takes over any state
+// stored under the bare user ID before workspaces existed.
+type Workspaces struct {
+	repo repository.Repository
+	now  func() time.Time
+	// Quotas applies to classifiers opened through Classifier and to
+	// creating workspaces. The zero value sets no limits.
+	Quotas Quotas
+}
+
+func NewWorkspaces(repo repository.Repository) *Workspaces {
+	return &Workspaces{repo: repo, now: time.Now}
+}
+
+// List returns the user's own workspaces followed by those shared with them.
+func (w *Workspaces) List(ctx context.Context, userID string) ([]Access, error) {
+	if _, err := w.Default(ctx, userID); err != nil {
+		return nil, err
+	}
+	owned, err := w.repo.ListWorkspaces(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Access, 0, len(owned))
+	for _, ws := range owned {
+		out = append(out, Access{Workspace: ws, Role: RoleOwner})
+	}
+
+	grants, err := w.repo.ListGrantsFor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range grants {
+		ws, err := w.repo.GetWorkspace(ctx, g.WorkspaceID)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Access{Workspace: ws, Role: Role(g.Role)})
+	}
+	return out, nil
+}
+
+func (w *Workspaces) Create(ctx context.Context, userID, name string) (repository.Workspace, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return repository.Workspace{}, err
+	}
+	// Make sure the first workspace a user creates does not become their
+	// default instead of the one holding their existing state.
+	if _, err := w.Default(ctx, userID); err != nil {
+		return repository.Workspace{}, err
+	}
+	if err := w.checkWorkspaces(ctx, userID); err != nil {
+		return repository.Workspace{}, err
+	}
+	ws := w.newWorkspace(ctx, randomWorkspaceID(), userID, name)
+	if err := w.repo.CreateWorkspace(ctx, ws); err != nil {
+		return repository.Workspace{}, err
+	}
+	return ws, nil
+}
+
+// Get returns the workspace with the user's role in it. Workspaces the user
+// has no access to are reported as not found so their IDs cannot be probed.
+func (w *Workspaces) Get(ctx context.Context, userID, id string) (Access, error) {
+	ws, err := w.repo.GetWorkspace(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return Access{}, ErrWorkspaceNotFound
+	}
+	if err != nil {
+		return Access{}, err
+	}
+	if ws.OwnerID == userID {
+		return Access{Workspace: ws, Role: RoleOwner}, nil
+	}
+	g, err := w.repo.GetGrant(ctx, id, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return Access{}, ErrWorkspaceNotFound
+	}
+	if err != nil {
+		return Access{}, err
+	}
+	return Access{Workspace: ws, Role: Role(g.Role)}, nil
+}
+
+// Resolve picks the workspace a request operates on, the given ID or the
+// user's default workspace when id is empty, and checks that the user's role
+// is at least need.
+func (w *Workspaces) Resolve(ctx context.Context, userID, id string, need Role) (Access, error) {
+	if id == "" {
+		ws, err := w.Default(ctx, userID)
+		if err != nil {
+			return Access{}, err
+		}
+		w.touch(ctx, ws)
+		return Access{Workspace: ws, Role: RoleOwner}, nil
+	}
+	return w.require(ctx, userID, id, need)
+}
+
+func (w *Workspaces) require(ctx context.Context, userID, id string, need Role) (Access, error) {
+	a, err := w.Get(ctx, userID, id)
+	if err != nil {
+		return Access{}, err
+	}
+	if !a.Role.Allows(need) {
+		return Access{}, ErrForbidden
+	}
+	w.touch(ctx, a.Workspace)
+	return a, nil
+}
+
+// touch records that a workspace was opened, so retention keeps one that is
+// only read, as through classify or a share link.
+func (w *Workspaces) touch(ctx context.Context, ws repository.Workspace) {
+	now := w.timestamp()
+	if ws.AccessedAt != nil && now.Sub(*ws.AccessedAt) < workspaceTouchInterval {
+		return
+	}
+	if err := w.repo.TouchWorkspace(ctx, ws.ID, now); err != nil {
+		log.Printf("[workspace=%s] record access: %v", ws.ID, err)
+	}
+}
+
+// Classifier returns the classifier service for a workspace, held to the
+// quotas.
+func (w *Workspaces) Classifier(id string) Service {
+	return w.ClassifierAs(id, Actor{})
+}
+
+// ClassifierAs is Classifier with changes recorded in the audit log as made
+// by actor.
+func (w *Workspaces) ClassifierAs(id string, actor Actor) Service {
+	return &userService{repo: w.repo, workspaceID: id, quotas: w.Quotas, actor: actor}
+}
+
+func (w *Workspaces) Rename(ctx context.Context, userID, id, name string) (repository.Workspace, error) {
+	a, err := w.require(ctx, userID, id, RoleOwner)
+	if err != nil {
+		return repository.Workspace{}, err
+	}
+	if name, err = cleanName(name); err != nil {
+		return repository.Workspace{}, err
+	}
+	ws := a.Workspace
+	ws.Name, ws.UpdatedAt = name, w.timestamp()
+	if err := w.repo.RenameWorkspace(ctx, id, ws.Name, ws.UpdatedAt); err != nil {
+		return repository.Workspace{}, notFound(err)
+	}
+	return ws, nil
+}
+
+// Delete removes a workspace the user owns. Its audit log is kept, ending
+// with an entry for the deletion that records the state it held; the entry
+// is appended first, so a workspace is never deleted without one.
+func (w *Workspaces) Delete(ctx context.Context, userID, id string) error {
+	if _, err := w.require(ctx, userID, id, RoleOwner); err != nil {
+		return err
+	}
+	st, err := w.repo.GetState(ctx, id)
+	if err != nil {
+		return err
+	}
+	svc := &userService{workspaceID: id, actor: Actor{UserID: userID}}
+	audit, err := svc.auditEntry(ctx, auditOp{name: "delete"}, st, repository.State{})
+	if err != nil {
+		return err
+	}
+	if _, err := w.repo.AppendAudit(ctx, *audit); err != nil {
+		return err
+	}
+	return notFound(w.repo.DeleteWorkspace(ctx, id))
+}
+
+// Default returns the user's default workspace, or their oldest one if that
+// was deleted, creating it if the user has none. The default workspace ID is
+// derived from the user ID, so concurrent first requests converge on it.
+func (w *Workspaces) Default(ctx context.Context, userID string) (repository.Workspace, error) {
+	list, err := w.repo.ListWorkspaces(ctx, userID)
+	if err != nil {
+		return repository.Workspace{}, err
+	}
+	id := defaultWorkspaceID(userID)
+	for _, ws := range list {
+		if ws.ID == id {
+			return ws, nil
+		}
+	}
+	if len(list) > 0 {
+		return list[0], nil
+	}
+
+	ws := w.newWorkspace(ctx, id, userID, DefaultWorkspaceName)
+	switch err := w.repo.CreateWorkspace(ctx, ws); {
+	case errors.Is(err, repository.ErrConflict):
+		a, err := w.require(ctx, userID, ws.ID, RoleOwner)
+		return a.Workspace, err
+	case err != nil:
+		return repository.Workspace{}, err
+	}
+	if err := w.adoptLegacyState(ctx, userID, ws.ID); err != nil {
+		return repository.Workspace{}, err
+	}
+	return ws, nil
+}
+
+// adoptLegacyState moves state kept under the bare user ID into the user's
+// first workspace. A user ID that names an existing workspace is someone
+// else's state and is left alone.
+func (w *Workspaces) adoptLegacyState(ctx context.Context, userID, workspaceID string) error {
+	switch _, err := w.repo.GetWorkspace(ctx, userID); {
+	case err == nil:
+		return nil
+	case !errors.Is(err, repository.ErrNotFound):
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err := w.tryAdopt(ctx, userID, workspaceID)
+		if errors.Is(err, repository.ErrStale) && attempt < maxCommitAttempts {
+			continue
+		}
+		return err
+	}
+}
+
+// tryAdopt copies and deletes the legacy state in one commit that only
+// succeeds if the state is unchanged since it was read, so a concurrent
+// writer or adopter cannot be overwritten or have its copy duplicated.
+func (w *Workspaces) tryAdopt(ctx context.Context, userID, workspaceID string) error {
+	st, version, err := w.repo.LoadState(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if st.Class1.Name == "" && st.Class2.Name == "" {
+		return nil
+	}
+	return w.repo.CommitState(ctx, repository.StateCommit{ID: userID, Version: version, State: st, MoveTo: workspaceID})
+}
+
+// AcceptLegacySession reports whether an anonymous session ID read from an
+// unsigned cookie, issued before cookies were signed, may be signed and kept.
+// It is accepted only if the session owns workspaces or state, and only the
+// first time, so a copy of the old cookie stops working once its holder has
+// a signed one.
+func (w *Workspaces) AcceptLegacySession(ctx context.Context, id string) (bool, error) {
+	list, err := w.repo.ListWorkspaces(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if len(list) == 0 {
+		st, err := w.repo.GetState(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		if st.Class1.Name == "" && st.Class2.Name == "" {
+			return false, nil
+		}
+	}
+	sum := sha256.Sum256([]byte("legacy-session:" + id))
+	switch err := w.repo.MarkLegacySession(ctx, hex.EncodeToString(sum[:]), w.timestamp()); {
+	case errors.Is(err, repository.ErrConflict):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+func (w *Workspaces) newWorkspace(ctx context.Context, id, owner, name string) repository.Workspace {
+	now := w.timestamp()
+	return repository.Workspace{ID: id, OwnerID: owner, Name: name, CreatedAt: now, UpdatedAt: now, Anonymous: isAnonymous(ctx)}
+}
+
+// timestamp is truncated to what every backend stores losslessly.
+func (w *Workspaces) timestamp() time.Time {
+	return w.now().UTC().Truncate(time.Microsecond)
+}
+
+func cleanName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxWorkspaceName {
+		return "", ErrInvalidName
+	}
+	return name, nil
+}
+
+func notFound(err error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrWorkspaceNotFound
+	}
+	return err
+}
+
+func randomWorkspaceID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "ws_" + hex.EncodeToString(b)
+}
+
+// defaultWorkspaceID must not reveal the user ID, which doubles as the
+// anonymous session credential.
+func defaultWorkspaceID(userID string) string {
+	sum := sha256.Sum256([]byte("default-workspace:" + userID))
+	return "ws_" + hex.EncodeToString(sum[:12])
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AntonKhPI2/self-learning-classifier/internal/models"
+	"github.com/AntonKhPI2/self-learning-classifier/internal/repository"
+)
+
+func TestWorkspaces_DefaultIsCreatedOnce(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWorkspaces(repository.NewMemory())
+
+	var wg sync.WaitGroup
+	ids := make([]string, 8)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d, err := ws.Default(ctx, "u1")
+			if err != nil {
+				t.Error(err)
+			}
+			ids[i] = d.ID
+		}(i)
+	}
+	wg.Wait()
+	for _, id := range ids[1:] {
+		if id != ids[0] {
+			t.Fatalf("default workspace ids differ: %v", ids)
+		}
+	}
+	if strings.Contains(ids[0], "u1") {
+		t.Fatalf("default workspace id %q leaks the user id", ids[0])
+	}
+	list, _ := ws.List(ctx, "u1")
+	if len(list) != 1 || list[0].Name != DefaultWorkspaceName {
+		t.Fatalf("list=%+v", list)
+	}
+}
+
+func TestWorkspaces_LegacyAdoptionSkipsWorkspaceIDs(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	ws := NewWorkspaces(repo)
+
+	victim, err := ws.Create(ctx, "victim", "private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := repository.State{Class1: models.Class{Name: "Secret"}, Class2: models.Class{Name: "Other"}}
+	if err := repo.UpsertState(ctx, victim.ID, st); err != nil {
+		t.Fatal(err)
+	}
+
+	// A caller whose user ID equals the victim's workspace ID must not adopt it.
+	d, err := ws.Default(ctx, victim.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := repo.GetState(ctx, d.ID)
+	if got.Class1.Name != "" {
+		t.Fatalf("foreign workspace state adopted: %+v", got)
+	}
+	kept, _ := repo.GetState(ctx, victim.ID)
+	if kept.Class1.Name != "Secret" {
+		t.Fatalf("victim state changed: %+v", kept)
+	}
+}
+
+// racingRepo writes the legacy state once, right after the first LoadState
+// of it, as a concurrent request would.
+type racingRepo struct {
+	*repository.MemoryRepo
+	once  sync.Once
+	write repository.State
+}
+
+func (r *racingRepo) LoadState(ctx context.Context, id string) (repository.State, int64, error) {
+	st, v, err := r.MemoryRepo.LoadState(ctx, id)
+	r.once.Do(func() { _ = r.UpsertState(ctx, id, r.write) })
+	return st, v, err
+}
+
+func TestWorkspaces_LegacyAdoptionKeepsConcurrentWrite(t *testing.T) {
+	ctx := context.Background()
+	newer := repository.State{Class1: models.Class{Name: "Spam", Properties: []string{"newer"}}, Class2: models.Class{Name: "Ham"}}
+	repo := &racingRepo{MemoryRepo: repository.NewMemory(), write: newer}
+	if err := repo.UpsertState(ctx, "u1", repository.State{Class1: models.Class{Name: "Spam"}, Class2: models.Class{Name: "Ham"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewWorkspaces(repo).Default(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := repo.GetState(ctx, d.ID)
+	if !slices.Equal(got.Class1.Properties, []string{"newer"}) {
+		t.Fatalf("adopted state=%+v; want the concurrent write", got)
+	}
+	if legacy, _ := repo.GetState(ctx, "u1"); legacy.Class1.Name != "" {
+		t.Fatalf("legacy state left behind: %+v", legacy)
+	}
+}
+
+func TestWorkspaces_OwnershipAndNames(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWorkspaces(repository.NewMemory())
+
+	if _, err := ws.Create(ctx, "u1", strings.Repeat("x", 101)); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("long name: err=%v", err)
+	}
+	w, err := ws.Create(ctx, "u1", "  spam vs ham  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Name != "spam vs ham" {
+		t.Fatalf("name not trimmed: %q", w.Name)
+	}
+	if _, err := ws.Resolve(ctx, "u2", w.ID, RoleViewer); !errors.Is(err, ErrWorkspaceNotFound) {
+		t.Fatalf("foreign resolve: err=%v", err)
+	}
+	if err := ws.Delete(ctx, "u2", w.ID); !errors.Is(err, ErrWorkspaceNotFound) {
+		t.Fatalf("foreign delete: err=%v", err)
+	}
+	if err := ws.Delete(ctx, "u1", w.ID); err != nil {
+		t.Fatal(err)
+	}
+}
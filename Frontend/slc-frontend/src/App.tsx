@@ -8,28 +8,13 @@ type Snapshot = {
 };
 type ClassifyAny = Record<string, any>;
 
-const API = import.meta.env.VITE_API_URL || "http://localhost:8080";
+// Empty means same origin: the Vite dev server and Nginx both proxy /api.
+const API = import.meta.env.VITE_API_URL ?? "";
 const V1 = `${API}/api/v1`;
 
-function secureRandomString(length: number) {
-    const bytes = new Uint8Array(length);
-    window.crypto.getRandomValues(bytes);
-    return Array.from(bytes, byte => byte.toString(36)).join('');
-}
-
-const UID = (() => {
-    const k = "slc_uid";
-    let v = localStorage.getItem(k);
-    if (!v) {
-        v = secureRandomString(12) + Date.now().toString(36);
-        localStorage.setItem(k, v);
-    }
-    return v;
-})();
+// The backend identifies anonymous visitors by its slc_uid cookie.
 async function api(path: string, init?: RequestInit) {
-    const h = new Headers(init?.headers || {});
-    h.set("X-User-ID", UID);
-    return fetch(`${V1}${path}`, { ...init, headers: h });
+    return fetch(`${V1}${path}`, { ...init, credentials: "include" });
 }
 
 const uniqSorted = (arr: string[]) => Array.from(new Set(arr)).sort((a, b) => a.localeCompare(b));